@@ -0,0 +1,299 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+)
+
+var (
+	addrIndexPrefix = []byte("addr-")     // pubKeyHash, height, txid, kind, index -> AddressEntry
+	addrIndexFlag   = []byte("addrindex") // present when the address index is enabled
+
+	// errNotIndexed is returned for outputs of non standard scripts, which
+	// have no address to index them under
+	errNotIndexed = errors.New("Spent output is not in the address index")
+
+	ErrAddrIndexDisabled = errors.New("Address index is not enabled")
+)
+
+const (
+	entryFunded = byte(0)
+	entrySpent  = byte(1)
+)
+
+type AddressEntry struct {
+	TxId      []byte // transaction funding or spending the address
+	Index     int    // output index when funded, input index when spent
+	Height    int    // height of the block containing the transaction
+	Value     int    // value of the output funded or spent
+	Spent     bool   // true if the entry spends an output of the address
+	PrevTxId  []byte // when spent, the transaction of the spent output
+	PrevIndex int    // when spent, the index of the spent output
+}
+
+func addrIndexKey(pubKeyHash []byte, height int, txId []byte, kind byte, index int) []byte {
+	key := append([]byte{}, addrIndexPrefix...)
+	key = append(key, pubKeyHash...)
+	key = append(key, ToHex(int64(height))...)
+	key = append(key, txId...)
+	key = append(key, kind)
+	return append(key, ToHex(int64(index))...)
+}
+
+func addrPrefix(pubKeyHash []byte) []byte {
+	key := append([]byte{}, addrIndexPrefix...)
+	return append(key, pubKeyHash...)
+}
+
+func (e AddressEntry) Serialize() []byte {
+	var res bytes.Buffer
+	encoder := gob.NewEncoder(&res)
+	err := encoder.Encode(e)
+	Handle(err)
+	return res.Bytes()
+}
+
+func DeserializeAddressEntry(data []byte) AddressEntry {
+	var e AddressEntry
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	err := decoder.Decode(&e)
+	Handle(err)
+	return e
+}
+
+func addrIndexEnabled(txn StoreTxn) bool {
+	return hasKey(txn, addrIndexFlag)
+}
+
+func addressEntries(txn StoreTxn, pubKeyHash []byte) ([]AddressEntry, error) {
+	var entries []AddressEntry
+	err := txn.Iterate(addrPrefix(pubKeyHash), nil, func(_, v []byte) error {
+		entries = append(entries, DeserializeAddressEntry(v))
+		return nil
+	})
+	return entries, err
+}
+
+func findFundingEntry(txn StoreTxn, pubKeyHash []byte, txId []byte, index int) (AddressEntry, error) {
+	entries, err := addressEntries(txn, pubKeyHash)
+	if err != nil {
+		return AddressEntry{}, err
+	}
+	for _, e := range entries {
+		if !e.Spent && e.Index == index && bytes.Equal(e.TxId, txId) {
+			return e, nil
+		}
+	}
+	return AddressEntry{}, errNotIndexed
+}
+
+func indexAddresses(txn StoreTxn, block *Block) error {
+	for _, tx := range block.Transactions {
+		if !tx.IsCoinbase() {
+			for inIdx, in := range tx.Inputs {
+				pubKeyHash := in.LockingHash()
+				funding, err := findFundingEntry(txn, pubKeyHash, in.Id, in.OutIndex)
+				if err == errNotIndexed {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				entry := AddressEntry{tx.Id, inIdx, block.Height, funding.Value, true, in.Id, in.OutIndex}
+				key := addrIndexKey(pubKeyHash, block.Height, tx.Id, entrySpent, inIdx)
+				if err := txn.Set(key, entry.Serialize()); err != nil {
+					return err
+				}
+			}
+		}
+		for outIdx, out := range tx.Outputs {
+			if out.AddressHash() == nil {
+				continue
+			}
+			entry := AddressEntry{tx.Id, outIdx, block.Height, out.Value, false, nil, 0}
+			key := addrIndexKey(out.AddressHash(), block.Height, tx.Id, entryFunded, outIdx)
+			if err := txn.Set(key, entry.Serialize()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func unindexAddresses(txn StoreTxn, block *Block) error {
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		tx := block.Transactions[i]
+		for outIdx, out := range tx.Outputs {
+			if out.AddressHash() == nil {
+				continue
+			}
+			key := addrIndexKey(out.AddressHash(), block.Height, tx.Id, entryFunded, outIdx)
+			if err := txn.Delete(key); err != nil {
+				return err
+			}
+		}
+		if tx.IsCoinbase() {
+			continue
+		}
+		for inIdx, in := range tx.Inputs {
+			key := addrIndexKey(in.LockingHash(), block.Height, tx.Id, entrySpent, inIdx)
+			if err := txn.Delete(key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// AddrIndexEnabled reports whether the chain maintains an address index
+func (chain *BlockChain) AddrIndexEnabled() bool {
+	var enabled bool
+	err := chain.Database.View(func(txn StoreTxn) error {
+		enabled = addrIndexEnabled(txn)
+		return nil
+	})
+	Handle(err)
+	return enabled
+}
+
+// ReIndexAddresses rebuilds the address index from the main chain and
+// enables it, so that it is kept up to date as blocks are connected
+func (chain *BlockChain) ReIndexAddresses() {
+	utxo := UTXOSet{chain}
+	utxo.DeleteByPrefix(addrIndexPrefix)
+
+	iter := chain.IteratorFrom(0)
+	for {
+		block := iter.Next()
+		if block == nil {
+			break
+		}
+		err := chain.Database.Update(func(txn StoreTxn) error {
+			return indexAddresses(txn, block)
+		})
+		Handle(err)
+	}
+
+	err := chain.Database.Update(func(txn StoreTxn) error {
+		return txn.Set(addrIndexFlag, []byte{1})
+	})
+	Handle(err)
+}
+
+// GetAddressHistory returns every main chain output funding the address and
+// every input spending from it, ordered by height
+func (chain *BlockChain) GetAddressHistory(pubKeyHash []byte) ([]AddressEntry, error) {
+	var entries []AddressEntry
+	err := chain.Database.View(func(txn StoreTxn) error {
+		if !addrIndexEnabled(txn) {
+			return ErrAddrIndexDisabled
+		}
+		var err error
+		entries, err = addressEntries(txn, pubKeyHash)
+		return err
+	})
+	return entries, err
+}
+
+// GetAddressUnspent returns the funding entries of the address that have not been spent
+func (chain *BlockChain) GetAddressUnspent(pubKeyHash []byte) ([]AddressEntry, error) {
+	entries, err := chain.GetAddressHistory(pubKeyHash)
+	if err != nil {
+		return nil, err
+	}
+
+	spent := make(map[string]bool)
+	for _, e := range entries {
+		if e.Spent {
+			spent[outpointKey(e.PrevTxId, e.PrevIndex)] = true
+		}
+	}
+
+	var unspent []AddressEntry
+	for _, e := range entries {
+		if !e.Spent && !spent[outpointKey(e.TxId, e.Index)] {
+			unspent = append(unspent, e)
+		}
+	}
+	return unspent, nil
+}
+
+func outpointKey(txId []byte, index int) string {
+	return string(append(append([]byte{}, txId...), ToHex(int64(index))...))
+}
+
+// UsedPubKeyHashes returns the pubKeyHash of every address that received
+// an output on the main chain, from the address index when it is enabled
+func (chain *BlockChain) UsedPubKeyHashes() map[string]bool {
+	used := make(map[string]bool)
+	indexed := false
+	err := chain.Database.View(func(txn StoreTxn) error {
+		if indexed = addrIndexEnabled(txn); !indexed {
+			return nil
+		}
+		return txn.Iterate(addrIndexPrefix, nil, func(k, v []byte) error {
+			e := DeserializeAddressEntry(v)
+			if !e.Spent {
+				// the key ends with the height, txid, kind and index
+				end := len(k) - 8 - len(e.TxId) - 1 - 8
+				used[string(k[len(addrIndexPrefix):end])] = true
+			}
+			return nil
+		})
+	})
+	Handle(err)
+	if indexed {
+		return used
+	}
+
+	iter := chain.IteratorFrom(0)
+	for {
+		block := iter.Next()
+		if block == nil {
+			break
+		}
+		for _, tx := range block.Transactions {
+			for _, out := range tx.Outputs {
+				used[string(out.AddressHash())] = true
+			}
+		}
+	}
+	return used
+}
+
+// ScanAddress returns the history of an address like GetAddressHistory,
+// walking the main chain when the address index is not enabled
+func (chain *BlockChain) ScanAddress(pubKeyHash []byte) ([]AddressEntry, error) {
+	entries, err := chain.GetAddressHistory(pubKeyHash)
+	if !errors.Is(err, ErrAddrIndexDisabled) {
+		return entries, err
+	}
+
+	entries = nil
+	funded := make(map[string]int)
+	iter := chain.IteratorFrom(0)
+	for {
+		block := iter.Next()
+		if block == nil {
+			break
+		}
+		for _, tx := range block.Transactions {
+			if !tx.IsCoinbase() {
+				for inIdx, in := range tx.Inputs {
+					value, ok := funded[outpointKey(in.Id, in.OutIndex)]
+					if ok && in.CanUseKey(pubKeyHash) {
+						entries = append(entries, AddressEntry{tx.Id, inIdx, block.Height, value, true, in.Id, in.OutIndex})
+					}
+				}
+			}
+			for outIdx, out := range tx.Outputs {
+				if out.IsLockedWithKey(pubKeyHash) {
+					funded[outpointKey(tx.Id, outIdx)] = out.Value
+					entries = append(entries, AddressEntry{tx.Id, outIdx, block.Height, out.Value, false, nil, 0})
+				}
+			}
+		}
+	}
+	return entries, nil
+}
@@ -2,7 +2,9 @@ package blockchain
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/gob"
+	"io"
 	"log"
 	"time"
 )
@@ -14,22 +16,46 @@ type Block struct {
 	PrevHash     []byte
 	Height       int
 	Nonce        int
+	Signer       []byte // public key of the proof of authority or proof of stake signer
+	Signature    []byte
 }
 
-func CreateBlock(txs []*Transaction, prevHash []byte, height int) (b *Block) {
-	newBlock := &Block{time.Now().Unix(), []byte{}, txs, prevHash, height, 0}
-	pow := InitPow(newBlock)
+// BlockHeader is what engines sealing blocks with a signature sign, it
+// commits to the transactions through their merkle root
+type BlockHeader struct {
+	PrevHash   []byte
+	MerkleRoot []byte
+	Height     int
+	Timstamp   int64
+	Nonce      int
+	Signer     []byte
+}
 
-	nonce, hash := pow.Run()
-	newBlock.Nonce = nonce
-	newBlock.Hash = hash[:]
+func (b *Block) Header() BlockHeader {
+	return BlockHeader{b.PrevHash, b.HashTransactions(), b.Height, b.Timstamp, b.Nonce, b.Signer}
+}
 
-	return newBlock
+func (h BlockHeader) Hash() []byte {
+	data := bytes.Join([][]byte{h.PrevHash, h.MerkleRoot, ToHex(int64(h.Height)), ToHex(h.Timstamp),
+		ToHex(int64(h.Nonce)), h.Signer}, []byte{})
+	hash := sha256.Sum256(data)
+	return hash[:]
 }
 
-func Genesis(coinbase *Transaction) (b *Block) {
-	newBlock := CreateBlock([]*Transaction{coinbase}, []byte{}, 0)
-	return newBlock
+// CreateBlock prepares and seals a new block with the consensus engine
+func CreateBlock(engine Consensus, txs []*Transaction, prevHash []byte, height int) (*Block, error) {
+	newBlock := &Block{time.Now().Unix(), []byte{}, txs, prevHash, height, 0, nil, nil}
+	if err := engine.Prepare(newBlock); err != nil {
+		return nil, err
+	}
+	if err := engine.Seal(newBlock); err != nil {
+		return nil, err
+	}
+	return newBlock, nil
+}
+
+func Genesis(engine Consensus, coinbase *Transaction) (*Block, error) {
+	return CreateBlock(engine, []*Transaction{coinbase}, []byte{}, 0)
 }
 
 func (b *Block) HashTransactions() []byte {
@@ -59,6 +85,14 @@ func Deserialize(data []byte) *Block {
 	return &res
 }
 
+// gob numbers types in the order a process first encodes them, so the
+// encoding of a transaction, and its hash, would differ between nodes.
+// Encoding a block first gives the block types the same numbers everywhere.
+func init() {
+	err := gob.NewEncoder(io.Discard).Encode(Block{})
+	Handle(err)
+}
+
 func Handle(err error) {
 	if err != nil {
 		log.Panic(err)
@@ -7,23 +7,37 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"log"
-	"os"
-	"path/filepath"
 	"runtime"
-	"strings"
 
-	"github.com/dgraph-io/badger"
+	"github.com/Harshjha3006/golang-blockchain/wallet"
 )
 
 type BlockChain struct {
 	LastHash []byte
-	Database *badger.DB
+	Database Store
+	Engine   Consensus
+
+	listeners []BlockListener
+}
+
+// BlockListener is told about blocks joining and leaving the main chain,
+// after the change has been committed
+type BlockListener interface {
+	BlockConnected(block *Block)
+	BlockDisconnected(block *Block)
+}
+
+// chainUpdate lists the new tip, if any, and the blocks a stored block
+// disconnected and connected
+type chainUpdate struct {
+	tip       []byte
+	detached  []*Block
+	connected []*Block
 }
 
 type BlockChainIterator struct {
 	CurrentHash []byte
-	Database    *badger.DB
+	Database    Store
 }
 
 const (
@@ -31,74 +45,179 @@ const (
 	genesisData = "First Transaction from Genesis"
 )
 
-func (chain *BlockChain) AddBlock(block *Block) {
-	err := chain.Database.Update(func(txn *badger.Txn) error {
-		if _, err := txn.Get(block.Hash); err == nil {
+var (
+	blockPrefix = []byte("b-") // block hash -> serialized block
+	lastHashKey = []byte("lh") // hash of the tip of the main chain
+)
+
+func blockKey(hash []byte) []byte {
+	key := append([]byte{}, blockPrefix...)
+	return append(key, hash...)
+}
+
+func hasBlock(txn StoreTxn, hash []byte) bool {
+	return hasKey(txn, blockKey(hash)) || hasKey(txn, hash)
+}
+
+func getBlock(txn StoreTxn, hash []byte) (*Block, error) {
+	blockData, err := txn.Get(blockKey(hash))
+	if err == ErrKeyNotFound {
+		// blocks written before the store was introduced are keyed by their bare hash
+		blockData, err = txn.Get(hash)
+	}
+	if err != nil {
+		return nil, errors.New("Block not found")
+	}
+	return Deserialize(blockData), nil
+}
+
+func putBlock(txn StoreTxn, block *Block) error {
+	return txn.Set(blockKey(block.Hash), block.Serialize())
+}
+
+func getLastHash(txn StoreTxn) ([]byte, error) {
+	return txn.Get(lastHashKey)
+}
+
+func setLastHash(txn StoreTxn, hash []byte) error {
+	return txn.Set(lastHashKey, hash)
+}
+
+func getTip(txn StoreTxn) (*Block, error) {
+	lastHash, err := getLastHash(txn)
+	if err != nil {
+		return nil, err
+	}
+	return getBlock(txn, lastHash)
+}
+
+// AddBlock stores a block received from a peer and, if it extends a heavier
+// chain than the current one, connects it. Storing the block, moving the
+// tip and updating the UTXO set and indexes happen in a single transaction.
+func (chain *BlockChain) AddBlock(block *Block) error {
+	if err := chain.Engine.VerifySeal(block); err != nil {
+		return err
+	}
+	var update chainUpdate
+	err := chain.Database.Update(func(txn StoreTxn) error {
+		if hasBlock(txn, block.Hash) {
 			return nil
 		}
+		var err error
+		update, err = chain.storeBlock(txn, block)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	chain.commit(update)
+	return nil
+}
 
-		blockData := block.Serialize()
-		err := txn.Set(block.Hash, blockData)
-		Handle(err)
+func (chain *BlockChain) storeBlock(txn StoreTxn, block *Block) (chainUpdate, error) {
+	var update chainUpdate
+	if err := putBlock(txn, block); err != nil {
+		return update, err
+	}
+	if err := recordSignature(txn, block); err != nil {
+		return update, err
+	}
 
-		item, err := txn.Get([]byte("lh"))
-		Handle(err)
-		lastHash, err := item.Value()
-		Handle(err)
+	lastBlock, err := getTip(txn)
+	if err != nil {
+		return update, err
+	}
+	if len(block.PrevHash) > 0 && !hasBlock(txn, block.PrevHash) {
+		fmt.Printf("Parent of block %x is unknown, not extending the chain\n", block.Hash)
+		return update, nil
+	}
+	weight, err := chain.setWeight(txn, block)
+	if err != nil {
+		return update, err
+	}
 
-		item, err = txn.Get(lastHash)
-		Handle(err)
-		lastBlockData, err := item.Value()
-		Handle(err)
+	if weight > getWeight(txn, lastBlock) {
+		update.detached, update.connected, err = reorganize(txn, lastBlock, block)
+		if err != nil {
+			return update, err
+		}
+		if err := setLastHash(txn, block.Hash); err != nil {
+			return update, err
+		}
+		update.tip = block.Hash
+	}
+	return update, nil
+}
 
-		lastBlock := *Deserialize(lastBlockData)
+// Subscribe registers a listener for blocks connected and disconnected by
+// AddBlock and MineBlock
+func (chain *BlockChain) Subscribe(listener BlockListener) {
+	chain.listeners = append(chain.listeners, listener)
+}
 
-		if block.Height > lastBlock.Height {
-			err = txn.Set([]byte("lh"), block.Hash)
-			Handle(err)
-			chain.LastHash = block.Hash
+// commit moves the in memory tip once the transaction storing a block has
+// succeeded and notifies the listeners
+func (chain *BlockChain) commit(update chainUpdate) {
+	if update.tip != nil {
+		chain.LastHash = update.tip
+	}
+	chain.notify(update)
+}
+
+func (chain *BlockChain) notify(update chainUpdate) {
+	for _, listener := range chain.listeners {
+		for _, block := range update.detached {
+			listener.BlockDisconnected(block)
 		}
-		return nil
-	})
-	Handle(err)
+		for _, block := range update.connected {
+			listener.BlockConnected(block)
+		}
+	}
 }
-func (chain *BlockChain) MineBlock(txs []*Transaction) *Block {
+
+// MineBlock seals a block of transactions on top of the tip with the
+// consensus engine of the chain and connects it
+func (chain *BlockChain) MineBlock(txs []*Transaction) (*Block, error) {
 	var lastHash []byte
 	var lastHeight int
-	err := chain.Database.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte("lh"))
-		Handle(err)
-		lastHash, err = item.Value()
-		Handle(err)
-		item, err = txn.Get(lastHash)
-		Handle(err)
-		blockData, err := item.Value()
-		block := *Deserialize(blockData)
+	err := chain.Database.View(func(txn StoreTxn) error {
+		block, err := getTip(txn)
+		if err != nil {
+			return err
+		}
+		lastHash = block.Hash
 		lastHeight = block.Height
-		return err
+		return nil
 	})
-	Handle(err)
-	newBlock := CreateBlock(txs, lastHash, lastHeight+1)
+	if err != nil {
+		return nil, err
+	}
+	newBlock, err := CreateBlock(chain.Engine, txs, lastHash, lastHeight+1)
+	if err != nil {
+		return nil, err
+	}
 
-	err = chain.Database.Update(func(txn *badger.Txn) error {
-		err = txn.Set([]byte("lh"), newBlock.Hash)
-		Handle(err)
-		err = txn.Set(newBlock.Hash, newBlock.Serialize())
+	var update chainUpdate
+	err = chain.Database.Update(func(txn StoreTxn) error {
+		var err error
+		update, err = chain.storeBlock(txn, newBlock)
 		return err
 	})
-	Handle(err)
-	return newBlock
+	if err != nil {
+		return nil, err
+	}
+	chain.commit(update)
+	return newBlock, nil
 }
 
 func (chain *BlockChain) GetBlock(blockHash []byte) (Block, error) {
 	var block Block
-	err := chain.Database.View(func(txn *badger.Txn) error {
-		if item, err := txn.Get(blockHash); err != nil {
-			return errors.New("Block not found")
-		} else {
-			blockData, _ := item.Value()
-			block = *Deserialize(blockData)
+	err := chain.Database.View(func(txn StoreTxn) error {
+		b, err := getBlock(txn, blockHash)
+		if err != nil {
+			return err
 		}
+		block = *b
 		return nil
 	})
 	if err != nil {
@@ -122,84 +241,126 @@ func (chain *BlockChain) GetBlockHashes() [][]byte {
 }
 
 func (chain *BlockChain) GetBestHeight() int {
-	var block Block
-	err := chain.Database.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte("lh"))
-		Handle(err)
-		lastHash, err := item.Value()
-		Handle(err)
-		item, err = txn.Get(lastHash)
-		Handle(err)
-		blockData, err := item.Value()
-		block = *Deserialize(blockData)
+	var block *Block
+	err := chain.Database.View(func(txn StoreTxn) error {
+		var err error
+		block, err = getTip(txn)
 		return err
 	})
 	Handle(err)
 	return block.Height
 }
 
-func DbExists(path string) bool {
-	if _, err := os.Stat(path + "/MANIFEST"); os.IsNotExist(err) {
-		return false
-	}
-	return true
-}
-
 func ContinueBlockChain(nodeId string) *BlockChain {
-
-	path := fmt.Sprintf(dbPath, nodeId)
-	if !DbExists(path) {
+	cfg := DefaultStoreConfig(nodeId)
+	if !StoreExists(cfg) {
 		fmt.Println("No BlockChain found, Create one")
 		runtime.Goexit()
 	}
 
-	var lastHash []byte
-	opts := badger.DefaultOptions
-	opts.Dir = path
-	opts.ValueDir = path
-
-	db, err := openDb(path, opts)
+	store, err := OpenStore(cfg)
 	Handle(err)
+	return ContinueBlockChainWithStore(store, NodeKeys(nodeId))
+}
 
-	err = db.Update(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte("lh"))
-		Handle(err)
-		lastHash, err = item.Value()
+// ContinueBlockChainWithStore loads the chain kept in an already opened
+// store, sealing blocks with keys from keys when its engine signs them
+func ContinueBlockChainWithStore(store Store, keys KeySource) *BlockChain {
+	var lastHash []byte
+	var params ChainParams
+	err := store.View(func(txn StoreTxn) error {
+		var err error
+		lastHash, err = getLastHash(txn)
+		params = getParams(txn)
 		return err
 	})
 	Handle(err)
-	return &BlockChain{lastHash, db}
-}
+	engine, err := NewConsensus(params, store, keys)
+	Handle(err)
 
-func InitBlockChain(address string, nodeId string) *BlockChain {
+	chain := &BlockChain{LastHash: lastHash, Database: store, Engine: engine}
+	if !chain.hasHeightIndex() {
+		fmt.Println("Building height index")
+		chain.ReIndexHeights()
+	}
+	utxo := UTXOSet{chain}
+	if !utxo.IsConsistent() {
+		fmt.Println("UTXO set does not match the chain tip, rebuilding it")
+		utxo.ReIndex()
+	}
+	return chain
+}
 
-	path := fmt.Sprintf(dbPath, nodeId)
-	if DbExists(path) {
+func InitBlockChain(address string, nodeId string, params ChainParams, txIndex, addrIndex bool) *BlockChain {
+	cfg := DefaultStoreConfig(nodeId)
+	if StoreExists(cfg) {
 		fmt.Println("BlockChain already exists")
 		runtime.Goexit()
 	}
+	keys := NodeKeys(nodeId)
+	params.Rules = CurrentRules
+	genesis, err := newGenesis(params, keys, address)
+	if err != nil {
+		fmt.Println("Error:", err)
+		runtime.Goexit()
+	}
 
-	var lastHash []byte
-	opts := badger.DefaultOptions
-	opts.Dir = path
-	opts.ValueDir = path
-
-	db, err := openDb(path, opts)
+	store, err := OpenStore(cfg)
 	Handle(err)
+	return initStore(store, params, keys, genesis, txIndex, addrIndex)
+}
 
-	err = db.Update(func(txn *badger.Txn) error {
-		coinbase := CoinbaseTx(address, genesisData)
-		genesis := Genesis(coinbase)
-		fmt.Println("Genesis Block Created")
-		err = txn.Set(genesis.Hash, genesis.Serialize())
+// InitBlockChainWithStore creates a new chain in an empty store
+func InitBlockChainWithStore(store Store, params ChainParams, keys KeySource, address string, txIndex, addrIndex bool) (*BlockChain, error) {
+	params.Rules = CurrentRules
+	genesis, err := newGenesis(params, keys, address)
+	if err != nil {
+		return nil, err
+	}
+	return initStore(store, params, keys, genesis, txIndex, addrIndex), nil
+}
+
+// newGenesis seals the genesis block paying address with the engine
+// selected by params
+func newGenesis(params ChainParams, keys KeySource, address string) (*Block, error) {
+	engine, err := NewConsensus(params, nil, keys)
+	if err != nil {
+		return nil, err
+	}
+	genesis, err := Genesis(engine, CoinbaseTx(address, genesisData))
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("Genesis Block Created")
+	return genesis, nil
+}
+
+func initStore(store Store, params ChainParams, keys KeySource, genesis *Block, txIndex, addrIndex bool) *BlockChain {
+	engine, err := NewConsensus(params, store, keys)
+	Handle(err)
+	chain := &BlockChain{LastHash: genesis.Hash, Database: store, Engine: engine}
+	err = store.Update(func(txn StoreTxn) error {
+		err := txn.Set(paramsKey, params.Serialize())
 		Handle(err)
-		err = txn.Set([]byte("lh"), genesis.Hash)
-		lastHash = genesis.Hash
-		return err
+		if txIndex {
+			err := txn.Set(txIndexFlag, []byte{1})
+			Handle(err)
+		}
+		if addrIndex {
+			err := txn.Set(addrIndexFlag, []byte{1})
+			Handle(err)
+		}
+		err = putBlock(txn, genesis)
+		Handle(err)
+		_, err = chain.setWeight(txn, genesis)
+		Handle(err)
+		err = setLastHash(txn, genesis.Hash)
+		Handle(err)
+		return connectBlock(txn, genesis)
 
 	})
 	Handle(err)
-	return &BlockChain{lastHash, db}
+	return chain
 }
 
 func (chain *BlockChain) Iterator() *BlockChainIterator {
@@ -209,11 +370,9 @@ func (chain *BlockChain) Iterator() *BlockChainIterator {
 func (iter *BlockChainIterator) Next() *Block {
 	var block *Block
 
-	err := iter.Database.View(func(txn *badger.Txn) error {
-		item, err := txn.Get(iter.CurrentHash)
-		Handle(err)
-		encodedBlock, err := item.Value()
-		block = Deserialize(encodedBlock)
+	err := iter.Database.View(func(txn StoreTxn) error {
+		var err error
+		block, err = getBlock(txn, iter.CurrentHash)
 		return err
 	})
 	Handle(err)
@@ -221,48 +380,22 @@ func (iter *BlockChainIterator) Next() *Block {
 	return block
 }
 
-func (chain *BlockChain) FindUTXO() map[string]TxOutputs {
-	utxo := make(map[string]TxOutputs)
-	spentTxos := make(map[string][]int)
-
-	iter := chain.Iterator()
-
-	for {
-
-		block := iter.Next()
-
-		for _, tx := range block.Transactions {
-			txId := hex.EncodeToString(tx.Id)
-
-		Outputs:
-			for outId, out := range tx.Outputs {
-				if spentTxos[txId] != nil {
-					for _, spentOut := range spentTxos[txId] {
-						if spentOut == outId {
-							continue Outputs
-						}
-					}
-				}
-				outs := utxo[txId]
-				outs.Outputs = append(outs.Outputs, out)
-				utxo[txId] = outs
-			}
-			if !tx.isCoinbase() {
-				for _, in := range tx.Inputs {
-					inTxid := hex.EncodeToString(in.Id)
-					spentTxos[inTxid] = append(spentTxos[inTxid], in.OutIndex)
-				}
-			}
-		}
-		if len(block.PrevHash) == 0 {
-			break
+func (chain *BlockChain) FindTransaction(ID []byte) (Transaction, error) {
+	var tx Transaction
+	indexed := false
+	err := chain.Database.View(func(txn StoreTxn) error {
+		if !txIndexEnabled(txn) {
+			return nil
 		}
+		indexed = true
+		var err error
+		tx, err = findIndexedTransaction(txn, ID)
+		return err
+	})
+	if indexed {
+		return tx, err
 	}
-	return utxo
 
-}
-
-func (chain *BlockChain) FindTransaction(ID []byte) (Transaction, error) {
 	iter := chain.Iterator()
 
 	for {
@@ -291,10 +424,9 @@ func (chain *BlockChain) SignTransaction(private ecdsa.PrivateKey, tx *Transacti
 	tx.Sign(private, prevTxs)
 }
 
-func (chain *BlockChain) VerifyTransaction(tx *Transaction) bool {
-	if tx.isCoinbase() {
-		return true
-	}
+// SignTransactionWithKeys signs every input with the wallet whose pubkey
+// hash locks the output it spends, keys being indexed by pubkey hash
+func (chain *BlockChain) SignTransactionWithKeys(tx *Transaction, keys map[string]*wallet.Wallet) {
 	prevTxs := make(map[string]Transaction)
 
 	for _, in := range tx.Inputs {
@@ -302,7 +434,44 @@ func (chain *BlockChain) VerifyTransaction(tx *Transaction) bool {
 		Handle(err)
 		prevTxs[hex.EncodeToString(inTx.Id)] = inTx
 	}
-	return tx.Verify(prevTxs)
+	for inId, in := range tx.Inputs {
+		prevOut := prevTxs[hex.EncodeToString(in.Id)].Outputs[in.OutIndex]
+		if w := keys[string(prevOut.AddressHash())]; w != nil {
+			tx.signWith(inId, w, prevOut)
+		}
+	}
+}
+
+// VerifyTransaction checks a transaction against the main chain, failing
+// when an output it spends is unknown
+func (chain *BlockChain) VerifyTransaction(tx *Transaction) error {
+	if tx.IsCoinbase() {
+		return nil
+	}
+	var prevOuts []TxOutput
+	for _, in := range tx.Inputs {
+		inTx, err := chain.FindTransaction(in.Id)
+		if err != nil {
+			return fmt.Errorf("input %x:%d: %w", in.Id, in.OutIndex, err)
+		}
+		if in.OutIndex < 0 || in.OutIndex >= len(inTx.Outputs) {
+			return fmt.Errorf("input %x:%d spends an output that does not exist", in.Id, in.OutIndex)
+		}
+		prevOuts = append(prevOuts, inTx.Outputs[in.OutIndex])
+	}
+	if err := tx.verifySpend(prevOuts); err != nil {
+		return err
+	}
+	if err := tx.checkTokenBalance(prevOuts); err != nil {
+		return err
+	}
+	if err := chain.CheckIssuance(tx); err != nil {
+		return err
+	}
+	if err := chain.CheckNameOperation(tx); err != nil {
+		return err
+	}
+	return chain.CheckStakeOperation(tx)
 }
 
 func DeserializeTransaction(data []byte) Transaction {
@@ -315,31 +484,3 @@ func DeserializeTransaction(data []byte) Transaction {
 	}
 	return txn
 }
-
-func retry(dir string, opts badger.Options) (*badger.DB, error) {
-	lockPath := filepath.Join(dir, "LOCK")
-	if err := os.Remove(lockPath); err != nil {
-		return nil, fmt.Errorf("error in removing lock file : %s", err)
-	}
-	retryOpts := opts
-	retryOpts.Truncate = true
-	db, err := badger.Open(retryOpts)
-	return db, err
-}
-
-func openDb(dir string, opts badger.Options) (*badger.DB, error) {
-	if db, err := badger.Open(opts); err != nil {
-		if strings.Contains(err.Error(), "LOCK") {
-			if db, err := retry(dir, opts); err == nil {
-				log.Println("Database is unlocked,Value log truncated")
-				return db, nil
-			} else {
-				log.Println("Database could not be unlocked : ", err)
-			}
-		}
-		return nil, err
-	} else {
-		return db, nil
-	}
-
-}
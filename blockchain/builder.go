@@ -0,0 +1,371 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/Harshjha3006/golang-blockchain/script"
+	"github.com/Harshjha3006/golang-blockchain/wallet"
+)
+
+// TxBuilder assembles a transaction paying any number of recipients, funded
+// by coins of the wallets added as signers
+type TxBuilder struct {
+	Outputs  []TxOutput
+	Coins    []UnspentOutput // coins to spend, selected with Strategy when empty
+	Strategy string
+	// ChangeAddress is called for the address of the change output, only
+	// when the coins spent exceed the amount paid
+	ChangeAddress func() (string, error)
+
+	issuance *TokenIssuance // token issued or minted, paid to issueTo
+	issueTo  string
+	nameOp   *NameOperation
+	stakeOp  *StakeOperation
+
+	utxo    UTXOSet
+	keys    map[string]*wallet.Wallet
+	scripts map[string][]byte
+}
+
+func NewTxBuilder(utxo UTXOSet) *TxBuilder {
+	return &TxBuilder{
+		Strategy: BranchAndBound,
+		utxo:     utxo,
+		keys:     make(map[string]*wallet.Wallet),
+		scripts:  make(map[string][]byte),
+	}
+}
+
+func (b *TxBuilder) AddRecipient(address string, amount int) error {
+	if !wallet.ValidateAddress(address) {
+		return fmt.Errorf("address %s is not valid", address)
+	}
+	if amount <= 0 {
+		return fmt.Errorf("amount paid to %s must be positive", address)
+	}
+	b.Outputs = append(b.Outputs, *NewTXOutput(address, amount))
+	return nil
+}
+
+// AddLockedRecipient pays an address with an output that can only be spent
+// once its timelocks have passed
+func (b *TxBuilder) AddLockedRecipient(address string, amount int, lock Timelock) error {
+	if err := b.AddRecipient(address, amount); err != nil {
+		return err
+	}
+	if lock.LockTime < 0 || lock.AfterBlocks < 0 {
+		return errors.New("timelocks cannot be negative")
+	}
+	out := &b.Outputs[len(b.Outputs)-1]
+	out.Script = script.WithTimelock(lock.LockTime, int64(lock.AfterBlocks), out.Script)
+	return nil
+}
+
+// AddTokenRecipient pays tokens to an address
+func (b *TxBuilder) AddTokenRecipient(address string, tokenId []byte, amount int) error {
+	if !wallet.ValidateAddress(address) {
+		return fmt.Errorf("address %s is not valid", address)
+	}
+	if amount <= 0 {
+		return fmt.Errorf("amount of tokens paid to %s must be positive", address)
+	}
+	b.Outputs = append(b.Outputs, *NewTokenOutput(address, tokenId, amount))
+	return nil
+}
+
+// IssueToken issues a new token, or mints more of a token, paid to an
+// address. The issuer of a new token is the owner of the first coin spent.
+func (b *TxBuilder) IssueToken(issuance TokenIssuance, to string) error {
+	if !wallet.ValidateAddress(to) {
+		return fmt.Errorf("address %s is not valid", to)
+	}
+	if issuance.Supply <= 0 {
+		return errors.New("issued supply must be positive")
+	}
+	b.issuance = &issuance
+	b.issueTo = to
+	return nil
+}
+
+// SetNameOperation registers, renews or transfers a name, the transaction
+// then burns the fee of the operation
+func (b *TxBuilder) SetNameOperation(op NameOperation) {
+	b.nameOp = &op
+}
+
+// AddStake pays stake to an address, registering it as staker
+func (b *TxBuilder) AddStake(address string, amount int) error {
+	if amount < MinStake {
+		return fmt.Errorf("stake must be at least %d", MinStake)
+	}
+	if err := b.AddRecipient(address, amount); err != nil {
+		return err
+	}
+	b.stakeOp = &StakeOperation{Op: StakeRegister, Output: len(b.Outputs) - 1}
+	return nil
+}
+
+// Unstake unregisters stake, given as the coins to spend, paying it back to
+// its staker once the unbonding period has passed
+func (b *TxBuilder) Unstake(stakes []UnspentOutput) error {
+	if len(stakes) == 0 {
+		return errors.New("no stake to unregister")
+	}
+	address := stakes[0].Output.Address()
+	lock := Timelock{AfterBlocks: StakeUnbondingPeriod}
+	if err := b.AddLockedRecipient(address, SumCoins(stakes), lock); err != nil {
+		return err
+	}
+	b.Coins = stakes
+	b.stakeOp = &StakeOperation{Op: StakeUnregister, Output: len(b.Outputs) - 1}
+	return nil
+}
+
+// Slash reports a staker who signed two blocks at the same height, burning
+// their stake
+func (b *TxBuilder) Slash(evidence DoubleSignEvidence) error {
+	if _, err := evidence.Verify(); err != nil {
+		return err
+	}
+	b.stakeOp = &StakeOperation{Op: StakeSlash, Evidence: &evidence}
+	return nil
+}
+
+// AddData adds an unspendable output carrying data
+func (b *TxBuilder) AddData(data []byte) error {
+	out, err := NewDataOutput(data)
+	if err != nil {
+		return err
+	}
+	b.Outputs = append(b.Outputs, *out)
+	return nil
+}
+
+// AddSigner adds a wallet whose coins may fund the transaction
+func (b *TxBuilder) AddSigner(w *wallet.Wallet) error {
+	if !w.CanSign() {
+		return ErrCannotSign
+	}
+	b.keys[string(wallet.PubkeyHash(w.PublicKey))] = w
+	return nil
+}
+
+// AddWatchOnly adds an address whose coins may fund the transaction without
+// its key, the transaction can then only be built partially signed
+func (b *TxBuilder) AddWatchOnly(address string) error {
+	if !wallet.ValidateAddress(address) {
+		return fmt.Errorf("address %s is not valid", address)
+	}
+	out := NewTXOutput(address, 0)
+	if _, ok := b.keys[string(out.AddressHash())]; !ok {
+		b.keys[string(out.AddressHash())] = nil
+	}
+	return nil
+}
+
+// AddRedeemScript adds a script address whose coins may fund the
+// transaction, which can then only be built partially signed
+func (b *TxBuilder) AddRedeemScript(redeemScript []byte) {
+	hash := string(script.Hash160(redeemScript))
+	b.scripts[hash] = redeemScript
+	if _, ok := b.keys[hash]; !ok {
+		b.keys[hash] = nil
+	}
+}
+
+// Amount is the total paid to the recipients
+func (b *TxBuilder) Amount() int {
+	amount := 0
+	for _, out := range b.Outputs {
+		amount += out.Value
+	}
+	return amount
+}
+
+// Fund selects the coins to spend, or checks that the coins given can be
+// spent, and checks that they cover the amount paid and the tokens paid
+func (b *TxBuilder) Fund() error {
+	if len(b.Outputs) == 0 && b.issuance == nil && b.nameOp == nil && b.stakeOp == nil {
+		return errors.New("transaction has no recipients")
+	}
+	amount := b.Amount() + b.nameOp.Fee()
+	tokens := TokenAmounts(b.Outputs)
+	// a transaction paying no coins or tokens, only data or the tokens it
+	// issues, still spends a coin
+	if amount == 0 && len(tokens) == 0 {
+		amount = 1
+	}
+
+	if len(b.Coins) == 0 {
+		var pubKeyHashes [][]byte
+		for pubKeyHash := range b.keys {
+			pubKeyHashes = append(pubKeyHashes, []byte(pubKeyHash))
+		}
+		for tokenId, needed := range tokens {
+			coins, err := selectTokens(b.utxo.FindSpendableTokens(pubKeyHashes, []byte(tokenId)), needed)
+			if err != nil {
+				return fmt.Errorf("token %x: %w", tokenId, err)
+			}
+			b.Coins = append(b.Coins, coins...)
+		}
+		if amount > 0 {
+			candidates := b.utxo.FindSpendableCoins(pubKeyHashes)
+			if available := SumCoins(candidates); available < amount {
+				return fmt.Errorf("%w: paying %d, available %d", ErrNotEnoughFunds, amount, available)
+			}
+			coins, err := SelectCoins(b.Strategy, candidates, amount)
+			if err != nil {
+				return err
+			}
+			b.Coins = append(b.Coins, coins...)
+		}
+	} else {
+		// only unregistering stake spends it
+		unstaking := b.stakeOp != nil && b.stakeOp.Op == StakeUnregister
+		for _, coin := range b.Coins {
+			if err := b.utxo.checkSpendable(coin, unstaking); err != nil {
+				return err
+			}
+		}
+	}
+
+	if available := SumCoins(b.Coins); available < amount {
+		return fmt.Errorf("%w: paying %d, available %d", ErrNotEnoughFunds, amount, available)
+	}
+	available := TokenAmounts(coinOutputs(b.Coins))
+	for tokenId, needed := range tokens {
+		if available[tokenId] < needed {
+			return fmt.Errorf("%w: paying %d of token %x, available %d", ErrNotEnoughFunds, needed, tokenId, available[tokenId])
+		}
+	}
+	return nil
+}
+
+// selectTokens picks the outputs carrying the most tokens until they cover
+// amount
+func selectTokens(coins []UnspentOutput, amount int) ([]UnspentOutput, error) {
+	sort.SliceStable(coins, func(i, j int) bool {
+		return coins[i].Output.Tokens > coins[j].Output.Tokens
+	})
+	var selected []UnspentOutput
+	total := 0
+	for _, coin := range coins {
+		if total >= amount {
+			break
+		}
+		selected = append(selected, coin)
+		total += coin.Output.Tokens
+	}
+	if total < amount {
+		return nil, fmt.Errorf("%w: paying %d, available %d", ErrNotEnoughFunds, amount, total)
+	}
+	return selected, nil
+}
+
+func coinOutputs(coins []UnspentOutput) []TxOutput {
+	var outputs []TxOutput
+	for _, coin := range coins {
+		outputs = append(outputs, coin.Output)
+	}
+	return outputs
+}
+
+// Build funds the transaction if needed, adds the change output and signs
+// every input with the key of the coin it spends
+func (b *TxBuilder) Build() (*Transaction, error) {
+	tx, err := b.assemble()
+	if err != nil {
+		return nil, err
+	}
+	for _, coin := range b.Coins {
+		if b.keys[string(coin.Output.AddressHash())] == nil {
+			return nil, ErrCannotSign
+		}
+	}
+	b.utxo.Blockchain.SignTransactionWithKeys(tx, b.keys)
+	return tx, nil
+}
+
+// BuildPartial funds the transaction like Build but leaves it unsigned,
+// together with the outputs it spends, for signing elsewhere
+func (b *TxBuilder) BuildPartial() (*PartialTransaction, error) {
+	tx, err := b.assemble()
+	if err != nil {
+		return nil, err
+	}
+	var prevOutputs []TxOutput
+	for _, coin := range b.Coins {
+		prevOutputs = append(prevOutputs, coin.Output)
+	}
+	return &PartialTransaction{*tx, prevOutputs}, nil
+}
+
+func (b *TxBuilder) assemble() (*Transaction, error) {
+	if err := b.Fund(); err != nil {
+		return nil, err
+	}
+
+	var inputs []TxInput
+	var lockTime int64
+	for _, coin := range b.Coins {
+		hash := string(coin.Output.AddressHash())
+		if _, ok := b.keys[hash]; !ok {
+			return nil, ErrCannotSign
+		}
+		coinLockTime, afterBlocks := coin.Output.Timelock()
+		if coinLockTime > 0 && lockTime > 0 && (coinLockTime < LockTimeThreshold) != (lockTime < LockTimeThreshold) {
+			return nil, errors.New("coins locked by height and by time cannot be spent together")
+		}
+		if coinLockTime > lockTime {
+			lockTime = coinLockTime
+		}
+		input := TxInput{Id: coin.TxId, OutIndex: coin.Index, Sequence: afterBlocks}
+		if redeemScript, ok := b.scripts[hash]; ok {
+			input.Script = script.NewBuilder().AddData(redeemScript).Script()
+		}
+		inputs = append(inputs, input)
+	}
+
+	outputs := append([]TxOutput{}, b.Outputs...)
+	if change := SumCoins(b.Coins) - b.Amount() - b.nameOp.Fee(); change > 0 {
+		if b.ChangeAddress == nil {
+			return nil, errors.New("change address is missing")
+		}
+		address, err := b.ChangeAddress()
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, *NewTXOutput(address, change))
+	}
+	paid := TokenAmounts(b.Outputs)
+	for tokenId, amount := range TokenAmounts(coinOutputs(b.Coins)) {
+		if change := amount - paid[tokenId]; change > 0 {
+			if b.ChangeAddress == nil {
+				return nil, errors.New("change address is missing")
+			}
+			address, err := b.ChangeAddress()
+			if err != nil {
+				return nil, err
+			}
+			outputs = append(outputs, *NewTokenOutput(address, []byte(tokenId), change))
+		}
+	}
+
+	var issuance *TokenIssuance
+	if b.issuance != nil {
+		issued := *b.issuance
+		tokenId := issued.TokenId
+		if tokenId == nil {
+			issued.Issuer = b.Coins[0].Output.AddressHash()
+			tokenId = newTokenId(inputs[0])
+		}
+		outputs = append(outputs, *NewTokenOutput(b.issueTo, tokenId, issued.Supply))
+		issuance = &issued
+	}
+
+	tx := Transaction{nil, inputs, outputs, lockTime, issuance, b.nameOp, b.stakeOp}
+	tx.setId()
+	return &tx, nil
+}
@@ -0,0 +1,435 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/Harshjha3006/golang-blockchain/wallet"
+)
+
+func address(w *wallet.Wallet) string {
+	return string(w.Address())
+}
+
+// newTestChain creates a proof of work chain in memory paying its genesis
+// reward to miner
+func newTestChain(t *testing.T, miner *wallet.Wallet, txIndex, addrIndex bool) *BlockChain {
+	t.Helper()
+	chain, err := InitBlockChainWithStore(NewMemoryStore(), ChainParams{}, nil, address(miner), txIndex, addrIndex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chain
+}
+
+// mine mines a block of transactions on the tip, paying the reward to miner
+func mine(t *testing.T, chain *BlockChain, miner *wallet.Wallet, txs ...*Transaction) *Block {
+	t.Helper()
+	block, err := chain.MineBlock(append([]*Transaction{CoinbaseTx(address(miner), "")}, txs...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+// mineOn mines a block on any block of the chain and adds it like a block
+// received from a peer
+func mineOn(t *testing.T, chain *BlockChain, parent *Block, miner *wallet.Wallet, txs ...*Transaction) *Block {
+	t.Helper()
+	txs = append([]*Transaction{CoinbaseTx(address(miner), "")}, txs...)
+	block, err := CreateBlock(chain.Engine, txs, parent.Hash, parent.Height+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.AddBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+// buildTx builds a transaction funded by the coins of from, paying its
+// change back to from
+func buildTx(chain *BlockChain, from *wallet.Wallet, setup func(b *TxBuilder) error) (*Transaction, error) {
+	b := NewTxBuilder(UTXOSet{chain})
+	if err := b.AddSigner(from); err != nil {
+		return nil, err
+	}
+	b.ChangeAddress = func() (string, error) {
+		return address(from), nil
+	}
+	if err := setup(b); err != nil {
+		return nil, err
+	}
+	return b.Build()
+}
+
+func pay(t *testing.T, chain *BlockChain, from *wallet.Wallet, to string, amount int) *Transaction {
+	t.Helper()
+	tx, err := buildTx(chain, from, func(b *TxBuilder) error {
+		return b.AddRecipient(to, amount)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func balance(chain *BlockChain, w *wallet.Wallet) int {
+	total := 0
+	for _, out := range (UTXOSet{chain}).FindUTXO(wallet.PubkeyHash(w.PublicKey)) {
+		total += out.Value
+	}
+	return total
+}
+
+func tip(t *testing.T, chain *BlockChain) *Block {
+	t.Helper()
+	block, err := chain.GetBlock(chain.LastHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &block
+}
+
+// chainState is what connecting blocks writes to the store, other than the
+// blocks and the indexes kept for every stored block
+func chainState(t *testing.T, chain *BlockChain) map[string]string {
+	t.Helper()
+	state := make(map[string]string)
+	prefixes := [][]byte{utxoPrefix, undoPrefix, tokenPrefix, tokenNamePrefix, nftPrefix,
+		namePrefix, nameUndoPrefix, stakePrefix, evidencePrefix, heightPrefix}
+	err := chain.Database.View(func(txn StoreTxn) error {
+		for _, prefix := range prefixes {
+			err := txn.Iterate(prefix, nil, func(k, v []byte) error {
+				state[string(k)] = string(v)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+// checkUndo checks that the state left by connecting and disconnecting
+// blocks is the state rebuilt from scratch by replaying the main chain
+func checkUndo(t *testing.T, chain *BlockChain) {
+	t.Helper()
+	before := chainState(t, chain)
+	UTXOSet{chain}.ReIndex()
+	after := chainState(t, chain)
+	if len(before) != len(after) {
+		t.Fatalf("state has %d entries, %d when rebuilt", len(before), len(after))
+	}
+	for k, v := range after {
+		if before[k] != v {
+			t.Fatalf("state entry %q differs from the rebuilt one", k)
+		}
+	}
+}
+
+var errRollback = errors.New("rollback")
+
+// withReverted runs check on the state of the chain with its last blocks
+// reverted, then discards the revert
+func withReverted(t *testing.T, chain *BlockChain, blocks int, check func(txn StoreTxn)) {
+	t.Helper()
+	err := chain.Database.Update(func(txn StoreTxn) error {
+		block, err := getTip(txn)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < blocks; i++ {
+			if err := disconnectBlock(txn, block); err != nil {
+				return err
+			}
+			if block, err = getBlock(txn, block.PrevHash); err != nil {
+				return err
+			}
+		}
+		check(txn)
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatal(err)
+	}
+}
+
+type recordedBlocks struct {
+	connected, disconnected [][]byte
+}
+
+func (r *recordedBlocks) BlockConnected(block *Block) {
+	r.connected = append(r.connected, block.Hash)
+}
+
+func (r *recordedBlocks) BlockDisconnected(block *Block) {
+	r.disconnected = append(r.disconnected, block.Hash)
+}
+
+func TestHeightIndex(t *testing.T) {
+	alice, bob := wallet.MakeWallet(), wallet.MakeWallet()
+	chain := newTestChain(t, alice, false, false)
+	block := mine(t, chain, alice, pay(t, chain, alice, address(bob), 30))
+	mine(t, chain, alice)
+
+	if chain.GetBestHeight() != 2 {
+		t.Errorf("best height %d", chain.GetBestHeight())
+	}
+	byHeight, err := chain.GetBlockByHeight(1)
+	if err != nil || !bytes.Equal(byHeight.Hash, block.Hash) {
+		t.Errorf("block at height 1 is %x, %v", byHeight.Hash, err)
+	}
+	if _, err := chain.GetBlockByHeight(3); err == nil {
+		t.Error("a block is found above the tip")
+	}
+	if !chain.hasHeightIndex() {
+		t.Fatal("height index is not up to date")
+	}
+
+	// a rebuild interrupted before it wrote the tip is detected
+	before := chainState(t, chain)
+	err = chain.Database.Update(func(txn StoreTxn) error {
+		return txn.Delete(heightKey(2))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chain.hasHeightIndex() {
+		t.Error("a height index missing the tip is up to date")
+	}
+
+	// a height index rebuilt from scratch matches the one kept while connecting
+	utxo := UTXOSet{chain}
+	utxo.DeleteByPrefix(heightPrefix)
+	chain.ReIndexHeights()
+	after := chainState(t, chain)
+	if len(after) != len(before) {
+		t.Errorf("rebuilt height index has %d entries, want %d", len(after), len(before))
+	}
+	for k, v := range before {
+		if after[k] != v {
+			t.Errorf("rebuilt height index differs at %q", k)
+		}
+	}
+}
+
+func TestTxIndex(t *testing.T) {
+	alice, bob := wallet.MakeWallet(), wallet.MakeWallet()
+	chain := newTestChain(t, alice, true, false)
+	tx := pay(t, chain, alice, address(bob), 30)
+	if err := chain.VerifyTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	block := mine(t, chain, alice, tx)
+
+	loc, err := chain.FindTransactionLocation(tx.Id)
+	if err != nil || !bytes.Equal(loc.BlockHash, block.Hash) || loc.Position != 1 {
+		t.Errorf("transaction location %x %d, %v", loc.BlockHash, loc.Position, err)
+	}
+	found, err := chain.FindTransaction(tx.Id)
+	if err != nil || !bytes.Equal(found.Id, tx.Id) {
+		t.Errorf("FindTransaction = %x, %v", found.Id, err)
+	}
+	if _, err := chain.FindTransaction([]byte("unknown")); err == nil {
+		t.Error("an unknown transaction is found")
+	}
+
+	unindexed := newTestChain(t, alice, false, false)
+	if _, err := unindexed.FindTransactionLocation(tx.Id); err == nil {
+		t.Error("a chain without the index locates transactions")
+	}
+	unindexed.ReIndexTransactions()
+	if !unindexed.TxIndexEnabled() {
+		t.Error("rebuilding the index does not enable it")
+	}
+}
+
+func TestAddressIndex(t *testing.T) {
+	alice, bob, carol := wallet.MakeWallet(), wallet.MakeWallet(), wallet.MakeWallet()
+	chain := newTestChain(t, alice, false, true)
+	mine(t, chain, alice, pay(t, chain, alice, address(bob), 30))
+
+	history, err := chain.GetAddressHistory(wallet.PubkeyHash(bob.PublicKey))
+	if err != nil || len(history) != 1 || history[0].Value != 30 || history[0].Spent || history[0].Height != 1 {
+		t.Errorf("bob's history %+v, %v", history, err)
+	}
+	history, err = chain.GetAddressHistory(wallet.PubkeyHash(alice.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	spent := 0
+	for _, entry := range history {
+		if entry.Spent {
+			spent += entry.Value
+		}
+	}
+	if spent != 100 {
+		t.Errorf("alice's history spends %d, want the genesis reward", spent)
+	}
+
+	used := chain.UsedPubKeyHashes()
+	if !used[string(wallet.PubkeyHash(bob.PublicKey))] || !used[string(wallet.PubkeyHash(alice.PublicKey))] {
+		t.Error("used addresses miss a funded address")
+	}
+	if used[string(wallet.PubkeyHash(carol.PublicKey))] {
+		t.Error("an unused address is used")
+	}
+
+	// without the index, history is found by scanning the chain
+	unindexed := newTestChain(t, alice, false, false)
+	mine(t, unindexed, alice, pay(t, unindexed, alice, address(bob), 30))
+	if _, err := unindexed.GetAddressHistory(wallet.PubkeyHash(bob.PublicKey)); !errors.Is(err, ErrAddrIndexDisabled) {
+		t.Errorf("history without the index: %v", err)
+	}
+	scanned, err := unindexed.ScanAddress(wallet.PubkeyHash(bob.PublicKey))
+	if err != nil || len(scanned) != 1 || scanned[0].Value != 30 {
+		t.Errorf("scanned history %+v, %v", scanned, err)
+	}
+	if !unindexed.UsedPubKeyHashes()[string(wallet.PubkeyHash(bob.PublicKey))] {
+		t.Error("used addresses scanned without the index miss bob")
+	}
+	checkUndo(t, chain)
+}
+
+func TestReorganize(t *testing.T) {
+	alice, bob, carol := wallet.MakeWallet(), wallet.MakeWallet(), wallet.MakeWallet()
+	chain := newTestChain(t, alice, true, true)
+	genesis := tip(t, chain)
+	var recorded recordedBlocks
+	chain.Subscribe(&recorded)
+
+	// both spend the genesis reward
+	toBob := pay(t, chain, alice, address(bob), 30)
+	toCarol := pay(t, chain, alice, address(carol), 40)
+
+	main1 := mine(t, chain, alice, toBob)
+	side1 := mineOn(t, chain, genesis, carol, toCarol)
+	if !bytes.Equal(chain.LastHash, main1.Hash) {
+		t.Fatal("a side chain of the same weight replaced the main chain")
+	}
+	side2 := mineOn(t, chain, side1, carol)
+	if !bytes.Equal(chain.LastHash, side2.Hash) {
+		t.Fatal("the heavier side chain did not become the main chain")
+	}
+	if len(recorded.disconnected) != 1 || !bytes.Equal(recorded.disconnected[0], main1.Hash) {
+		t.Errorf("disconnected %x", recorded.disconnected)
+	}
+	if len(recorded.connected) != 3 || !bytes.Equal(recorded.connected[2], side2.Hash) {
+		t.Errorf("connected %x", recorded.connected)
+	}
+
+	if got := balance(chain, bob); got != 0 {
+		t.Errorf("bob has %d after the reorganization, want 0", got)
+	}
+	if got := balance(chain, carol); got != 240 {
+		t.Errorf("carol has %d, want 240", got)
+	}
+	if got := balance(chain, alice); got != 60 {
+		t.Errorf("alice has %d, want 60", got)
+	}
+	if byHeight, _ := chain.GetBlockByHeight(1); !bytes.Equal(byHeight.Hash, side1.Hash) {
+		t.Error("height index still points at the old branch")
+	}
+	if _, err := chain.FindTransactionLocation(toBob.Id); err == nil {
+		t.Error("transaction of the old branch is still indexed")
+	}
+	if history, _ := chain.GetAddressHistory(wallet.PubkeyHash(bob.PublicKey)); len(history) != 0 {
+		t.Errorf("bob's history keeps %d entries of the old branch", len(history))
+	}
+	checkUndo(t, chain)
+
+	// the old branch overtakes again
+	main2 := mineOn(t, chain, main1, alice)
+	main3 := mineOn(t, chain, main2, alice)
+	if !bytes.Equal(chain.LastHash, main3.Hash) {
+		t.Fatal("the old branch did not become the main chain again")
+	}
+	if got := balance(chain, bob); got != 30 {
+		t.Errorf("bob has %d, want 30", got)
+	}
+	if got := balance(chain, carol); got != 0 {
+		t.Errorf("carol has %d, want 0", got)
+	}
+	if loc, err := chain.FindTransactionLocation(toBob.Id); err != nil || !bytes.Equal(loc.BlockHash, main1.Hash) {
+		t.Errorf("transaction location %x, %v", loc.BlockHash, err)
+	}
+	if _, err := chain.FindTransactionLocation(toCarol.Id); err == nil {
+		t.Error("transaction of the abandoned branch is still indexed")
+	}
+	checkUndo(t, chain)
+}
+
+func TestReorganizeSpendWithinBlock(t *testing.T) {
+	alice, bob, carol := wallet.MakeWallet(), wallet.MakeWallet(), wallet.MakeWallet()
+	chain := newTestChain(t, alice, false, false)
+	genesis := tip(t, chain)
+
+	// bob spends the output paying him in the block creating it
+	toBob := pay(t, chain, alice, address(bob), 30)
+	toCarol := Transaction{nil, []TxInput{{Id: toBob.Id, OutIndex: 0}},
+		[]TxOutput{*NewTXOutput(address(carol), 30)}, 0, nil, nil, nil}
+	toCarol.setId()
+	if !toCarol.signWith(0, bob, toBob.Outputs[0]) {
+		t.Fatal("bob cannot sign for his output")
+	}
+	mine(t, chain, alice, toBob, &toCarol)
+	if got := balance(chain, carol); got != 30 {
+		t.Fatalf("carol has %d, want 30", got)
+	}
+
+	side1 := mineOn(t, chain, genesis, carol)
+	mineOn(t, chain, side1, carol)
+	if _, err := (UTXOSet{chain}).GetUnspentOutput(toBob.Id, 0); err == nil {
+		t.Error("an output created and spent in a disconnected block is unspent")
+	}
+	if got := balance(chain, bob); got != 0 {
+		t.Errorf("bob has %d after the reorganization, want 0", got)
+	}
+	if got := balance(chain, alice); got != 100 {
+		t.Errorf("alice has %d, want the genesis reward back", got)
+	}
+	checkUndo(t, chain)
+}
+
+func TestRejectsInvalidBlocks(t *testing.T) {
+	alice, bob := wallet.MakeWallet(), wallet.MakeWallet()
+	chain := newTestChain(t, alice, false, false)
+	genesis := tip(t, chain)
+	tx := pay(t, chain, alice, address(bob), 30)
+	mine(t, chain, alice, tx)
+
+	// spending the genesis reward twice
+	lastHash := chain.LastHash
+	if _, err := chain.MineBlock([]*Transaction{CoinbaseTx(address(alice), ""), tx}); err == nil {
+		t.Error("a block spending a spent output is accepted")
+	}
+	if !bytes.Equal(chain.LastHash, lastHash) {
+		t.Error("a rejected block moved the tip")
+	}
+
+	block, err := CreateBlock(chain.Engine, []*Transaction{CoinbaseTx(address(bob), "")}, genesis.Hash, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block.Nonce++
+	if err := chain.AddBlock(block); err == nil {
+		t.Error("a block with an invalid proof of work is accepted")
+	}
+
+	block, err = CreateBlock(chain.Engine, []*Transaction{CoinbaseTx(address(bob), "")}, genesis.Hash, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block.Timstamp = genesis.Timstamp
+	block.Nonce, block.Hash = InitPow(block, CurrentRules).Run()
+	if err := chain.AddBlock(block); err == nil {
+		t.Error("a block timestamped at its parent's time is accepted")
+	}
+}
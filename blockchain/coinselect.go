@@ -0,0 +1,151 @@
+package blockchain
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+)
+
+// Coin selection strategies
+const (
+	BranchAndBound = "bnb"     // exact match without change, falls back to largest first
+	LargestFirst   = "largest" // fewest inputs
+	RandomImprove  = "random"  // random inputs, improved towards change of about the amount paid
+)
+
+const bnbMaxTries = 100000
+
+var ErrUnknownStrategy = errors.New("unknown coin selection strategy")
+
+// SelectCoins picks the coins spent to pay amount
+func SelectCoins(strategy string, coins []UnspentOutput, amount int) ([]UnspentOutput, error) {
+	if amount <= 0 {
+		return nil, errors.New("amount must be positive")
+	}
+	if SumCoins(coins) < amount {
+		return nil, ErrNotEnoughFunds
+	}
+
+	switch strategy {
+	case BranchAndBound, "":
+		if selected := selectBranchAndBound(coins, amount); selected != nil {
+			return selected, nil
+		}
+		return selectLargestFirst(coins, amount), nil
+	case LargestFirst:
+		return selectLargestFirst(coins, amount), nil
+	case RandomImprove:
+		return selectRandomImprove(coins, amount), nil
+	default:
+		return nil, ErrUnknownStrategy
+	}
+}
+
+func SumCoins(coins []UnspentOutput) int {
+	sum := 0
+	for _, coin := range coins {
+		sum += coin.Output.Value
+	}
+	return sum
+}
+
+func sortByValue(coins []UnspentOutput) []UnspentOutput {
+	sorted := append([]UnspentOutput{}, coins...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Output.Value > sorted[j].Output.Value
+	})
+	return sorted
+}
+
+// selectBranchAndBound searches depth first, largest coins first, for a set
+// of coins adding up to exactly amount, so no change output is needed. It
+// returns nil when there is none or the search gives up.
+func selectBranchAndBound(coins []UnspentOutput, amount int) []UnspentOutput {
+	sorted := sortByValue(coins)
+	remaining := make([]int, len(sorted)+1)
+	for i := len(sorted) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1] + sorted[i].Output.Value
+	}
+
+	var selected []int
+	tries := 0
+	var search func(i, total int) bool
+	search = func(i, total int) bool {
+		tries++
+		if total == amount {
+			return true
+		}
+		if tries > bnbMaxTries || i == len(sorted) || total > amount || total+remaining[i] < amount {
+			return false
+		}
+		selected = append(selected, i)
+		if search(i+1, total+sorted[i].Output.Value) {
+			return true
+		}
+		selected = selected[:len(selected)-1]
+
+		// leaving out a coin also leaves out the coins of the same value,
+		// including them instead was tried on the branch above
+		next := i + 1
+		for next < len(sorted) && sorted[next].Output.Value == sorted[i].Output.Value {
+			next++
+		}
+		return search(next, total)
+	}
+	if !search(0, 0) {
+		return nil
+	}
+
+	var result []UnspentOutput
+	for _, i := range selected {
+		result = append(result, sorted[i])
+	}
+	return result
+}
+
+func selectLargestFirst(coins []UnspentOutput, amount int) []UnspentOutput {
+	var selected []UnspentOutput
+	total := 0
+	for _, coin := range sortByValue(coins) {
+		if total >= amount {
+			break
+		}
+		selected = append(selected, coin)
+		total += coin.Output.Value
+	}
+	return selected
+}
+
+// selectRandomImprove picks random coins until amount is covered, then keeps
+// adding random coins while they bring the total closer to twice the amount
+// without passing three times it, so change outputs resemble payments
+func selectRandomImprove(coins []UnspentOutput, amount int) []UnspentOutput {
+	shuffled := append([]UnspentOutput{}, coins...)
+	rand.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+
+	var selected []UnspentOutput
+	total, i := 0, 0
+	for ; total < amount; i++ {
+		selected = append(selected, shuffled[i])
+		total += shuffled[i].Output.Value
+	}
+
+	ideal, limit := 2*amount, 3*amount
+	for ; i < len(shuffled); i++ {
+		value := shuffled[i].Output.Value
+		if total+value <= limit && distance(ideal, total+value) < distance(ideal, total) {
+			selected = append(selected, shuffled[i])
+			total += value
+		}
+	}
+	return selected
+}
+
+func distance(a, b int) int {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
@@ -0,0 +1,131 @@
+package blockchain
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Harshjha3006/golang-blockchain/wallet"
+)
+
+func testCoins(values ...int) []UnspentOutput {
+	var coins []UnspentOutput
+	for i, value := range values {
+		coins = append(coins, UnspentOutput{TxId: []byte{byte(i)}, Index: i, Output: TxOutput{Value: value}})
+	}
+	return coins
+}
+
+func TestSelectCoins(t *testing.T) {
+	coins := testCoins(50, 30, 20, 7, 5, 1)
+
+	tests := []struct {
+		strategy string
+		amount   int
+		want     int // total of the coins selected
+		inputs   int
+	}{
+		{BranchAndBound, 27, 27, 2},   // 20 + 7, no change
+		{BranchAndBound, 56, 56, 3},   // 50 + 5 + 1
+		{BranchAndBound, 112, 112, 5}, // 50 + 30 + 20 + 7 + 5
+		{BranchAndBound, 113, 113, 6},
+		{BranchAndBound, 49, 50, 1}, // no exact match, falls back to largest first
+		{LargestFirst, 27, 50, 1},
+		{LargestFirst, 60, 80, 2},
+	}
+	for _, test := range tests {
+		selected, err := SelectCoins(test.strategy, coins, test.amount)
+		if err != nil {
+			t.Fatalf("%s %d: %v", test.strategy, test.amount, err)
+		}
+		if SumCoins(selected) != test.want || len(selected) != test.inputs {
+			t.Errorf("%s %d: selected %d in %d coins, want %d in %d", test.strategy, test.amount,
+				SumCoins(selected), len(selected), test.want, test.inputs)
+		}
+	}
+}
+
+func TestSelectRandomImprove(t *testing.T) {
+	coins := testCoins(10, 10, 10, 10, 10, 10, 10, 10, 10, 10)
+	for i := 0; i < 20; i++ {
+		selected, err := SelectCoins(RandomImprove, coins, 25)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// random improve aims at change about the amount paid, within three times it
+		if total := SumCoins(selected); total < 25 || total > 75 {
+			t.Errorf("selected %d to pay 25", total)
+		}
+		seen := make(map[int]bool)
+		for _, coin := range selected {
+			if seen[coin.Index] {
+				t.Fatalf("coin %d selected twice", coin.Index)
+			}
+			seen[coin.Index] = true
+		}
+	}
+}
+
+func TestSelectCoinsErrors(t *testing.T) {
+	coins := testCoins(5, 5)
+	if _, err := SelectCoins(BranchAndBound, coins, 11); !errors.Is(err, ErrNotEnoughFunds) {
+		t.Errorf("paying more than available: %v", err)
+	}
+	if _, err := SelectCoins(BranchAndBound, coins, 0); err == nil {
+		t.Error("paying nothing selects coins")
+	}
+	if _, err := SelectCoins("smallest", coins, 5); !errors.Is(err, ErrUnknownStrategy) {
+		t.Errorf("unknown strategy: %v", err)
+	}
+}
+
+func TestManualCoins(t *testing.T) {
+	alice, bob := wallet.MakeWallet(), wallet.MakeWallet()
+	chain := newTestChain(t, alice, false, false)
+	genesisCoin := UTXOSet{chain}.FindSpendableCoins([][]byte{wallet.PubkeyHash(alice.PublicKey)})
+
+	locked, err := buildTx(chain, alice, func(b *TxBuilder) error {
+		return b.AddLockedRecipient(address(alice), 30, Timelock{AfterBlocks: 5})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mine(t, chain, alice, locked)
+	stake, err := buildTx(chain, alice, func(b *TxBuilder) error {
+		return b.AddStake(address(alice), MinStake)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mine(t, chain, alice, stake)
+	stakedCoin, err := UTXOSet{chain}.GetUnspentOutput(stake.Id, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lockedCoin, err := UTXOSet{chain}.GetUnspentOutput(locked.Id, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changeCoin, err := UTXOSet{chain}.GetUnspentOutput(stake.Id, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		coins []UnspentOutput
+		ok    bool
+	}{
+		"spent":      {genesisCoin, false},
+		"timelocked": {[]UnspentOutput{lockedCoin}, false},
+		"staked":     {[]UnspentOutput{stakedCoin}, false},
+		"spendable":  {[]UnspentOutput{changeCoin}, true},
+	}
+	for name, test := range tests {
+		_, err := buildTx(chain, alice, func(b *TxBuilder) error {
+			b.Coins = test.coins
+			return b.AddRecipient(address(bob), 10)
+		})
+		if (err == nil) != test.ok {
+			t.Errorf("%s coin: %v", name, err)
+		}
+	}
+}
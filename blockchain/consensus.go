@@ -0,0 +1,231 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+
+	"github.com/Harshjha3006/golang-blockchain/wallet"
+)
+
+// Consensus engines
+const (
+	ConsensusPoW = "pow"
+	ConsensusPoA = "poa"
+	ConsensusPoS = "pos"
+)
+
+// Consensus decides how blocks are sealed and which chain is the main chain
+type Consensus interface {
+	Name() string
+	// Prepare fills in the consensus fields of a new block
+	Prepare(block *Block) error
+	// Seal completes a prepared block and sets its hash
+	Seal(block *Block) error
+	// VerifySeal checks that a block is sealed by the rules of the engine
+	VerifySeal(block *Block) error
+	// Weight is what a block adds to the weight of its chain, the heaviest
+	// chain being the main chain
+	Weight(block *Block) int
+}
+
+// Consensus rule versions. Chains keep the version they were created with,
+// chains created before versions were stored follow BaseRules.
+const (
+	// BaseRules commits proof of work to the transactions, the parent and
+	// the nonce
+	BaseRules = iota
+	// TimedRules also commits proof of work to the timestamp and height of
+	// the block, and bounds its timestamp
+	TimedRules
+	// SignerLimitRules also keeps a proof of authority signer from signing
+	// again within SignerLimit blocks and spaces its blocks AuthorityPeriod
+	// seconds apart
+	SignerLimitRules
+
+	CurrentRules = SignerLimitRules
+)
+
+// ChainParams are fixed when a chain is created and stored with it
+type ChainParams struct {
+	Consensus string
+	Signers   [][]byte // pubkey hashes of the proof of authority signers, or of the proof of stake bootstrap signers
+	Rules     int      // consensus rule version
+}
+
+// KeySource returns the wallet holding the key of a pubkey hash, which
+// engines sealing blocks with a signature sign with
+type KeySource func(pubKeyHash []byte) (*wallet.Wallet, error)
+
+var (
+	paramsKey        = []byte("params")
+	weightPrefix     = []byte("w-")          // block hash -> weight of the chain it ends
+	signedPrefix     = []byte("signed-")     // signer pubkey hash and height -> hash of the block signed
+	doubleSignPrefix = []byte("doublesign-") // hashes of two blocks signed by a signer at the same height -> DoubleSign
+)
+
+var ErrNoSigningKey = errors.New("no signing key for this chain")
+
+// NewConsensus returns the engine selected by the chain parameters, for the
+// chain kept in store, which is nil while the genesis block is created
+func NewConsensus(params ChainParams, store Store, keys KeySource) (Consensus, error) {
+	switch params.Consensus {
+	case "", ConsensusPoW:
+		return &PoW{store, params.Rules}, nil
+	case ConsensusPoA:
+		if len(params.Signers) == 0 {
+			return nil, errors.New("proof of authority needs signers")
+		}
+		return &PoA{params.Signers, store, params.Rules, keys}, nil
+	case ConsensusPoS:
+		if len(params.Signers) == 0 {
+			return nil, errors.New("proof of stake needs bootstrap signers")
+		}
+		return &PoS{params.Signers, store, keys}, nil
+	}
+	return nil, fmt.Errorf("unknown consensus engine %q", params.Consensus)
+}
+
+// NodeKeys is the KeySource of the wallet of a node
+func NodeKeys(nodeId string) KeySource {
+	return func(pubKeyHash []byte) (*wallet.Wallet, error) {
+		wallets, err := wallet.CreateWallets(nodeId)
+		if err != nil {
+			return nil, err
+		}
+		if wallets.Locked() {
+			return nil, wallet.ErrWalletLocked
+		}
+		w, ok := wallets.Wallets[string(wallet.AddressFromPubKeyHash(pubKeyHash))]
+		if !ok || !w.CanSign() {
+			return nil, ErrNoSigningKey
+		}
+		return w, nil
+	}
+}
+
+func (p ChainParams) Serialize() []byte {
+	var res bytes.Buffer
+	err := gob.NewEncoder(&res).Encode(p)
+	Handle(err)
+	return res.Bytes()
+}
+
+func getParams(txn StoreTxn) ChainParams {
+	var params ChainParams
+	data, err := txn.Get(paramsKey)
+	if err != nil {
+		// chains created before the parameters were stored use proof of work
+		return params
+	}
+	err = gob.NewDecoder(bytes.NewReader(data)).Decode(&params)
+	Handle(err)
+	return params
+}
+
+// Params returns the parameters the chain was created with
+func (chain *BlockChain) Params() ChainParams {
+	var params ChainParams
+	err := chain.Database.View(func(txn StoreTxn) error {
+		params = getParams(txn)
+		return nil
+	})
+	Handle(err)
+	return params
+}
+
+func weightKey(hash []byte) []byte {
+	return append(append([]byte{}, weightPrefix...), hash...)
+}
+
+// getWeight returns the weight of the chain ending with a block. Blocks
+// stored before weights were recorded are proof of work blocks weighing one.
+func getWeight(txn StoreTxn, block *Block) int {
+	data, err := txn.Get(weightKey(block.Hash))
+	if err != nil {
+		return block.Height + 1
+	}
+	return int(binary.BigEndian.Uint64(data))
+}
+
+func (chain *BlockChain) setWeight(txn StoreTxn, block *Block) (int, error) {
+	weight := chain.Engine.Weight(block)
+	if parent, err := parentBlock(txn, block); err != nil {
+		return 0, err
+	} else if parent != nil {
+		weight += getWeight(txn, parent)
+	}
+	return weight, txn.Set(weightKey(block.Hash), ToHex(int64(weight)))
+}
+
+func signedKey(signer []byte, height int) []byte {
+	key := append(append([]byte{}, signedPrefix...), wallet.PubkeyHash(signer)...)
+	return append(key, ToHex(int64(height))...)
+}
+
+// DoubleSign records a signer who signed two blocks this node stored at the
+// same height
+type DoubleSign struct {
+	Signer []byte // pubkey hash
+	Height int
+	First  []byte // hashes of the blocks
+	Second []byte
+}
+
+// recordSignature remembers the block a signer signed at a height, and
+// records a DoubleSign when the signer already signed another one
+func recordSignature(txn StoreTxn, block *Block) error {
+	if block.Signer == nil {
+		return nil
+	}
+	key := signedKey(block.Signer, block.Height)
+	other, err := txn.Get(key)
+	if err != nil {
+		return txn.Set(key, block.Hash)
+	}
+	if bytes.Equal(other, block.Hash) {
+		return nil
+	}
+	double := DoubleSign{wallet.PubkeyHash(block.Signer), block.Height, other, block.Hash}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(double); err != nil {
+		return err
+	}
+	return txn.Set(bytes.Join([][]byte{doubleSignPrefix, other, block.Hash}, []byte{}), buf.Bytes())
+}
+
+// DoubleSigns returns the signers this node stored two blocks of at the same
+// height
+func (chain *BlockChain) DoubleSigns() []DoubleSign {
+	var doubles []DoubleSign
+	err := chain.Database.View(func(txn StoreTxn) error {
+		return txn.Iterate(doubleSignPrefix, nil, func(_, v []byte) error {
+			var double DoubleSign
+			if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&double); err != nil {
+				return err
+			}
+			doubles = append(doubles, double)
+			return nil
+		})
+	})
+	Handle(err)
+	return doubles
+}
+
+// DoubleSignEvidence returns the evidence that the signer of two stored
+// blocks signed both at the same height
+func (chain *BlockChain) DoubleSignEvidence(first, second []byte) (DoubleSignEvidence, error) {
+	var headers []SignedHeader
+	for _, hash := range [][]byte{first, second} {
+		block, err := chain.GetBlock(hash)
+		if err != nil {
+			return DoubleSignEvidence{}, err
+		}
+		headers = append(headers, SignedHeader{block.Header(), block.Signature})
+	}
+	evidence := DoubleSignEvidence{headers[0], headers[1]}
+	_, err := evidence.Verify()
+	return evidence, err
+}
@@ -0,0 +1,270 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+)
+
+var (
+	heightPrefix = []byte("h-") // height -> hash of the main chain block at that height
+)
+
+func heightKey(height int) []byte {
+	key := append([]byte{}, heightPrefix...)
+	return append(key, ToHex(int64(height))...)
+}
+
+func getHashAtHeight(txn StoreTxn, height int) ([]byte, error) {
+	hash, err := txn.Get(heightKey(height))
+	if err != nil {
+		return nil, errors.New("No block at this height")
+	}
+	return hash, nil
+}
+
+// connectBlock records a block that has just become part of the main chain
+func connectBlock(txn StoreTxn, block *Block) error {
+	if err := txn.Set(heightKey(block.Height), block.Hash); err != nil {
+		return err
+	}
+	if err := applyBlock(txn, block); err != nil {
+		return err
+	}
+	if txIndexEnabled(txn) {
+		if err := indexTransactions(txn, block); err != nil {
+			return err
+		}
+	}
+	if addrIndexEnabled(txn) {
+		if err := indexAddresses(txn, block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// disconnectBlock removes a block that is no longer part of the main chain
+func disconnectBlock(txn StoreTxn, block *Block) error {
+	if addrIndexEnabled(txn) {
+		if err := unindexAddresses(txn, block); err != nil {
+			return err
+		}
+	}
+	if txIndexEnabled(txn) {
+		if err := unindexTransactions(txn, block); err != nil {
+			return err
+		}
+	}
+	if err := revertBlock(txn, block); err != nil {
+		return err
+	}
+	hash, err := getHashAtHeight(txn, block.Height)
+	if err != nil || !bytes.Equal(hash, block.Hash) {
+		return nil
+	}
+	return txn.Delete(heightKey(block.Height))
+}
+
+// reorganize switches the main chain from oldTip to newTip, disconnecting the
+// blocks of the old branch and connecting the blocks of the new one. It returns
+// the blocks in the order they were disconnected and connected.
+func reorganize(txn StoreTxn, oldTip, newTip *Block) (detached, connected []*Block, err error) {
+	var detach, attach []*Block
+	oldBlock, newBlock := oldTip, newTip
+
+	for oldBlock != nil && newBlock != nil && !bytes.Equal(oldBlock.Hash, newBlock.Hash) {
+		if oldBlock.Height >= newBlock.Height {
+			detach = append(detach, oldBlock)
+			oldBlock, err = parentBlock(txn, oldBlock)
+		} else {
+			attach = append(attach, newBlock)
+			newBlock, err = parentBlock(txn, newBlock)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	for oldBlock != nil && newBlock == nil {
+		detach = append(detach, oldBlock)
+		if oldBlock, err = parentBlock(txn, oldBlock); err != nil {
+			return nil, nil, err
+		}
+	}
+	for newBlock != nil && oldBlock == nil {
+		attach = append(attach, newBlock)
+		if newBlock, err = parentBlock(txn, newBlock); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, block := range detach {
+		if err := disconnectBlock(txn, block); err != nil {
+			return nil, nil, err
+		}
+	}
+	for i := len(attach) - 1; i >= 0; i-- {
+		if err := connectBlock(txn, attach[i]); err != nil {
+			return nil, nil, err
+		}
+		connected = append(connected, attach[i])
+	}
+	return detach, connected, nil
+}
+
+func parentBlock(txn StoreTxn, block *Block) (*Block, error) {
+	if len(block.PrevHash) == 0 {
+		return nil, nil
+	}
+	return getBlock(txn, block.PrevHash)
+}
+
+func (chain *BlockChain) hasHeightIndex() bool {
+	var indexed bool
+	err := chain.Database.View(func(txn StoreTxn) error {
+		tip, err := getBlock(txn, chain.LastHash)
+		if err != nil {
+			return err
+		}
+		hash, err := getHashAtHeight(txn, tip.Height)
+		indexed = err == nil && bytes.Equal(hash, chain.LastHash)
+		return nil
+	})
+	Handle(err)
+	return indexed
+}
+
+// heightBatchSize is the number of height keys written per transaction when
+// rebuilding the height index
+const heightBatchSize = 10000
+
+// ReIndexHeights rebuilds the height index by walking the main chain from the
+// tip. It writes in batches, the tip last, so that an interrupted rebuild
+// leaves no entry for the tip and is run again on the next start.
+func (chain *BlockChain) ReIndexHeights() {
+	var hashes [][]byte
+	err := chain.Database.View(func(txn StoreTxn) error {
+		for hash := chain.LastHash; len(hash) > 0; {
+			block, err := getBlock(txn, hash)
+			if err != nil {
+				return err
+			}
+			hashes = append(hashes, block.Hash)
+			hash = block.PrevHash
+		}
+		return nil
+	})
+	Handle(err)
+
+	err = chain.Database.Update(func(txn StoreTxn) error {
+		return txn.Delete(heightKey(len(hashes) - 1))
+	})
+	Handle(err)
+	utxo := UTXOSet{chain}
+	utxo.DeleteByPrefix(heightPrefix)
+
+	for height := 0; height < len(hashes); height += heightBatchSize {
+		err := chain.Database.Update(func(txn StoreTxn) error {
+			for h := height; h < height+heightBatchSize && h < len(hashes); h++ {
+				if err := txn.Set(heightKey(h), hashes[len(hashes)-1-h]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		Handle(err)
+	}
+}
+
+// GetBlockByHeight returns the main chain block at the given height
+func (chain *BlockChain) GetBlockByHeight(height int) (Block, error) {
+	var block Block
+	err := chain.Database.View(func(txn StoreTxn) error {
+		hash, err := getHashAtHeight(txn, height)
+		if err != nil {
+			return err
+		}
+		b, err := getBlock(txn, hash)
+		if err != nil {
+			return err
+		}
+		block = *b
+		return nil
+	})
+	return block, err
+}
+
+// GetBlockHashesInRange returns the hashes of the main chain blocks with
+// heights from..to inclusive, in ascending order. to is capped at the tip.
+func (chain *BlockChain) GetBlockHashesInRange(from, to int) [][]byte {
+	var hashes [][]byte
+	if from < 0 {
+		from = 0
+	}
+	err := chain.Database.View(func(txn StoreTxn) error {
+		return txn.Iterate(heightPrefix, heightKey(from), func(key, hash []byte) error {
+			height := int(bytesToInt64(bytes.TrimPrefix(key, heightPrefix)))
+			if to >= 0 && height > to {
+				return ErrStopIteration
+			}
+			hashes = append(hashes, hash)
+			return nil
+		})
+	})
+	Handle(err)
+	return hashes
+}
+
+// GetBlocksInRange returns the main chain blocks with heights from..to
+// inclusive, in ascending order
+func (chain *BlockChain) GetBlocksInRange(from, to int) []*Block {
+	var blocks []*Block
+	iter := chain.IteratorFrom(from)
+	for {
+		block := iter.Next()
+		if block == nil || (to >= 0 && block.Height > to) {
+			break
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+type BlockChainForwardIterator struct {
+	CurrentHeight int
+	Database      Store
+}
+
+// IteratorFrom returns an iterator walking the main chain from the given height towards the tip
+func (chain *BlockChain) IteratorFrom(height int) *BlockChainForwardIterator {
+	if height < 0 {
+		height = 0
+	}
+	return &BlockChainForwardIterator{height, chain.Database}
+}
+
+// Next returns the next block of the main chain or nil once the tip has been passed
+func (iter *BlockChainForwardIterator) Next() *Block {
+	var block *Block
+
+	err := iter.Database.View(func(txn StoreTxn) error {
+		hash, err := getHashAtHeight(txn, iter.CurrentHeight)
+		if err != nil {
+			return nil
+		}
+		block, err = getBlock(txn, hash)
+		return err
+	})
+	Handle(err)
+	if block != nil {
+		iter.CurrentHeight++
+	}
+	return block
+}
+
+func bytesToInt64(data []byte) int64 {
+	var num int64
+	for _, b := range data {
+		num = num<<8 | int64(b)
+	}
+	return num
+}
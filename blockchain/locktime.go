@@ -0,0 +1,84 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// LockTimeThreshold separates lock times by block height, below it, from
+// lock times by unix timestamp
+const LockTimeThreshold = 500000000
+
+var ErrTimelocked = errors.New("transaction is timelocked")
+
+// Timelock locks the outputs of a payment until a block height or time and
+// for a number of blocks after they are confirmed
+type Timelock struct {
+	LockTime    int64
+	AfterBlocks int
+}
+
+// IsFinal reports whether the lock time of the transaction allows it in a
+// block of the given height and timestamp
+func (tx *Transaction) IsFinal(height int, timestamp int64) bool {
+	switch {
+	case tx.LockTime == 0:
+		return true
+	case tx.LockTime < LockTimeThreshold:
+		return int64(height) >= tx.LockTime
+	}
+	return timestamp >= tx.LockTime
+}
+
+// DescribeLockTime prints a lock time as a height or a UTC time
+func DescribeLockTime(lockTime int64) string {
+	if lockTime < LockTimeThreshold {
+		return fmt.Sprintf("height %d", lockTime)
+	}
+	return time.Unix(lockTime, 0).UTC().Format(time.RFC3339)
+}
+
+// checkLocks checks the lock time of a transaction and the relative lock
+// times of its inputs, spending outputs confirmed at prevHeights, for a
+// block of the given height and timestamp
+func checkLocks(tx *Transaction, prevHeights []int, height int, timestamp int64) error {
+	if !tx.IsFinal(height, timestamp) {
+		return fmt.Errorf("%w until %s", ErrTimelocked, DescribeLockTime(tx.LockTime))
+	}
+	for inId, in := range tx.Inputs {
+		if in.Sequence > 0 && height-prevHeights[inId] < in.Sequence {
+			return fmt.Errorf("%w: input %d spends an output of height %d locked for %d blocks",
+				ErrTimelocked, inId, prevHeights[inId], in.Sequence)
+		}
+	}
+	return nil
+}
+
+// CheckLocks checks that the timelocks of a transaction allow it in the
+// next block
+func (chain *BlockChain) CheckLocks(tx *Transaction) error {
+	if tx.IsCoinbase() {
+		return nil
+	}
+	utxo := UTXOSet{chain}
+	var prevHeights []int
+	for _, in := range tx.Inputs {
+		out, err := utxo.GetUnspentOutput(in.Id, in.OutIndex)
+		if err != nil {
+			return err
+		}
+		prevHeights = append(prevHeights, out.Height)
+	}
+	return checkLocks(tx, prevHeights, chain.GetBestHeight()+1, time.Now().Unix())
+}
+
+// Spendable reports whether the timelocks of the output allow spending it
+// in a block of the given height and timestamp
+func (u UnspentOutput) Spendable(height int, timestamp int64) bool {
+	lockTime, afterBlocks := u.Output.Timelock()
+	if height-u.Height < afterBlocks {
+		return false
+	}
+	return (&Transaction{LockTime: lockTime}).IsFinal(height, timestamp)
+}
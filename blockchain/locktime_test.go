@@ -0,0 +1,113 @@
+package blockchain
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Harshjha3006/golang-blockchain/wallet"
+)
+
+// spendLocked signs the transaction spending a timelocked coin of w back to
+// w, with the lock time and sequence the coin requires. The builder refuses
+// coins that are still locked, so it is assembled by hand.
+func spendLocked(t *testing.T, chain *BlockChain, w *wallet.Wallet, txId []byte, index int) *Transaction {
+	t.Helper()
+	coin, err := UTXOSet{chain}.GetUnspentOutput(txId, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lockTime, afterBlocks := coin.Output.Timelock()
+	spend := Transaction{nil, []TxInput{{Id: txId, OutIndex: index, Sequence: afterBlocks}},
+		[]TxOutput{*NewTXOutput(address(w), coin.Output.Value)}, lockTime, nil, nil, nil}
+	spend.setId()
+	if !spend.signWith(0, w, coin.Output) {
+		t.Fatalf("%s cannot sign for the coin", address(w))
+	}
+	return &spend
+}
+
+func TestTimelocks(t *testing.T) {
+	alice, bob, carol := wallet.MakeWallet(), wallet.MakeWallet(), wallet.MakeWallet()
+	chain := newTestChain(t, alice, false, false)
+
+	tx, err := buildTx(chain, alice, func(b *TxBuilder) error {
+		if err := b.AddLockedRecipient(address(bob), 30, Timelock{LockTime: 4}); err != nil {
+			return err
+		}
+		return b.AddLockedRecipient(address(carol), 20, Timelock{AfterBlocks: 2})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mine(t, chain, alice, tx)
+
+	if coins := (UTXOSet{chain}).FindSpendableCoins([][]byte{wallet.PubkeyHash(bob.PublicKey)}); len(coins) != 0 {
+		t.Error("a coin locked until height 4 is spendable at height 2")
+	}
+	toBob := spendLocked(t, chain, bob, tx.Id, 0)
+	toCarol := spendLocked(t, chain, carol, tx.Id, 1)
+	if toBob.LockTime != 4 || toCarol.Inputs[0].Sequence != 2 {
+		t.Fatalf("spends carry lock time %d and sequence %d", toBob.LockTime, toCarol.Inputs[0].Sequence)
+	}
+	for _, spend := range []*Transaction{toBob, toCarol} {
+		if err := chain.VerifyTransaction(spend); err != nil {
+			t.Fatalf("spend of locked coins does not verify: %v", err)
+		}
+	}
+
+	// height 2
+	for _, spend := range []*Transaction{toBob, toCarol} {
+		if err := chain.CheckLocks(spend); !errors.Is(err, ErrTimelocked) {
+			t.Errorf("spend at height 2: %v", err)
+		}
+		if _, err := chain.MineBlock([]*Transaction{CoinbaseTx(address(alice), ""), spend}); !errors.Is(err, ErrTimelocked) {
+			t.Errorf("block spending at height 2: %v", err)
+		}
+	}
+	mine(t, chain, alice)
+
+	// height 3: the relative lock of carol's coin has passed
+	if err := chain.CheckLocks(toBob); !errors.Is(err, ErrTimelocked) {
+		t.Errorf("spend at height 3: %v", err)
+	}
+	if err := chain.CheckLocks(toCarol); err != nil {
+		t.Errorf("spend at height 3: %v", err)
+	}
+	mine(t, chain, alice, toCarol)
+
+	// height 4
+	if err := chain.CheckLocks(toBob); err != nil {
+		t.Errorf("spend at height 4: %v", err)
+	}
+	mine(t, chain, alice, toBob)
+	if balance(chain, bob) != 30 || balance(chain, carol) != 20 {
+		t.Errorf("bob has %d and carol %d", balance(chain, bob), balance(chain, carol))
+	}
+	checkUndo(t, chain)
+}
+
+func TestTimelockedScriptNeedsLockTime(t *testing.T) {
+	alice, bob := wallet.MakeWallet(), wallet.MakeWallet()
+	chain := newTestChain(t, alice, false, false)
+	tx, err := buildTx(chain, alice, func(b *TxBuilder) error {
+		return b.AddLockedRecipient(address(bob), 30, Timelock{LockTime: 2})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mine(t, chain, alice, tx)
+
+	// a spend signed without the lock time the script requires
+	coin, err := UTXOSet{chain}.GetUnspentOutput(tx.Id, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spend := Transaction{nil, []TxInput{{Id: tx.Id, OutIndex: 0}}, []TxOutput{*NewTXOutput(address(bob), 30)}, 0, nil, nil, nil}
+	spend.setId()
+	if !spend.signWith(0, bob, coin.Output) {
+		t.Fatal("bob cannot sign for the coin")
+	}
+	if spend.verifyInput(0, coin.Output) {
+		t.Error("a spend without lock time unlocks a coin locked by height")
+	}
+}
@@ -0,0 +1,109 @@
+package blockchain
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+)
+
+// MemoryStore keeps everything in memory, it is meant for tests and
+// throwaway chains
+type MemoryStore struct {
+	mu   sync.RWMutex
+	data map[string][]byte
+}
+
+type memoryTxn struct {
+	store    *MemoryStore
+	writable bool
+	writes   map[string][]byte // pending writes, nil values are deletions
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{data: make(map[string][]byte)}
+}
+
+func (s *MemoryStore) View(fn func(txn StoreTxn) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return fn(&memoryTxn{store: s})
+}
+
+func (s *MemoryStore) Update(fn func(txn StoreTxn) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	txn := &memoryTxn{s, true, make(map[string][]byte)}
+	if err := fn(txn); err != nil {
+		return err
+	}
+	for key, value := range txn.writes {
+		if value == nil {
+			delete(s.data, key)
+		} else {
+			s.data[key] = value
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+func (t *memoryTxn) Get(key []byte) ([]byte, error) {
+	value, ok := t.writes[string(key)]
+	if !ok {
+		value, ok = t.store.data[string(key)]
+	}
+	if !ok || value == nil {
+		return nil, ErrKeyNotFound
+	}
+	return append([]byte{}, value...), nil
+}
+
+func (t *memoryTxn) Set(key, value []byte) error {
+	if !t.writable {
+		return errReadOnly
+	}
+	t.writes[string(key)] = append([]byte{}, value...)
+	return nil
+}
+
+func (t *memoryTxn) Delete(key []byte) error {
+	if !t.writable {
+		return errReadOnly
+	}
+	t.writes[string(key)] = nil
+	return nil
+}
+
+func (t *memoryTxn) Iterate(prefix, start []byte, fn func(key, value []byte) error) error {
+	if start == nil || bytes.Compare(start, prefix) < 0 {
+		start = prefix
+	}
+	seen := make(map[string]bool)
+	var keys []string
+	for _, data := range []map[string][]byte{t.writes, t.store.data} {
+		for key := range data {
+			if !seen[key] && bytes.HasPrefix([]byte(key), prefix) && key >= string(start) {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value, err := t.Get([]byte(key))
+		if err == ErrKeyNotFound {
+			continue
+		}
+		if err := fn([]byte(key), value); err == ErrStopIteration {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
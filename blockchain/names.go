@@ -0,0 +1,245 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/Harshjha3006/golang-blockchain/wallet"
+)
+
+// Name operations
+const (
+	NameRegister = "register"
+	NameRenew    = "renew"
+	NameTransfer = "transfer"
+)
+
+const (
+	// NameFee is burnt by the transactions registering or renewing a name
+	NameFee = 10
+	// NameLifetime is the number of blocks a name registration or renewal
+	// lasts
+	NameLifetime = 1000
+)
+
+// NameOperation claims a name for an owner, renews it, or transfers it to a
+// new owner. Renewals and transfers must spend an input of the owner.
+type NameOperation struct {
+	Op    string
+	Name  string
+	Owner []byte // pubkey hash the name resolves to, the new owner of a transfer
+}
+
+// NameRecord is an entry of the name index
+type NameRecord struct {
+	Name       string
+	Owner      []byte
+	Registered int // height of the block registering it
+	Expires    int // height from which the name can be registered again
+}
+
+var (
+	namePrefix     = []byte("name-")     // name -> NameRecord
+	nameUndoPrefix = []byte("nameundo-") // tx id -> NameRecord replaced by the tx
+)
+
+var nameFormat = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+var ErrUnknownName = errors.New("unknown name")
+
+func nameKey(name string) []byte {
+	return append(append([]byte{}, namePrefix...), name...)
+}
+
+func nameUndoKey(txId []byte) []byte {
+	return append(append([]byte{}, nameUndoPrefix...), txId...)
+}
+
+func (r NameRecord) Serialize() []byte {
+	var res bytes.Buffer
+	err := gob.NewEncoder(&res).Encode(r)
+	Handle(err)
+	return res.Bytes()
+}
+
+func DeserializeNameRecord(data []byte) NameRecord {
+	var r NameRecord
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&r)
+	Handle(err)
+	return r
+}
+
+// Address is the address the name resolves to
+func (r NameRecord) Address() string {
+	return string(wallet.AddressFromPubKeyHash(r.Owner))
+}
+
+// Fee is the amount a transaction with the operation burns
+func (op *NameOperation) Fee() int {
+	if op == nil || op.Op == NameTransfer {
+		return 0
+	}
+	return NameFee
+}
+
+func getNameRecord(txn StoreTxn, name string) (NameRecord, error) {
+	data, err := txn.Get(nameKey(name))
+	if err != nil {
+		return NameRecord{}, fmt.Errorf("%w %s", ErrUnknownName, name)
+	}
+	return DeserializeNameRecord(data), nil
+}
+
+// checkNameOperation checks the name operation of a transaction against the
+// name index, for a transaction in a block of the given height
+func checkNameOperation(txn StoreTxn, tx *Transaction, prevOuts []TxOutput, height int) error {
+	op := tx.Name
+	if op == nil {
+		return nil
+	}
+	if tx.IsCoinbase() {
+		return errors.New("coinbase cannot operate on names")
+	}
+	if !nameFormat.MatchString(op.Name) {
+		return fmt.Errorf("invalid name %q", op.Name)
+	}
+	burnt := 0
+	for _, prevOut := range prevOuts {
+		burnt += prevOut.Value
+	}
+	for _, out := range tx.Outputs {
+		burnt -= out.Value
+	}
+	if burnt < op.Fee() {
+		return fmt.Errorf("name %s needs a fee of %d, the transaction burns %d", op.Name, op.Fee(), burnt)
+	}
+
+	record, err := getNameRecord(txn, op.Name)
+	active := err == nil && height < record.Expires
+	switch op.Op {
+	case NameRegister:
+		if active {
+			return fmt.Errorf("name %s is taken until height %d", op.Name, record.Expires)
+		}
+		if len(op.Owner) != 20 {
+			return errors.New("name owner must be a pubkey hash")
+		}
+		return nil
+	case NameRenew, NameTransfer:
+		if !active {
+			return fmt.Errorf("name %s is not registered", op.Name)
+		}
+		if op.Op == NameTransfer && len(op.Owner) != 20 {
+			return errors.New("name owner must be a pubkey hash")
+		}
+		for _, prevOut := range prevOuts {
+			if bytes.Equal(prevOut.AddressHash(), record.Owner) {
+				return nil
+			}
+		}
+		return fmt.Errorf("only the owner of name %s can %s it", op.Name, op.Op)
+	}
+	return fmt.Errorf("unknown name operation %q", op.Op)
+}
+
+// applyNameOperation updates the name index with the name operation of a
+// transaction, keeping the record it replaces to undo it
+func applyNameOperation(txn StoreTxn, tx *Transaction, height int) error {
+	op := tx.Name
+	if op == nil {
+		return nil
+	}
+	var replaced []byte
+	record, err := getNameRecord(txn, op.Name)
+	if err == nil {
+		replaced = record.Serialize()
+	}
+	switch op.Op {
+	case NameRegister:
+		record = NameRecord{op.Name, op.Owner, height, height + NameLifetime}
+	case NameRenew:
+		record.Expires += NameLifetime
+	case NameTransfer:
+		record.Owner = op.Owner
+	}
+	if err := txn.Set(nameUndoKey(tx.Id), replaced); err != nil {
+		return err
+	}
+	return txn.Set(nameKey(op.Name), record.Serialize())
+}
+
+// revertNameOperation undoes applyNameOperation
+func revertNameOperation(txn StoreTxn, tx *Transaction) error {
+	op := tx.Name
+	if op == nil {
+		return nil
+	}
+	replaced, err := txn.Get(nameUndoKey(tx.Id))
+	if err != nil {
+		return errors.New("Undo data of the name operation is missing, run reindexutxo")
+	}
+	if err := txn.Delete(nameUndoKey(tx.Id)); err != nil {
+		return err
+	}
+	if len(replaced) == 0 {
+		return txn.Delete(nameKey(op.Name))
+	}
+	return txn.Set(nameKey(op.Name), replaced)
+}
+
+// CheckNameOperation checks the name operation of a transaction against the
+// name index, for the next block
+func (chain *BlockChain) CheckNameOperation(tx *Transaction) error {
+	if tx.Name == nil {
+		return nil
+	}
+	prevOuts, err := UTXOSet{chain}.prevOutputs(tx)
+	if err != nil {
+		return err
+	}
+	height := chain.GetBestHeight() + 1
+	return chain.Database.View(func(txn StoreTxn) error {
+		return checkNameOperation(txn, tx, prevOuts, height)
+	})
+}
+
+// ResolveName looks up the record of a registered name which has not expired
+func (chain *BlockChain) ResolveName(name string) (NameRecord, error) {
+	var record NameRecord
+	err := chain.Database.View(func(txn StoreTxn) error {
+		var err error
+		record, err = getNameRecord(txn, name)
+		return err
+	})
+	if err != nil {
+		return record, err
+	}
+	if chain.GetBestHeight() >= record.Expires {
+		return record, fmt.Errorf("name %s expired at height %d", name, record.Expires)
+	}
+	return record, nil
+}
+
+// GetNames lists the names owned by a pubkey hash which have not expired
+func (chain *BlockChain) GetNames(owner []byte) []NameRecord {
+	var records []NameRecord
+	height := chain.GetBestHeight()
+	err := chain.Database.View(func(txn StoreTxn) error {
+		return txn.Iterate(namePrefix, nil, func(_, v []byte) error {
+			record := DeserializeNameRecord(v)
+			if bytes.Equal(record.Owner, owner) && height < record.Expires {
+				records = append(records, record)
+			}
+			return nil
+		})
+	})
+	Handle(err)
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Name < records[j].Name
+	})
+	return records
+}
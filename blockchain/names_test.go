@@ -0,0 +1,91 @@
+package blockchain
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/Harshjha3006/golang-blockchain/wallet"
+)
+
+func nameTx(t *testing.T, chain *BlockChain, from *wallet.Wallet, op NameOperation) *Transaction {
+	t.Helper()
+	tx, err := buildTx(chain, from, func(b *TxBuilder) error {
+		b.SetNameOperation(op)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func TestNames(t *testing.T) {
+	alice, bob := wallet.MakeWallet(), wallet.MakeWallet()
+	chain := newTestChain(t, alice, false, false)
+	mine(t, chain, alice, pay(t, chain, alice, address(bob), 30))
+	aliceHash, bobHash := wallet.PubkeyHash(alice.PublicKey), wallet.PubkeyHash(bob.PublicKey)
+
+	register := nameTx(t, chain, alice, NameOperation{NameRegister, "alice", aliceHash})
+	if err := chain.CheckNameOperation(register); err != nil {
+		t.Fatal(err)
+	}
+	mine(t, chain, alice, register)
+	record, err := chain.ResolveName("alice")
+	if err != nil || !bytes.Equal(record.Owner, aliceHash) || record.Expires != 2+NameLifetime {
+		t.Fatalf("alice resolves to %+v, %v", record, err)
+	}
+	if got := balance(chain, alice); got != 300-30-NameFee {
+		t.Errorf("alice has %d after paying the name fee", got)
+	}
+
+	invalid := []NameOperation{
+		{NameRegister, "alice", bobHash},        // taken
+		{NameTransfer, "alice", bobHash},        // bob does not own it
+		{NameRenew, "alice", nil},               // bob does not own it
+		{NameRegister, "Not A Name", bobHash},   // invalid
+		{NameRegister, "bob", []byte("short")},  // owner is not a pubkey hash
+		{NameTransfer, "unregistered", bobHash}, // not registered
+		{"delete", "alice", nil},                // unknown operation
+	}
+	for _, op := range invalid {
+		if err := chain.CheckNameOperation(nameTx(t, chain, bob, op)); err == nil {
+			t.Errorf("name operation %s %s by bob is accepted", op.Op, op.Name)
+		}
+	}
+	underpaid := DeserializeTransaction(nameTx(t, chain, bob, NameOperation{NameRegister, "bob", bobHash}).Serialize())
+	underpaid.Outputs[0].Value += NameFee
+	if err := chain.CheckNameOperation(&underpaid); err == nil {
+		t.Error("a name is registered without burning its fee")
+	}
+
+	transfer := nameTx(t, chain, alice, NameOperation{NameTransfer, "alice", bobHash})
+	mine(t, chain, alice, transfer)
+	if record, err := chain.ResolveName("alice"); err != nil || !bytes.Equal(record.Owner, bobHash) {
+		t.Errorf("alice resolves to %+v after the transfer, %v", record, err)
+	}
+	if names := chain.GetNames(bobHash); len(names) != 1 || names[0].Name != "alice" {
+		t.Errorf("bob owns %+v", names)
+	}
+	withReverted(t, chain, 1, func(txn StoreTxn) {
+		if record, _ := getNameRecord(txn, "alice"); !bytes.Equal(record.Owner, aliceHash) {
+			t.Error("the name keeps its new owner with the transfer reverted")
+		}
+	})
+
+	renew := nameTx(t, chain, bob, NameOperation{Op: NameRenew, Name: "alice"})
+	if err := chain.CheckNameOperation(renew); err != nil {
+		t.Fatal(err)
+	}
+	mine(t, chain, alice, renew)
+	if record, _ := chain.ResolveName("alice"); record.Expires != 2+2*NameLifetime {
+		t.Errorf("renewed name expires at %d", record.Expires)
+	}
+	checkUndo(t, chain)
+
+	// reverting the registration frees the name
+	withReverted(t, chain, 3, func(txn StoreTxn) {
+		if _, err := getNameRecord(txn, "alice"); err == nil {
+			t.Error("name is registered with its registration reverted")
+		}
+	})
+}
@@ -0,0 +1,85 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// MaxURISize is the largest URI an NFT can point to
+const MaxURISize = 256
+
+// NFTMetadata describes the asset a non-fungible token stands for. An NFT is
+// a token with a supply of one which cannot be minted again: as tokens
+// balance, it is always carried by exactly one unspent output.
+type NFTMetadata struct {
+	ContentHash []byte // sha256 of the asset
+	URI         string // where the asset can be fetched
+}
+
+var nftPrefix = []byte("nft-") // content hash -> token id
+
+var ErrNotNFT = errors.New("token is not an NFT")
+
+func nftKey(contentHash []byte) []byte {
+	return append(append([]byte{}, nftPrefix...), contentHash...)
+}
+
+// IsNFT tells whether the token is non-fungible
+func (t Token) IsNFT() bool {
+	return t.Metadata != nil
+}
+
+// checkNFT checks the minting of a new NFT: a single token whose content
+// was never minted before
+func checkNFT(txn StoreTxn, issuance *TokenIssuance) error {
+	metadata := issuance.Metadata
+	if issuance.Supply != 1 || issuance.Mintable {
+		return errors.New("an NFT has a fixed supply of one")
+	}
+	if len(metadata.ContentHash) != sha256.Size {
+		return fmt.Errorf("NFT content hash must be %d bytes", sha256.Size)
+	}
+	if len(metadata.URI) > MaxURISize {
+		return fmt.Errorf("NFT URI is longer than %d bytes", MaxURISize)
+	}
+	if tokenId, err := txn.Get(nftKey(metadata.ContentHash)); err == nil {
+		return fmt.Errorf("content %x is already minted as NFT %x", metadata.ContentHash, tokenId)
+	}
+	return nil
+}
+
+// GetNFT looks up an NFT by its hex id
+func (chain *BlockChain) GetNFT(id string) (Token, error) {
+	token, err := chain.GetToken(id)
+	if err != nil {
+		return token, err
+	}
+	if !token.IsNFT() {
+		return token, fmt.Errorf("%w: %s", ErrNotNFT, id)
+	}
+	return token, nil
+}
+
+// FindNFTs returns the NFTs owned by a pubkey hash
+func (u UTXOSet) FindNFTs(pubKeyHash []byte) []Token {
+	var nfts []Token
+	outs := u.FindUTXO(pubKeyHash)
+	err := u.Blockchain.Database.View(func(txn StoreTxn) error {
+		for _, out := range outs {
+			if out.TokenId == nil {
+				continue
+			}
+			token, err := getToken(txn, out.TokenId)
+			if err != nil {
+				return err
+			}
+			if token.IsNFT() {
+				nfts = append(nfts, token)
+			}
+		}
+		return nil
+	})
+	Handle(err)
+	return nfts
+}
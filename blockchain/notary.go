@@ -0,0 +1,37 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/Harshjha3006/golang-blockchain/script"
+)
+
+// FindData looks up the earliest main chain transaction with an output
+// carrying data, and the block it is in
+func (chain *BlockChain) FindData(data []byte) (*Transaction, *Block, error) {
+	var found *Transaction
+	var foundIn *Block
+
+	iter := chain.Iterator()
+	for {
+		block := iter.Next()
+		for _, tx := range block.Transactions {
+			for _, out := range tx.Outputs {
+				if !out.IsData() {
+					continue
+				}
+				if carried, err := script.ExtractNullData(out.Script); err == nil && bytes.Equal(carried, data) {
+					found, foundIn = tx, block
+				}
+			}
+		}
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+	if found == nil {
+		return nil, nil, errors.New("no transaction carries the data")
+	}
+	return found, foundIn, nil
+}
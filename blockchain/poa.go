@@ -0,0 +1,215 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Harshjha3006/golang-blockchain/wallet"
+)
+
+// PoA is the proof of authority engine: blocks are signed by one of a fixed
+// set of signers, who take turns in their configured order. A block signed
+// by the signer in turn weighs more than one signed out of turn, so other
+// signers can stand in for a missing signer without outweighing the chain
+// of the signers in turn. Under SignerLimitRules a signer signs at most one
+// of SignerLimit consecutive blocks, so that a minority of signers cannot
+// produce the chain alone, and blocks are AuthorityPeriod seconds apart.
+type PoA struct {
+	Signers [][]byte // pubkey hashes
+	db      Store
+	rules   int
+	keys    KeySource
+}
+
+const (
+	inTurnWeight    = 2
+	outOfTurnWeight = 1
+
+	// AuthorityPeriod is the minimum number of seconds between proof of
+	// authority blocks
+	AuthorityPeriod = 5
+)
+
+var ErrSignedRecently = errors.New("signer signed one of the recent blocks")
+
+func (e *PoA) Name() string {
+	return ConsensusPoA
+}
+
+// InTurn is the pubkey hash of the signer in turn at a height
+func (e *PoA) InTurn(height int) []byte {
+	return e.Signers[height%len(e.Signers)]
+}
+
+func (e *PoA) isSigner(pubKeyHash []byte) bool {
+	for _, signer := range e.Signers {
+		if bytes.Equal(signer, pubKeyHash) {
+			return true
+		}
+	}
+	return false
+}
+
+// SignerLimit is the number of consecutive blocks a signer signs at most one
+// of
+func (e *PoA) SignerLimit() int {
+	return len(e.Signers)/2 + 1
+}
+
+func (e *PoA) parent(block *Block) (*Block, error) {
+	if block.Height == 0 {
+		return nil, nil
+	}
+	if e.db == nil {
+		return nil, errors.New("parent block is unknown")
+	}
+	var parent *Block
+	err := e.db.View(func(txn StoreTxn) error {
+		var err error
+		parent, err = getBlock(txn, block.PrevHash)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("parent of block %x is unknown", block.Hash)
+	}
+	return parent, nil
+}
+
+// recentSigners returns the pubkey hashes of the signers of the blocks
+// before a block that its signer must not have signed
+func (e *PoA) recentSigners(block *Block) (map[string]bool, error) {
+	recent := make(map[string]bool)
+	if block.Height == 0 || e.rules < SignerLimitRules {
+		return recent, nil
+	}
+	if e.db == nil {
+		return nil, errors.New("parent block is unknown")
+	}
+	err := e.db.View(func(txn StoreTxn) error {
+		hash := block.PrevHash
+		for i := 1; i < e.SignerLimit() && len(hash) > 0; i++ {
+			ancestor, err := getBlock(txn, hash)
+			if err != nil {
+				return fmt.Errorf("parent of block %x is unknown", block.Hash)
+			}
+			recent[string(wallet.PubkeyHash(ancestor.Signer))] = true
+			hash = ancestor.PrevHash
+		}
+		return nil
+	})
+	return recent, err
+}
+
+// checkPeriod fails if a block is timestamped less than AuthorityPeriod
+// seconds after its parent
+func (e *PoA) checkPeriod(block *Block) error {
+	if e.rules < SignerLimitRules {
+		return nil
+	}
+	parent, err := e.parent(block)
+	if err != nil {
+		return err
+	}
+	if parent != nil && block.Timstamp < parent.Timstamp+AuthorityPeriod {
+		next := time.Unix(parent.Timstamp+AuthorityPeriod, 0)
+		return fmt.Errorf("block period has not passed, the next block can be signed at %s", next.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// Prepare picks the signer of the block among the signers this node holds
+// keys for and who did not sign recently, the signer in turn first. It fails
+// if the block period since the parent has not passed yet.
+func (e *PoA) Prepare(block *Block) error {
+	if e.keys == nil {
+		return ErrNoSigningKey
+	}
+	recent, err := e.recentSigners(block)
+	if err != nil {
+		return err
+	}
+	signedRecently := false
+	for i := range e.Signers {
+		signer := e.InTurn(block.Height + i)
+		w, err := e.keys(signer)
+		if errors.Is(err, wallet.ErrWalletLocked) {
+			return err
+		}
+		if err != nil {
+			continue
+		}
+		if recent[string(signer)] {
+			signedRecently = true
+			continue
+		}
+		if err := e.checkPeriod(block); err != nil {
+			return err
+		}
+		block.Signer = w.PublicKey
+		block.Nonce = 0
+		return nil
+	}
+	if signedRecently {
+		return ErrSignedRecently
+	}
+	return ErrNoSigningKey
+}
+
+func (e *PoA) Seal(block *Block) error {
+	if e.keys == nil {
+		return ErrNoSigningKey
+	}
+	w, err := e.keys(wallet.PubkeyHash(block.Signer))
+	if err != nil {
+		return err
+	}
+	block.Hash = e.headerHash(block)
+	block.Signature = signData(w.PrivateKey, block.Hash)
+	return nil
+}
+
+func (e *PoA) VerifySeal(block *Block) error {
+	if !bytes.Equal(e.headerHash(block), block.Hash) {
+		return fmt.Errorf("block %x does not match its header", block.Hash)
+	}
+	if !e.isSigner(wallet.PubkeyHash(block.Signer)) {
+		return fmt.Errorf("block %x is not signed by an authorized signer", block.Hash)
+	}
+	if !verifyData(block.Signer, block.Signature, block.Hash) {
+		return fmt.Errorf("block %x has an invalid signature", block.Hash)
+	}
+	if e.rules < SignerLimitRules {
+		return nil
+	}
+	if block.Timstamp > time.Now().Unix()+AuthorityPeriod {
+		return fmt.Errorf("block %x is from the future", block.Hash)
+	}
+	if err := e.checkPeriod(block); err != nil {
+		return fmt.Errorf("block %x: %w", block.Hash, err)
+	}
+	recent, err := e.recentSigners(block)
+	if err != nil {
+		return err
+	}
+	if recent[string(wallet.PubkeyHash(block.Signer))] {
+		return fmt.Errorf("block %x: %w", block.Hash, ErrSignedRecently)
+	}
+	return nil
+}
+
+func (e *PoA) Weight(block *Block) int {
+	if bytes.Equal(wallet.PubkeyHash(block.Signer), e.InTurn(block.Height)) {
+		return inTurnWeight
+	}
+	return outOfTurnWeight
+}
+
+func (e *PoA) headerHash(block *Block) []byte {
+	data := bytes.Join([][]byte{block.HashTransactions(), block.PrevHash, ToHex(int64(block.Height)),
+		ToHex(block.Timstamp), block.Signer}, []byte{})
+	hash := sha256.Sum256(data)
+	return hash[:]
+}
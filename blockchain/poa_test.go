@@ -0,0 +1,194 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Harshjha3006/golang-blockchain/wallet"
+)
+
+// testKeys holds the wallets sealing blocks, by pubkey hash
+type testKeys map[string]*wallet.Wallet
+
+func keysOf(wallets ...*wallet.Wallet) testKeys {
+	keys := make(testKeys)
+	for _, w := range wallets {
+		keys[string(wallet.PubkeyHash(w.PublicKey))] = w
+	}
+	return keys
+}
+
+func (k testKeys) source(pubKeyHash []byte) (*wallet.Wallet, error) {
+	w, ok := k[string(pubKeyHash)]
+	if !ok {
+		return nil, ErrNoSigningKey
+	}
+	return w, nil
+}
+
+// newAuthorityChain creates a proof of authority chain in memory following
+// rules, with a genesis block signed by the first signer a day ago
+func newAuthorityChain(t *testing.T, rules int, signers ...*wallet.Wallet) *BlockChain {
+	t.Helper()
+	params := ChainParams{Consensus: ConsensusPoA, Rules: rules}
+	for _, w := range signers {
+		params.Signers = append(params.Signers, wallet.PubkeyHash(w.PublicKey))
+	}
+	keys := keysOf(signers...).source
+	engine, err := NewConsensus(params, nil, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesis := &Block{time.Now().Add(-24 * time.Hour).Unix(), []byte{},
+		[]*Transaction{CoinbaseTx(address(signers[0]), genesisData)}, []byte{}, 0, 0, nil, nil}
+	if err := engine.Prepare(genesis); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.Seal(genesis); err != nil {
+		t.Fatal(err)
+	}
+	return initStore(NewMemoryStore(), params, keys, genesis, false, false)
+}
+
+// signBlock prepares and seals a block on parent, timestamped seconds after
+// it, with the keys of the signers given
+func signBlock(chain *BlockChain, parent *Block, seconds int64, signers ...*wallet.Wallet) (*Block, error) {
+	engine := chain.Engine.(*PoA)
+	engine = &PoA{engine.Signers, engine.db, engine.rules, keysOf(signers...).source}
+	block := &Block{parent.Timstamp + seconds, []byte{},
+		[]*Transaction{CoinbaseTx(address(signers[0]), "")}, parent.Hash, parent.Height + 1, 0, nil, nil}
+	if err := engine.Prepare(block); err != nil {
+		return nil, err
+	}
+	return block, engine.Seal(block)
+}
+
+// forceSign seals a block on parent signed by w, skipping the checks of
+// Prepare
+func forceSign(t *testing.T, chain *BlockChain, parent *Block, seconds int64, w *wallet.Wallet) *Block {
+	t.Helper()
+	block := &Block{parent.Timstamp + seconds, []byte{},
+		[]*Transaction{CoinbaseTx(address(w), "")}, parent.Hash, parent.Height + 1, 0, w.PublicKey, nil}
+	if err := chain.Engine.Seal(block); err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+func TestAuthoritySignerLimit(t *testing.T) {
+	alice, bob, carol := wallet.MakeWallet(), wallet.MakeWallet(), wallet.MakeWallet()
+	chain := newAuthorityChain(t, CurrentRules, alice, bob, carol)
+	if limit := chain.Engine.(*PoA).SignerLimit(); limit != 2 {
+		t.Fatalf("signer limit of 3 signers is %d, want 2", limit)
+	}
+
+	block, err := signBlock(chain, tip(t, chain), AuthorityPeriod, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.AddBlock(block); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := signBlock(chain, block, AuthorityPeriod, bob); !errors.Is(err, ErrSignedRecently) {
+		t.Errorf("preparing a second block in a row: %v", err)
+	}
+	if err := chain.AddBlock(forceSign(t, chain, block, AuthorityPeriod, bob)); !errors.Is(err, ErrSignedRecently) {
+		t.Errorf("adding a second block in a row of a signer: %v", err)
+	}
+	// the signers holding other keys stand in
+	next, err := signBlock(chain, block, AuthorityPeriod, bob, carol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(next.Signer, carol.PublicKey) {
+		t.Error("a signer who signed the parent signs again")
+	}
+	if err := chain.AddBlock(next); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(chain.LastHash, next.Hash) {
+		t.Error("the chain does not extend with a block of another signer")
+	}
+}
+
+func TestAuthorityPeriod(t *testing.T) {
+	alice, bob, carol := wallet.MakeWallet(), wallet.MakeWallet(), wallet.MakeWallet()
+	chain := newAuthorityChain(t, CurrentRules, alice, bob, carol)
+	genesis := tip(t, chain)
+
+	if _, err := signBlock(chain, genesis, AuthorityPeriod-1, bob); err == nil {
+		t.Error("a block is prepared before the period passed")
+	}
+	if err := chain.AddBlock(forceSign(t, chain, genesis, AuthorityPeriod-1, bob)); err == nil {
+		t.Error("a block signed before the period passed is accepted")
+	}
+	future := forceSign(t, chain, genesis, int64(48*time.Hour/time.Second), bob)
+	if err := chain.AddBlock(future); err == nil {
+		t.Error("a block from the future is accepted")
+	}
+	if !bytes.Equal(chain.LastHash, genesis.Hash) {
+		t.Error("a rejected block moved the tip")
+	}
+}
+
+func TestAuthorityBaseRules(t *testing.T) {
+	alice, bob, carol := wallet.MakeWallet(), wallet.MakeWallet(), wallet.MakeWallet()
+	chain := newAuthorityChain(t, BaseRules, alice, bob, carol)
+
+	// chains created before the signer limit keep accepting the blocks they
+	// accepted
+	block := forceSign(t, chain, tip(t, chain), 1, bob)
+	if err := chain.AddBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	block = forceSign(t, chain, block, 1, bob)
+	if err := chain.AddBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(chain.LastHash, block.Hash) {
+		t.Error("the chain does not extend under BaseRules")
+	}
+}
+
+func TestDoubleSigns(t *testing.T) {
+	alice, bob, carol := wallet.MakeWallet(), wallet.MakeWallet(), wallet.MakeWallet()
+	chain := newAuthorityChain(t, CurrentRules, alice, bob, carol)
+	genesis := tip(t, chain)
+
+	first := forceSign(t, chain, genesis, AuthorityPeriod, bob)
+	second := forceSign(t, chain, genesis, AuthorityPeriod+1, bob)
+	for _, block := range []*Block{first, second} {
+		if err := chain.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	doubles := chain.DoubleSigns()
+	if len(doubles) != 1 {
+		t.Fatalf("%d double signs recorded, want 1", len(doubles))
+	}
+	double := doubles[0]
+	if !bytes.Equal(double.Signer, wallet.PubkeyHash(bob.PublicKey)) || double.Height != 1 ||
+		!bytes.Equal(double.First, first.Hash) || !bytes.Equal(double.Second, second.Hash) {
+		t.Errorf("double sign recorded as %+v", double)
+	}
+}
+
+func TestProofOfWorkRules(t *testing.T) {
+	alice := wallet.MakeWallet()
+	block := &Block{time.Now().Unix(), []byte{}, []*Transaction{CoinbaseTx(address(alice), "")}, []byte{}, 0, 0, nil, nil}
+	moved := *block
+	moved.Timstamp++
+	moved.Height++
+
+	// from TimedRules the work commits to the timestamp and the height,
+	// blocks of chains created before keep their work
+	for rules, commits := range map[int]bool{BaseRules: false, TimedRules: true, CurrentRules: true} {
+		same := bytes.Equal(InitPow(block, rules).InitData(0), InitPow(&moved, rules).InitData(0))
+		if same == commits {
+			t.Errorf("rules %d: work commits to the timestamp and height: %v, want %v", rules, !same, commits)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/Harshjha3006/golang-blockchain/wallet"
+)
+
+const (
+	// StakeSlot is the number of seconds between blocks, after which the
+	// next staker in line may produce a block in place of a missing one
+	StakeSlot = 10
+	// maxStakeRounds bounds the stakers in line for a block
+	maxStakeRounds = 32
+)
+
+var ErrNotSelected = errors.New("no staker of this node is selected")
+
+// PoS is the proof of stake engine: the producer of a block is drawn among
+// the stakers in proportion to their active stake, from a seed derived from
+// the parent block. The block's nonce is its round: the staker drawn in
+// round 0 produces the block a slot after its parent, if it is missing the
+// staker drawn in round r may produce it r slots later, but the block weighs
+// less. While no stake is registered, the bootstrap signers of the chain
+// parameters produce blocks.
+type PoS struct {
+	Bootstrap [][]byte // pubkey hashes
+	db        Store
+	keys      KeySource
+}
+
+func (e *PoS) Name() string {
+	return ConsensusPoS
+}
+
+// stakers returns the stakers and their weights for a block, from the stake
+// registry as it was after its parent, on whichever chain the parent is
+func (e *PoS) stakers(block *Block) ([][]byte, []int, error) {
+	var stakers [][]byte
+	var weights []int
+	if e.db != nil && block.Height > 0 {
+		err := e.db.View(func(txn StoreTxn) error {
+			parent, err := getBlock(txn, block.PrevHash)
+			if err != nil {
+				return err
+			}
+			stakes, err := stakesAt(txn, parent)
+			if err != nil {
+				return err
+			}
+			stakers, weights = stakeWeights(stakes, block.Height)
+			return nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(stakers) == 0 {
+		stakers = e.Bootstrap
+		weights = nil
+		for range stakers {
+			weights = append(weights, 1)
+		}
+	}
+	return stakers, weights, nil
+}
+
+// Selected is the pubkey hash of the staker drawn to produce a block in a
+// round
+func (e *PoS) Selected(block *Block, round int) ([]byte, error) {
+	stakers, weights, err := e.stakers(block)
+	if err != nil {
+		return nil, err
+	}
+	total := 0
+	for _, weight := range weights {
+		total += weight
+	}
+	seed := sha256.Sum256(bytes.Join([][]byte{block.PrevHash, ToHex(int64(block.Height)), ToHex(int64(round))}, []byte{}))
+	draw := new(big.Int).Mod(new(big.Int).SetBytes(seed[:]), big.NewInt(int64(total))).Int64()
+	for i, weight := range weights {
+		if draw < int64(weight) {
+			return stakers[i], nil
+		}
+		draw -= int64(weight)
+	}
+	return nil, errors.New("no staker to select")
+}
+
+// roundStart is the time from which a block can be produced in a round
+func roundStart(parent *Block, round int) int64 {
+	return parent.Timstamp + int64((round+1)*StakeSlot)
+}
+
+func (e *PoS) parent(block *Block) (*Block, error) {
+	if block.Height == 0 {
+		return nil, nil
+	}
+	if e.db == nil {
+		return nil, errors.New("parent block is unknown")
+	}
+	var parent *Block
+	err := e.db.View(func(txn StoreTxn) error {
+		var err error
+		parent, err = getBlock(txn, block.PrevHash)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("parent of block %x is unknown", block.Hash)
+	}
+	return parent, nil
+}
+
+// Prepare picks the first round whose drawn staker this node holds the key
+// of, failing if that round has not started yet
+func (e *PoS) Prepare(block *Block) error {
+	if e.keys == nil {
+		return ErrNoSigningKey
+	}
+	parent, err := e.parent(block)
+	if err != nil {
+		return err
+	}
+	for round := 0; round < maxStakeRounds; round++ {
+		staker, err := e.Selected(block, round)
+		if err != nil {
+			return err
+		}
+		w, err := e.keys(staker)
+		if errors.Is(err, wallet.ErrWalletLocked) {
+			return err
+		}
+		if err != nil {
+			continue
+		}
+		if parent != nil && block.Timstamp < roundStart(parent, round) {
+			start := time.Unix(roundStart(parent, round), 0)
+			return fmt.Errorf("%w yet, round %d starts at %s", ErrNotSelected, round, start.Format(time.RFC3339))
+		}
+		block.Nonce = round
+		block.Signer = w.PublicKey
+		return nil
+	}
+	return ErrNotSelected
+}
+
+func (e *PoS) Seal(block *Block) error {
+	if e.keys == nil {
+		return ErrNoSigningKey
+	}
+	w, err := e.keys(wallet.PubkeyHash(block.Signer))
+	if err != nil {
+		return err
+	}
+	block.Hash = block.Header().Hash()
+	block.Signature = signData(w.PrivateKey, block.Hash)
+	return nil
+}
+
+func (e *PoS) VerifySeal(block *Block) error {
+	if !bytes.Equal(block.Header().Hash(), block.Hash) {
+		return fmt.Errorf("block %x does not match its header", block.Hash)
+	}
+	if !verifyData(block.Signer, block.Signature, block.Hash) {
+		return fmt.Errorf("block %x has an invalid signature", block.Hash)
+	}
+	if block.Nonce < 0 || block.Nonce >= maxStakeRounds {
+		return fmt.Errorf("block %x has an invalid round", block.Hash)
+	}
+	if block.Timstamp > time.Now().Unix()+StakeSlot {
+		return fmt.Errorf("block %x is from the future", block.Hash)
+	}
+	parent, err := e.parent(block)
+	if err != nil {
+		return err
+	}
+	if parent != nil && block.Timstamp < roundStart(parent, block.Nonce) {
+		return fmt.Errorf("block %x is produced before its round", block.Hash)
+	}
+	staker, err := e.Selected(block, block.Nonce)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(wallet.PubkeyHash(block.Signer), staker) {
+		return fmt.Errorf("block %x is not signed by the staker drawn for round %d", block.Hash, block.Nonce)
+	}
+	return nil
+}
+
+func (e *PoS) Weight(block *Block) int {
+	if block.Nonce == 0 {
+		return inTurnWeight
+	}
+	return outOfTurnWeight
+}
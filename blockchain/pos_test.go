@@ -0,0 +1,387 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Harshjha3006/golang-blockchain/wallet"
+)
+
+// stakeNode is a node of an in-process proof of stake network, keeping its
+// own chain and holding the key of one wallet
+type stakeNode struct {
+	w     *wallet.Wallet
+	chain *BlockChain
+}
+
+type stakeNetwork struct {
+	t     *testing.T
+	nodes []*stakeNode
+}
+
+// newStakeNetwork starts a node for each wallet, the first one being the
+// bootstrap signer. The genesis block is a day old so that blocks can be
+// timestamped at the start of their round without waiting for it.
+func newStakeNetwork(t *testing.T, wallets ...*wallet.Wallet) *stakeNetwork {
+	t.Helper()
+	bootstrap := wallets[0]
+	params := ChainParams{Consensus: ConsensusPoS, Signers: [][]byte{wallet.PubkeyHash(bootstrap.PublicKey)}}
+	engine, err := NewConsensus(params, nil, keysOf(bootstrap).source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coinbase := CoinbaseTx(address(bootstrap), genesisData)
+	genesis := &Block{time.Now().Add(-24 * time.Hour).Unix(), []byte{}, []*Transaction{coinbase}, []byte{}, 0, 0, nil, nil}
+	if err := engine.Prepare(genesis); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.Seal(genesis); err != nil {
+		t.Fatal(err)
+	}
+
+	n := &stakeNetwork{t: t}
+	for _, w := range wallets {
+		chain := initStore(NewMemoryStore(), params, keysOf(w).source, genesis, false, false)
+		n.nodes = append(n.nodes, &stakeNode{w, chain})
+	}
+	return n
+}
+
+func (n *stakeNetwork) chain() *BlockChain {
+	return n.nodes[0].chain
+}
+
+func (n *stakeNetwork) tip() *Block {
+	return tip(n.t, n.chain())
+}
+
+// next finds the node producing a block on parent and its round: the first
+// round whose drawn staker is not skip and is held by a node
+func (n *stakeNetwork) next(parent *Block, skip []byte) (*stakeNode, int) {
+	n.t.Helper()
+	engine := n.chain().Engine.(*PoS)
+	block := &Block{PrevHash: parent.Hash, Height: parent.Height + 1}
+	for round := 0; round < maxStakeRounds; round++ {
+		staker, err := engine.Selected(block, round)
+		if err != nil {
+			n.t.Fatal(err)
+		}
+		if bytes.Equal(staker, skip) {
+			continue
+		}
+		for _, node := range n.nodes {
+			if bytes.Equal(wallet.PubkeyHash(node.w.PublicKey), staker) {
+				return node, round
+			}
+		}
+	}
+	n.t.Fatal("no node is drawn to produce a block")
+	return nil, 0
+}
+
+// seal has node produce a block on parent at the start of round
+func (n *stakeNetwork) seal(node *stakeNode, parent *Block, round int, txs ...*Transaction) *Block {
+	n.t.Helper()
+	txs = append([]*Transaction{CoinbaseTx(address(node.w), "")}, txs...)
+	block := &Block{roundStart(parent, round), []byte{}, txs, parent.Hash, parent.Height + 1, 0, nil, nil}
+	if err := node.chain.Engine.Prepare(block); err != nil {
+		n.t.Fatal(err)
+	}
+	if block.Nonce != round {
+		n.t.Fatalf("block prepared for round %d, want %d", block.Nonce, round)
+	}
+	if err := node.chain.Engine.Seal(block); err != nil {
+		n.t.Fatal(err)
+	}
+	return block
+}
+
+func (n *stakeNetwork) broadcast(block *Block) {
+	n.t.Helper()
+	for _, node := range n.nodes {
+		if err := node.chain.AddBlock(block); err != nil {
+			n.t.Fatal(err)
+		}
+	}
+}
+
+// produce has the drawn node produce the next block and broadcast it
+func (n *stakeNetwork) produce(txs ...*Transaction) *Block {
+	n.t.Helper()
+	parent := n.tip()
+	node, round := n.next(parent, nil)
+	block := n.seal(node, parent, round, txs...)
+	n.broadcast(block)
+	return block
+}
+
+func (n *stakeNetwork) checkTips() {
+	n.t.Helper()
+	for _, node := range n.nodes {
+		if !bytes.Equal(node.chain.LastHash, n.chain().LastHash) {
+			n.t.Fatalf("node of %s has tip %x, want %x", address(node.w), node.chain.LastHash, n.chain().LastHash)
+		}
+	}
+}
+
+// signAs re-signs a block with any key, as a dishonest node would
+func signAs(block *Block, w *wallet.Wallet) {
+	block.Signer = w.PublicKey
+	block.Hash = block.Header().Hash()
+	block.Signature = signData(w.PrivateKey, block.Hash)
+}
+
+func stakeTx(t *testing.T, chain *BlockChain, w *wallet.Wallet, amount int) *Transaction {
+	t.Helper()
+	tx, err := buildTx(chain, w, func(b *TxBuilder) error {
+		return b.AddStake(address(w), amount)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.CheckStakeOperation(tx); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+// startStaking has the bootstrap signer fund the stakers, who register 20
+// each, active from height 3
+func startStaking(n *stakeNetwork, bootstrap *wallet.Wallet, stakers ...*wallet.Wallet) {
+	n.t.Helper()
+	fund, err := buildTx(n.chain(), bootstrap, func(b *TxBuilder) error {
+		for _, w := range stakers {
+			if err := b.AddRecipient(address(w), 50); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		n.t.Fatal(err)
+	}
+	if block := n.produce(fund); !bytes.Equal(block.Signer, bootstrap.PublicKey) {
+		n.t.Fatal("block 1 is not produced by the bootstrap signer")
+	}
+	var stakes []*Transaction
+	for _, w := range stakers {
+		stakes = append(stakes, stakeTx(n.t, n.chain(), w, 20))
+	}
+	n.produce(stakes...)
+}
+
+func TestStakeNetwork(t *testing.T) {
+	bootstrap, s1, s2 := wallet.MakeWallet(), wallet.MakeWallet(), wallet.MakeWallet()
+	n := newStakeNetwork(t, bootstrap, s1, s2)
+	startStaking(n, bootstrap, s1, s2)
+
+	produced := make(map[string]int)
+	for i := 0; i < 20; i++ {
+		block := n.produce()
+		if block.Nonce != 0 {
+			t.Errorf("block %d is produced in round %d while every staker is online", block.Height, block.Nonce)
+		}
+		produced[string(wallet.AddressFromPubKeyHash(wallet.PubkeyHash(block.Signer)))]++
+	}
+	n.checkTips()
+	if produced[address(bootstrap)] != 0 {
+		t.Error("the bootstrap signer produces blocks once stake is registered")
+	}
+	if produced[address(s1)] == 0 || produced[address(s2)] == 0 {
+		t.Errorf("blocks produced by each staker: %v", produced)
+	}
+	if got := balance(n.chain(), s1) + balance(n.chain(), s2); got != 100+100*20 {
+		t.Errorf("stakers hold %d", got)
+	}
+	for _, node := range n.nodes {
+		checkUndo(t, node.chain)
+	}
+}
+
+func TestStakeNetworkRejectsBlocks(t *testing.T) {
+	bootstrap, s1, s2 := wallet.MakeWallet(), wallet.MakeWallet(), wallet.MakeWallet()
+	n := newStakeNetwork(t, bootstrap, s1, s2)
+	startStaking(n, bootstrap, s1, s2)
+
+	parent := n.tip()
+	node, round := n.next(parent, nil)
+	other := s1
+	if node.w == s1 {
+		other = s2
+	}
+	forged := func(w *wallet.Wallet, round int, timestamp int64) *Block {
+		block := &Block{timestamp, []byte{}, []*Transaction{CoinbaseTx(address(w), "")}, parent.Hash, parent.Height + 1, round, nil, nil}
+		signAs(block, w)
+		return block
+	}
+	rejected := map[string]*Block{
+		"staker not drawn":       forged(other, round, roundStart(parent, round)),
+		"bootstrap signer":       forged(bootstrap, round, roundStart(parent, round)),
+		"before its round":       forged(node.w, round, roundStart(parent, round)-1),
+		"from the future":        forged(node.w, round, time.Now().Unix()+2*StakeSlot),
+		"round out of bounds":    forged(node.w, maxStakeRounds, roundStart(parent, maxStakeRounds)),
+		"signature of other key": forged(node.w, round, roundStart(parent, round)),
+	}
+	rejected["signature of other key"].Signature = signData(other.PrivateKey, rejected["signature of other key"].Hash)
+	for name, block := range rejected {
+		if err := n.nodes[0].chain.AddBlock(block); err == nil {
+			t.Errorf("block with a %s is accepted", name)
+		}
+	}
+
+	// the node not drawn cannot produce the block before its own round
+	for _, candidate := range n.nodes[1:] {
+		if candidate == node {
+			continue
+		}
+		block := &Block{roundStart(parent, round), []byte{}, []*Transaction{CoinbaseTx(address(candidate.w), "")}, parent.Hash, parent.Height + 1, 0, nil, nil}
+		if err := candidate.chain.Engine.Prepare(block); !errors.Is(err, ErrNotSelected) {
+			t.Errorf("node not drawn prepares a block: %v", err)
+		}
+	}
+	n.broadcast(n.seal(node, parent, round))
+	n.checkTips()
+}
+
+func TestStakeSlashing(t *testing.T) {
+	bootstrap, s1, s2 := wallet.MakeWallet(), wallet.MakeWallet(), wallet.MakeWallet()
+	n := newStakeNetwork(t, bootstrap, s1, s2)
+	startStaking(n, bootstrap, s1, s2)
+	s1Hash := wallet.PubkeyHash(s1.PublicKey)
+
+	// s1 signs two blocks on the same parent
+	var first, second *Block
+	for first == nil {
+		parent := n.tip()
+		node, round := n.next(parent, nil)
+		if node.w != s1 {
+			n.broadcast(n.seal(node, parent, round))
+			continue
+		}
+		first, second = n.seal(node, parent, round), n.seal(node, parent, round)
+	}
+	n.broadcast(first)
+	n.broadcast(second)
+	n.checkTips()
+	evidence, err := n.chain().DoubleSignEvidence(first.Hash, second.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offender, err := evidence.Verify(); err != nil || !bytes.Equal(offender, s1Hash) {
+		t.Fatalf("evidence is against %x, %v", offender, err)
+	}
+
+	// stake registered after the double signing cannot be slashed for it
+	later := stakeTx(t, n.chain(), s1, 10)
+	n.produce(later)
+
+	slash, err := buildTx(n.chain(), s2, func(b *TxBuilder) error {
+		return b.Slash(evidence)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.chain().CheckStakeOperation(slash); err != nil {
+		t.Fatal(err)
+	}
+	fork := n.tip()
+	slashBlock := n.produce(slash)
+
+	for _, stake := range n.chain().GetStakes() {
+		if !bytes.Equal(stake.Staker, s1Hash) {
+			continue
+		}
+		if bytes.Equal(stake.TxId, later.Id) {
+			if stake.Slashed != 0 {
+				t.Error("stake registered after the double signing is slashed")
+			}
+		} else if stake.Slashed != slashBlock.Height {
+			t.Errorf("double signed stake slashed at %d, want %d", stake.Slashed, slashBlock.Height)
+		}
+	}
+
+	swapped := DoubleSignEvidence{evidence.Second, evidence.First}
+	reused, err := buildTx(n.chain(), s2, func(b *TxBuilder) error {
+		return b.Slash(swapped)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.chain().CheckStakeOperation(reused); !errors.Is(err, ErrEvidenceUsed) {
+		t.Errorf("slashing again with the same evidence: %v", err)
+	}
+
+	withReverted(t, n.chain(), 1, func(txn StoreTxn) {
+		if hasKey(txn, evidenceKey(&evidence)) {
+			t.Error("evidence is used with the slash reverted")
+		}
+		var prevOuts []TxOutput
+		for _, in := range slash.Inputs {
+			out, err := getUnspentOutput(txn, in.Id, in.OutIndex)
+			if err != nil {
+				t.Fatal(err)
+			}
+			prevOuts = append(prevOuts, out.Output)
+		}
+		if err := checkStakeOperation(txn, slash, prevOuts, slashBlock.Height); err != nil {
+			t.Errorf("slash is rejected with it reverted: %v", err)
+		}
+	})
+	for _, node := range n.nodes {
+		checkUndo(t, node.chain)
+	}
+
+	// a side chain forking before the slash draws from its own registry:
+	// the bootstrap signer stakes there and s1 is not slashed
+	bootstrapStake := stakeTx(t, n.chain(), bootstrap, 20)
+	node, round := n.next(fork, slashBlock.Signer)
+	side := n.seal(node, fork, round, bootstrapStake)
+	n.broadcast(side)
+	err = n.chain().Database.View(func(txn StoreTxn) error {
+		for block, want := range map[*Block]int{side: 0, slashBlock: slashBlock.Height} {
+			stakes, err := stakesAt(txn, block)
+			if err != nil {
+				return err
+			}
+			staked := false
+			for _, stake := range stakes {
+				if bytes.Equal(stake.Staker, s1Hash) && !bytes.Equal(stake.TxId, later.Id) && stake.Slashed != want {
+					t.Errorf("s1 is slashed at %d after block %x, want %d", stake.Slashed, block.Hash, want)
+				}
+				staked = staked || bytes.Equal(stake.TxId, bootstrapStake.Id)
+			}
+			if staked != (block == side) {
+				t.Errorf("the bootstrap signer's stake is registered after block %x: %v", block.Hash, staked)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// extending the side chain makes it the main chain
+	parent := side
+	for i := 0; i < 3; i++ {
+		node, round := n.next(parent, nil)
+		parent = n.seal(node, parent, round)
+		n.broadcast(parent)
+	}
+	n.checkTips()
+	if !bytes.Equal(n.chain().LastHash, parent.Hash) {
+		t.Fatal("the side chain did not become the main chain")
+	}
+	for _, stake := range n.chain().GetStakes() {
+		if stake.Slashed != 0 {
+			t.Errorf("stake of %x is slashed on the side chain", stake.Staker)
+		}
+	}
+	if err := n.chain().CheckStakeOperation(slash); err != nil {
+		t.Errorf("slash is rejected on the side chain: %v", err)
+	}
+	for _, node := range n.nodes {
+		checkUndo(t, node.chain)
+	}
+}
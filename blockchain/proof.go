@@ -4,25 +4,34 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"log"
 	"math"
 	"math/big"
+	"sort"
+	"time"
 )
 
 type ProofOfWork struct {
 	Block  *Block
 	Target *big.Int
+	Rules  int // rule version of the chain, deciding what the work commits to
 }
 
 // take data from the block
 
 const Difficulty = 12
 
-func InitPow(b *Block) *ProofOfWork {
+const (
+	MedianTimeBlocks   = 11          // blocks the median past time of a proof of work block is taken over
+	MaxFutureBlockTime = 2 * 60 * 60 // seconds a proof of work block can be ahead of the clock
+)
+
+func InitPow(b *Block, rules int) *ProofOfWork {
 	target := big.NewInt(1)
 	target.Lsh(target, uint(256-Difficulty))
-	pow := &ProofOfWork{b, target}
+	pow := &ProofOfWork{b, target, rules}
 	return pow
 }
 
@@ -58,10 +67,104 @@ func (p *ProofOfWork) Run() (int, []byte) {
 }
 
 func (p *ProofOfWork) InitData(nonce int) []byte {
-	res := bytes.Join([][]byte{p.Block.HashTransactions(), p.Block.PrevHash, ToHex(int64(nonce)), ToHex(int64(Difficulty))}, []byte{})
+	if p.Rules < TimedRules {
+		return bytes.Join([][]byte{p.Block.HashTransactions(), p.Block.PrevHash, ToHex(int64(nonce)), ToHex(int64(Difficulty))}, []byte{})
+	}
+	res := bytes.Join([][]byte{p.Block.HashTransactions(), p.Block.PrevHash, ToHex(p.Block.Timstamp),
+		ToHex(int64(p.Block.Height)), ToHex(int64(nonce)), ToHex(int64(Difficulty))}, []byte{})
 	return res
 }
 
+// PoW is the proof of work engine, every block weighing one. From
+// TimedRules, a block must be timestamped after the median time of the
+// blocks before it.
+type PoW struct {
+	db    Store
+	rules int
+}
+
+func (e *PoW) Name() string {
+	return ConsensusPoW
+}
+
+// medianTimePast returns the median timestamp of the last MedianTimeBlocks
+// blocks of the chain ending with the parent of block
+func (e *PoW) medianTimePast(block *Block) (int64, error) {
+	if e.db == nil {
+		return 0, errors.New("parent block is unknown")
+	}
+	var times []int64
+	err := e.db.View(func(txn StoreTxn) error {
+		for hash := block.PrevHash; len(hash) > 0 && len(times) < MedianTimeBlocks; {
+			parent, err := getBlock(txn, hash)
+			if err != nil {
+				return err
+			}
+			times = append(times, parent.Timstamp)
+			hash = parent.PrevHash
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("parent of block %x is unknown", block.Hash)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	return times[len(times)/2], nil
+}
+
+// Prepare moves the timestamp of a block past the median time of the blocks
+// before it, when blocks are mined faster than the clock ticks
+func (e *PoW) Prepare(block *Block) error {
+	block.Nonce = 0
+	if block.Height == 0 || e.rules < TimedRules {
+		return nil
+	}
+	median, err := e.medianTimePast(block)
+	if err != nil {
+		return err
+	}
+	if block.Timstamp <= median {
+		block.Timstamp = median + 1
+	}
+	return nil
+}
+
+func (e *PoW) Seal(block *Block) error {
+	nonce, hash := InitPow(block, e.rules).Run()
+	block.Nonce = nonce
+	block.Hash = hash
+	return nil
+}
+
+func (e *PoW) VerifySeal(block *Block) error {
+	pow := InitPow(block, e.rules)
+	hash := sha256.Sum256(pow.InitData(block.Nonce))
+	if !bytes.Equal(hash[:], block.Hash) || !pow.Validate() {
+		return fmt.Errorf("block %x has an invalid proof of work", block.Hash)
+	}
+	if e.rules < TimedRules {
+		return nil
+	}
+	if block.Timstamp > time.Now().Unix()+MaxFutureBlockTime {
+		return fmt.Errorf("block %x is from the future", block.Hash)
+	}
+	if block.Height == 0 {
+		return nil
+	}
+	median, err := e.medianTimePast(block)
+	if err != nil {
+		return err
+	}
+	if block.Timstamp <= median {
+		return fmt.Errorf("block %x is not after the median time of the blocks before it", block.Hash)
+	}
+	return nil
+}
+
+func (e *PoW) Weight(block *Block) int {
+	return 1
+}
+
 func ToHex(num int64) []byte {
 	buf := new(bytes.Buffer)
 	err := binary.Write(buf, binary.BigEndian, num)
@@ -0,0 +1,114 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/Harshjha3006/golang-blockchain/wallet"
+)
+
+// PartialTransaction is an unsigned or partially signed transaction carrying
+// the outputs its inputs spend, so it can be signed on a machine without the
+// chain. Its id is fixed when it is created and does not commit to the
+// public keys and signatures added by the signers.
+type PartialTransaction struct {
+	Tx          Transaction
+	PrevOutputs []TxOutput // output spent by each input
+}
+
+// Sign signs the inputs spending outputs locked to the wallet's key and
+// returns how many it signed
+func (p *PartialTransaction) Sign(w *wallet.Wallet) (int, error) {
+	if !w.CanSign() {
+		return 0, ErrCannotSign
+	}
+	if len(p.PrevOutputs) != len(p.Tx.Inputs) {
+		return 0, errors.New("partial transaction is missing previous outputs")
+	}
+
+	signed := 0
+	for inId, prevOut := range p.PrevOutputs {
+		if p.Tx.signWith(inId, w, prevOut) {
+			signed++
+		}
+	}
+	return signed, nil
+}
+
+// Signed reports how many inputs carry a valid signature
+func (p *PartialTransaction) Signed() int {
+	signed := 0
+	for inId, prevOut := range p.PrevOutputs {
+		if p.Tx.verifyInput(inId, prevOut) {
+			signed++
+		}
+	}
+	return signed
+}
+
+// Finalize returns the transaction once every input is validly signed
+func (p *PartialTransaction) Finalize() (*Transaction, error) {
+	if len(p.PrevOutputs) != len(p.Tx.Inputs) {
+		return nil, errors.New("partial transaction is missing previous outputs")
+	}
+	if signed := p.Signed(); signed < len(p.Tx.Inputs) {
+		return nil, fmt.Errorf("%d of %d inputs are signed", signed, len(p.Tx.Inputs))
+	}
+	tx := p.Tx
+	return &tx, nil
+}
+
+// CombinePartial merges the signatures of copies of the same partial
+// transaction signed by different keys
+func CombinePartial(parts ...*PartialTransaction) (*PartialTransaction, error) {
+	if len(parts) == 0 {
+		return nil, errors.New("nothing to combine")
+	}
+	combined := *parts[0]
+	combined.Tx.Inputs = append([]TxInput{}, parts[0].Tx.Inputs...)
+	if len(combined.PrevOutputs) != len(combined.Tx.Inputs) {
+		return nil, errors.New("partial transaction is missing previous outputs")
+	}
+
+	for _, part := range parts[1:] {
+		if !bytes.Equal(part.Tx.Id, combined.Tx.Id) || len(part.Tx.Inputs) != len(combined.Tx.Inputs) {
+			return nil, errors.New("partial transactions are not of the same transaction")
+		}
+		for inId, in := range part.Tx.Inputs {
+			combined.Tx.mergeInput(inId, combined.PrevOutputs[inId], in.Script)
+		}
+	}
+	return &combined, nil
+}
+
+func (p *PartialTransaction) Serialize() []byte {
+	var buffer bytes.Buffer
+	err := gob.NewEncoder(&buffer).Encode(p)
+	Handle(err)
+	return buffer.Bytes()
+}
+
+func DeserializePartial(data []byte) (*PartialTransaction, error) {
+	var p PartialTransaction
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// EncodePartial and DecodePartial convert a partial transaction to and from
+// the hex text passed between machines
+func EncodePartial(p *PartialTransaction) string {
+	return hex.EncodeToString(p.Serialize())
+}
+
+func DecodePartial(encoded string) (*PartialTransaction, error) {
+	data, err := hex.DecodeString(string(bytes.TrimSpace([]byte(encoded))))
+	if err != nil {
+		return nil, err
+	}
+	return DeserializePartial(data)
+}
@@ -0,0 +1,137 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/Harshjha3006/golang-blockchain/script"
+	"github.com/Harshjha3006/golang-blockchain/wallet"
+)
+
+// Raw transactions are passed around as the hex of their gob encoding
+
+func EncodeRawTransaction(tx *Transaction) string {
+	return hex.EncodeToString(tx.Serialize())
+}
+
+func DecodeRawTransaction(encoded string) (*Transaction, error) {
+	data, err := hex.DecodeString(string(bytes.TrimSpace([]byte(encoded))))
+	if err != nil {
+		return nil, err
+	}
+	var tx Transaction
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&tx); err != nil {
+		return nil, fmt.Errorf("invalid raw transaction: %w", err)
+	}
+	if len(tx.Inputs) == 0 || len(tx.Outputs) == 0 {
+		return nil, errors.New("raw transaction has no inputs or outputs")
+	}
+	return &tx, nil
+}
+
+// CreateRawTransaction returns the unsigned transaction spending the given
+// inputs, its id does not commit to the public keys and signatures added when
+// it is signed
+func CreateRawTransaction(inputs []TxInput, outputs []TxOutput) *Transaction {
+	tx := Transaction{nil, nil, outputs, 0, nil, nil, nil}
+	for _, in := range inputs {
+		tx.Inputs = append(tx.Inputs, TxInput{Id: in.Id, OutIndex: in.OutIndex, Sequence: in.Sequence})
+	}
+	tx.setId()
+	return &tx
+}
+
+// SignRawTransaction signs the inputs of a transaction whose spent output is
+// locked to one of the wallets, or to one of the redeem scripts, and reports
+// whether every input is then validly signed
+func (chain *BlockChain) SignRawTransaction(tx *Transaction, wallets []*wallet.Wallet, redeemScripts [][]byte) (signed int, complete bool, err error) {
+	keys := make(map[string]*wallet.Wallet)
+	for _, w := range wallets {
+		if w.CanSign() {
+			keys[string(wallet.PubkeyHash(w.PublicKey))] = w
+		}
+	}
+
+	complete = true
+	for inId, in := range tx.Inputs {
+		prevTx, err := chain.FindTransaction(in.Id)
+		if err != nil {
+			return signed, false, fmt.Errorf("input %d: %w", inId, err)
+		}
+		if in.OutIndex < 0 || in.OutIndex >= len(prevTx.Outputs) {
+			return signed, false, fmt.Errorf("input %d: transaction %x has no output %d", inId, in.Id, in.OutIndex)
+		}
+		prevOut := prevTx.Outputs[in.OutIndex]
+
+		if len(in.Script) == 0 && script.IsPayToScriptHash(prevOut.Script) {
+			for _, redeem := range redeemScripts {
+				if bytes.Equal(script.Hash160(redeem), prevOut.AddressHash()) {
+					tx.Inputs[inId].Script = script.NewBuilder().AddData(redeem).Script()
+				}
+			}
+		}
+		for _, w := range keys {
+			if tx.signWith(inId, w, prevOut) {
+				signed++
+			}
+		}
+		if !tx.verifyInput(inId, prevOut) {
+			complete = false
+		}
+	}
+	return signed, complete, nil
+}
+
+type RawInputView struct {
+	TxId     string `json:"txid"`
+	Vout     int    `json:"vout"`
+	Script   string `json:"script"`
+	Asm      string `json:"asm,omitempty"`
+	Address  string `json:"address,omitempty"`
+	Sequence int    `json:"sequence,omitempty"`
+}
+
+type RawOutputView struct {
+	Value   int    `json:"value"`
+	Script  string `json:"script"`
+	Asm     string `json:"asm"`
+	Type    string `json:"type"`
+	Address string `json:"address,omitempty"`
+	TokenId string `json:"token,omitempty"`
+	Tokens  int    `json:"tokens,omitempty"`
+}
+
+type RawTransactionView struct {
+	TxId     string          `json:"txid"`
+	Coinbase bool            `json:"coinbase"`
+	Inputs   []RawInputView  `json:"inputs"`
+	Outputs  []RawOutputView `json:"outputs"`
+	Total    int             `json:"total"`
+	LockTime int64           `json:"locktime,omitempty"`
+}
+
+// JSON returns the JSON view of the transaction printed by decoderawtransaction
+func (tx *Transaction) JSON() []byte {
+	view := RawTransactionView{TxId: hex.EncodeToString(tx.Id), Coinbase: tx.IsCoinbase(), LockTime: tx.LockTime}
+	for _, in := range tx.Inputs {
+		input := RawInputView{TxId: hex.EncodeToString(in.Id), Vout: in.OutIndex, Script: hex.EncodeToString(in.Script), Sequence: in.Sequence}
+		if !tx.IsCoinbase() {
+			input.Asm = script.Disasm(in.Script)
+			input.Address = in.Address()
+		}
+		view.Inputs = append(view.Inputs, input)
+	}
+	for _, out := range tx.Outputs {
+		class, _ := script.Classify(out.Script)
+		output := RawOutputView{out.Value, hex.EncodeToString(out.Script), script.Disasm(out.Script), class.String(), out.Address(), hex.EncodeToString(out.TokenId), out.Tokens}
+		view.Outputs = append(view.Outputs, output)
+		view.Total += out.Value
+	}
+	data, err := json.MarshalIndent(view, "", "  ")
+	Handle(err)
+	return data
+}
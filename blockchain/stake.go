@@ -0,0 +1,483 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/Harshjha3006/golang-blockchain/script"
+)
+
+// Stake operations
+const (
+	StakeRegister   = "register"
+	StakeUnregister = "unregister"
+	StakeSlash      = "slash"
+)
+
+const (
+	// MinStake is the smallest staking output
+	MinStake = 10
+	// StakeUnbondingPeriod is the number of blocks unregistered stake stays
+	// locked, and can still be slashed, before its staker can spend it
+	StakeUnbondingPeriod = 10
+)
+
+// StakeOperation registers the output Output of its transaction as stake of
+// the output's owner, unregisters the stake its transaction spends, paying
+// it to Output locked for StakeUnbondingPeriod blocks, or burns the stake of
+// a staker who signed two blocks at the same height.
+type StakeOperation struct {
+	Op       string
+	Output   int
+	Evidence *DoubleSignEvidence
+}
+
+// SignedHeader is a block header with the signature of its signer
+type SignedHeader struct {
+	Header    BlockHeader
+	Signature []byte
+}
+
+// DoubleSignEvidence holds two different block headers at the same height
+// signed by the same staker
+type DoubleSignEvidence struct {
+	First  SignedHeader
+	Second SignedHeader
+}
+
+// Stake is an entry of the stake registry, kept after the stake is
+// unregistered or slashed so that producers of past blocks can be checked
+type Stake struct {
+	Staker      []byte // pubkey hash
+	TxId        []byte
+	Index       int
+	Amount      int
+	Registered  int    // height of the block registering it
+	Unbonding   int    // height of the block unregistering it, 0 while staking
+	UnbondTxId  []byte // output the stake was unregistered to
+	UnbondIndex int
+	Slashed     int // height of the block slashing it, 0 unless slashed
+}
+
+var (
+	stakePrefix    = []byte("stake-")    // staking outpoint -> Stake
+	evidencePrefix = []byte("evidence-") // double sign evidence hash -> height of the block slashing with it
+)
+
+var (
+	ErrNotStaking   = errors.New("no stake to slash")
+	ErrEvidenceUsed = errors.New("evidence was already used to slash")
+)
+
+func stakeKey(txId []byte, index int) []byte {
+	return append(append([]byte{}, stakePrefix...), utxoKey(txId, index)...)
+}
+
+func (s Stake) Serialize() []byte {
+	var res bytes.Buffer
+	err := gob.NewEncoder(&res).Encode(s)
+	Handle(err)
+	return res.Bytes()
+}
+
+func DeserializeStake(data []byte) Stake {
+	var s Stake
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&s)
+	Handle(err)
+	return s
+}
+
+// ActiveAt tells whether the stake counts for selecting the producer of the
+// block at a height
+func (s Stake) ActiveAt(height int) bool {
+	return s.Registered < height && (s.Unbonding == 0 || s.Unbonding >= height) &&
+		(s.Slashed == 0 || s.Slashed >= height)
+}
+
+// slashableAt tells whether the stake of a staker can be slashed for signing
+// two blocks at a height: it counted at that height and is neither slashed
+// nor spent since
+func (s Stake) slashableAt(txn StoreTxn, staker []byte, height int) bool {
+	if !bytes.Equal(s.Staker, staker) || s.Slashed != 0 || !s.ActiveAt(height) {
+		return false
+	}
+	txId, index := s.Outpoint()
+	return hasKey(txn, utxoKey(txId, index))
+}
+
+// Outpoint is the output currently holding the stake
+func (s Stake) Outpoint() ([]byte, int) {
+	if s.Unbonding != 0 {
+		return s.UnbondTxId, s.UnbondIndex
+	}
+	return s.TxId, s.Index
+}
+
+func getStake(txn StoreTxn, txId []byte, index int) (Stake, bool) {
+	data, err := txn.Get(stakeKey(txId, index))
+	if err != nil {
+		return Stake{}, false
+	}
+	return DeserializeStake(data), true
+}
+
+func getStakes(txn StoreTxn) ([]Stake, error) {
+	var stakes []Stake
+	err := txn.Iterate(stakePrefix, nil, func(_, v []byte) error {
+		stakes = append(stakes, DeserializeStake(v))
+		return nil
+	})
+	return stakes, err
+}
+
+// isStaked tells whether an output is registered stake, which only an
+// unregistering transaction can spend
+func isStaked(txn StoreTxn, txId []byte, index int) bool {
+	stake, ok := getStake(txn, txId, index)
+	return ok && stake.Unbonding == 0 && stake.Slashed == 0
+}
+
+// Hash identifies the evidence whichever order its headers are in
+func (ev *DoubleSignEvidence) Hash() []byte {
+	first, second := ev.First.Header.Hash(), ev.Second.Header.Hash()
+	if bytes.Compare(first, second) > 0 {
+		first, second = second, first
+	}
+	hash := sha256.Sum256(append(first, second...))
+	return hash[:]
+}
+
+func evidenceKey(ev *DoubleSignEvidence) []byte {
+	return append(append([]byte{}, evidencePrefix...), ev.Hash()...)
+}
+
+// Verify checks the evidence and returns the pubkey hash of the staker who
+// signed both headers
+func (ev *DoubleSignEvidence) Verify() ([]byte, error) {
+	first, second := ev.First, ev.Second
+	if !bytes.Equal(first.Header.Signer, second.Header.Signer) || first.Header.Height != second.Header.Height {
+		return nil, errors.New("evidence headers are not signed by the same staker at the same height")
+	}
+	if bytes.Equal(first.Header.Hash(), second.Header.Hash()) {
+		return nil, errors.New("evidence headers are the same")
+	}
+	for _, signed := range []SignedHeader{first, second} {
+		if !verifyData(signed.Header.Signer, signed.Signature, signed.Header.Hash()) {
+			return nil, errors.New("evidence header has an invalid signature")
+		}
+	}
+	return script.Hash160(first.Header.Signer), nil
+}
+
+// checkStakeOperation checks the stake operation of a transaction in a block
+// of the given height against the stake registry, and that it spends
+// registered stake only to unregister it
+func checkStakeOperation(txn StoreTxn, tx *Transaction, prevOuts []TxOutput, height int) error {
+	op := tx.Stake
+	var staker []byte
+	staked := 0
+	for inId, in := range tx.Inputs {
+		if tx.IsCoinbase() || !isStaked(txn, in.Id, in.OutIndex) {
+			continue
+		}
+		if op == nil || op.Op != StakeUnregister {
+			return fmt.Errorf("input %d spends registered stake", inId)
+		}
+		if staker != nil && !bytes.Equal(staker, prevOuts[inId].AddressHash()) {
+			return errors.New("cannot unregister the stake of several stakers at once")
+		}
+		staker = prevOuts[inId].AddressHash()
+		staked += prevOuts[inId].Value
+	}
+	if op == nil {
+		return nil
+	}
+	if tx.IsCoinbase() {
+		return errors.New("coinbase cannot operate on stake")
+	}
+
+	switch op.Op {
+	case StakeRegister, StakeUnregister:
+		if op.Output < 0 || op.Output >= len(tx.Outputs) {
+			return fmt.Errorf("stake output %d does not exist", op.Output)
+		}
+		out := tx.Outputs[op.Output]
+		if op.Op == StakeRegister {
+			if class, _ := script.Classify(out.Script); class != script.PubKeyHash || out.TokenId != nil {
+				return errors.New("stake must be paid to a pubkey hash")
+			}
+			if _, afterBlocks := out.Timelock(); afterBlocks > 0 {
+				return errors.New("stake cannot be timelocked")
+			}
+			if out.Value < MinStake {
+				return fmt.Errorf("stake must be at least %d", MinStake)
+			}
+			return nil
+		}
+		if staker == nil {
+			return errors.New("transaction spends no registered stake")
+		}
+		if _, afterBlocks := out.Timelock(); !bytes.Equal(out.AddressHash(), staker) ||
+			afterBlocks < StakeUnbondingPeriod || out.Value < staked {
+			return fmt.Errorf("unregistered stake must be paid back to its staker, locked for %d blocks", StakeUnbondingPeriod)
+		}
+		return nil
+	case StakeSlash:
+		if op.Evidence == nil {
+			return errors.New("slashing needs evidence")
+		}
+		offender, err := op.Evidence.Verify()
+		if err != nil {
+			return err
+		}
+		if hasKey(txn, evidenceKey(op.Evidence)) {
+			return ErrEvidenceUsed
+		}
+		stakes, err := getStakes(txn)
+		if err != nil {
+			return err
+		}
+		for _, stake := range stakes {
+			if stake.slashableAt(txn, offender, op.Evidence.First.Header.Height) {
+				return nil
+			}
+		}
+		return ErrNotStaking
+	}
+	return fmt.Errorf("unknown stake operation %q", op.Op)
+}
+
+// applyStakeOperation updates the stake registry with the stake operation of
+// a transaction, returning the outputs it burns
+func applyStakeOperation(txn StoreTxn, tx *Transaction, height int) ([]UnspentOutput, error) {
+	op := tx.Stake
+	if op == nil {
+		return nil, nil
+	}
+	switch op.Op {
+	case StakeRegister:
+		out := tx.Outputs[op.Output]
+		stake := Stake{Staker: out.AddressHash(), TxId: tx.Id, Index: op.Output, Amount: out.Value, Registered: height}
+		return nil, txn.Set(stakeKey(tx.Id, op.Output), stake.Serialize())
+	case StakeUnregister:
+		for _, in := range tx.Inputs {
+			stake, ok := getStake(txn, in.Id, in.OutIndex)
+			if !ok || stake.Unbonding != 0 {
+				continue
+			}
+			stake.Unbonding, stake.UnbondTxId, stake.UnbondIndex = height, tx.Id, op.Output
+			if err := txn.Set(stakeKey(in.Id, in.OutIndex), stake.Serialize()); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	}
+
+	offender, _ := op.Evidence.Verify()
+	if err := txn.Set(evidenceKey(op.Evidence), ToHex(int64(height))); err != nil {
+		return nil, err
+	}
+	stakes, err := getStakes(txn)
+	if err != nil {
+		return nil, err
+	}
+	var burnt []UnspentOutput
+	for _, stake := range stakes {
+		if !stake.slashableAt(txn, offender, op.Evidence.First.Header.Height) {
+			continue
+		}
+		txId, index := stake.Outpoint()
+		out, err := getUnspentOutput(txn, txId, index)
+		if err != nil {
+			return nil, err
+		}
+		if err := txn.Delete(utxoKey(txId, index)); err != nil {
+			return nil, err
+		}
+		burnt = append(burnt, out)
+		stake.Slashed = height
+		if err := txn.Set(stakeKey(stake.TxId, stake.Index), stake.Serialize()); err != nil {
+			return nil, err
+		}
+	}
+	return burnt, nil
+}
+
+// revertStakeOperation undoes applyStakeOperation, except for restoring the
+// burnt outputs which are part of the undo data of the block
+func revertStakeOperation(txn StoreTxn, tx *Transaction, height int) error {
+	op := tx.Stake
+	if op == nil {
+		return nil
+	}
+	switch op.Op {
+	case StakeRegister:
+		return txn.Delete(stakeKey(tx.Id, op.Output))
+	case StakeUnregister:
+		for _, in := range tx.Inputs {
+			stake, ok := getStake(txn, in.Id, in.OutIndex)
+			if !ok || !bytes.Equal(stake.UnbondTxId, tx.Id) {
+				continue
+			}
+			stake.Unbonding, stake.UnbondTxId, stake.UnbondIndex = 0, nil, 0
+			if err := txn.Set(stakeKey(in.Id, in.OutIndex), stake.Serialize()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	offender, _ := op.Evidence.Verify()
+	if err := txn.Delete(evidenceKey(op.Evidence)); err != nil {
+		return err
+	}
+	stakes, err := getStakes(txn)
+	if err != nil {
+		return err
+	}
+	for _, stake := range stakes {
+		if bytes.Equal(stake.Staker, offender) && stake.Slashed == height {
+			stake.Slashed = 0
+			if err := txn.Set(stakeKey(stake.TxId, stake.Index), stake.Serialize()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// stakesAt returns the stake registry as it was after a block, which may be
+// on a side chain: the registry of the main chain is rolled back to the
+// block the side chain forks at, and the stake operations of the side chain
+// are replayed on it
+func stakesAt(txn StoreTxn, block *Block) ([]Stake, error) {
+	var branch []*Block
+	for {
+		hash, err := getHashAtHeight(txn, block.Height)
+		if err == nil && bytes.Equal(hash, block.Hash) {
+			break
+		}
+		branch = append(branch, block)
+		if block, err = getBlock(txn, block.PrevHash); err != nil {
+			return nil, err
+		}
+	}
+	fork := block.Height
+
+	registry, err := getStakes(txn)
+	if err != nil {
+		return nil, err
+	}
+	var stakes []Stake
+	for _, stake := range registry {
+		if stake.Registered > fork {
+			continue
+		}
+		if stake.Unbonding > fork {
+			stake.Unbonding, stake.UnbondTxId, stake.UnbondIndex = 0, nil, 0
+		}
+		if stake.Slashed > fork {
+			stake.Slashed = 0
+		}
+		stakes = append(stakes, stake)
+	}
+	for i := len(branch) - 1; i >= 0; i-- {
+		stakes = replayStakeOperations(stakes, branch[i])
+	}
+	return stakes, nil
+}
+
+// replayStakeOperations applies the stake operations of a block of a side
+// chain to a copy of the registry. The block is not checked yet, malformed
+// operations are skipped.
+func replayStakeOperations(stakes []Stake, block *Block) []Stake {
+	for _, tx := range block.Transactions {
+		op := tx.Stake
+		if op == nil {
+			continue
+		}
+		switch op.Op {
+		case StakeRegister:
+			if op.Output < 0 || op.Output >= len(tx.Outputs) {
+				continue
+			}
+			out := tx.Outputs[op.Output]
+			stakes = append(stakes, Stake{Staker: out.AddressHash(), TxId: tx.Id, Index: op.Output, Amount: out.Value, Registered: block.Height})
+		case StakeUnregister:
+			for _, in := range tx.Inputs {
+				for i := range stakes {
+					if bytes.Equal(stakes[i].TxId, in.Id) && stakes[i].Index == in.OutIndex && stakes[i].Unbonding == 0 {
+						stakes[i].Unbonding, stakes[i].UnbondTxId, stakes[i].UnbondIndex = block.Height, tx.Id, op.Output
+					}
+				}
+			}
+		case StakeSlash:
+			if op.Evidence == nil {
+				continue
+			}
+			offender, err := op.Evidence.Verify()
+			if err != nil {
+				continue
+			}
+			for i := range stakes {
+				if bytes.Equal(stakes[i].Staker, offender) && stakes[i].Slashed == 0 && stakes[i].ActiveAt(op.Evidence.First.Header.Height) {
+					stakes[i].Slashed = block.Height
+				}
+			}
+		}
+	}
+	return stakes
+}
+
+// stakeWeights sums the stake active at a height per staker, ordered by
+// pubkey hash
+func stakeWeights(stakes []Stake, height int) ([][]byte, []int) {
+	amounts := make(map[string]int)
+	for _, stake := range stakes {
+		if stake.ActiveAt(height) {
+			amounts[string(stake.Staker)] += stake.Amount
+		}
+	}
+	var stakers [][]byte
+	for staker := range amounts {
+		stakers = append(stakers, []byte(staker))
+	}
+	sort.Slice(stakers, func(i, j int) bool {
+		return bytes.Compare(stakers[i], stakers[j]) < 0
+	})
+	var weights []int
+	for _, staker := range stakers {
+		weights = append(weights, amounts[string(staker)])
+	}
+	return stakers, weights
+}
+
+// CheckStakeOperation checks the stake operation of a transaction, and its
+// spending of registered stake, for the next block
+func (chain *BlockChain) CheckStakeOperation(tx *Transaction) error {
+	prevOuts, err := UTXOSet{chain}.prevOutputs(tx)
+	if err != nil {
+		return err
+	}
+	height := chain.GetBestHeight() + 1
+	return chain.Database.View(func(txn StoreTxn) error {
+		return checkStakeOperation(txn, tx, prevOuts, height)
+	})
+}
+
+// GetStakes lists the stake registry, including unregistered and slashed
+// stake
+func (chain *BlockChain) GetStakes() []Stake {
+	var stakes []Stake
+	err := chain.Database.View(func(txn StoreTxn) error {
+		var err error
+		stakes, err = getStakes(txn)
+		return err
+	})
+	Handle(err)
+	return stakes
+}
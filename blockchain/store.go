@@ -0,0 +1,90 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+var (
+	ErrKeyNotFound   = errors.New("Key not found")
+	ErrStopIteration = errors.New("Stop iteration")
+)
+
+// Store is the key value database holding blocks, chain state, the UTXO
+// set and the indexes. Every Update is applied atomically: either all of
+// its writes are committed or none are.
+type Store interface {
+	View(fn func(txn StoreTxn) error) error
+	Update(fn func(txn StoreTxn) error) error
+	Close() error
+}
+
+// StoreTxn is a transaction on a Store. Reads inside an Update observe the
+// writes made earlier in the same transaction.
+type StoreTxn interface {
+	Get(key []byte) ([]byte, error)
+	Set(key, value []byte) error
+	Delete(key []byte) error
+	// Iterate calls fn for every key with the prefix, in ascending order,
+	// starting at start (or at the prefix when start is nil). Returning
+	// ErrStopIteration from fn ends the iteration without an error.
+	Iterate(prefix, start []byte, fn func(key, value []byte) error) error
+}
+
+const (
+	BadgerBackend = "badger"
+	BoltBackend   = "bolt"
+	MemoryBackend = "memory"
+)
+
+type StoreConfig struct {
+	Backend string
+	Path    string
+}
+
+// DefaultStoreConfig returns the store configuration of a node, taken from
+// the DB_BACKEND and DB_PATH environment variables when they are set
+func DefaultStoreConfig(nodeId string) StoreConfig {
+	cfg := StoreConfig{os.Getenv("DB_BACKEND"), os.Getenv("DB_PATH")}
+	if cfg.Backend == "" {
+		cfg.Backend = BadgerBackend
+	}
+	if cfg.Path == "" {
+		cfg.Path = fmt.Sprintf(dbPath, nodeId)
+		if cfg.Backend == BoltBackend {
+			cfg.Path += ".bolt"
+		}
+	}
+	return cfg
+}
+
+func OpenStore(cfg StoreConfig) (Store, error) {
+	switch cfg.Backend {
+	case BadgerBackend:
+		return OpenBadgerStore(cfg.Path)
+	case BoltBackend:
+		return OpenBoltStore(cfg.Path)
+	case MemoryBackend:
+		return NewMemoryStore(), nil
+	}
+	return nil, fmt.Errorf("unknown database backend %q", cfg.Backend)
+}
+
+func StoreExists(cfg StoreConfig) bool {
+	switch cfg.Backend {
+	case BadgerBackend:
+		return DbExists(cfg.Path)
+	case BoltBackend:
+		_, err := os.Stat(cfg.Path)
+		return err == nil
+	}
+	return false
+}
+
+func hasKey(txn StoreTxn, key []byte) bool {
+	_, err := txn.Get(key)
+	return err == nil
+}
+
+var errReadOnly = errors.New("Transaction is read only")
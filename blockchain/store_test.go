@@ -0,0 +1,98 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// testStores opens an empty store of every backend
+func testStores(t *testing.T) map[string]Store {
+	t.Helper()
+	dir := t.TempDir()
+	badger, err := OpenBadgerStore(filepath.Join(dir, "badger"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bolt, err := OpenBoltStore(filepath.Join(dir, "bolt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stores := map[string]Store{BadgerBackend: badger, BoltBackend: bolt, MemoryBackend: NewMemoryStore()}
+	t.Cleanup(func() {
+		for _, store := range stores {
+			store.Close()
+		}
+	})
+	return stores
+}
+
+func TestStoreBackends(t *testing.T) {
+	for backend, store := range testStores(t) {
+		err := store.Update(func(txn StoreTxn) error {
+			for i := 0; i < 5; i++ {
+				if err := txn.Set([]byte(fmt.Sprintf("a-%d", i)), []byte{byte(i)}); err != nil {
+					return err
+				}
+			}
+			if err := txn.Set([]byte("b-0"), []byte{9}); err != nil {
+				return err
+			}
+			// reads see the writes of the transaction
+			if value, err := txn.Get([]byte("a-3")); err != nil || value[0] != 3 {
+				return fmt.Errorf("a-3 is %v, %v", value, err)
+			}
+			return txn.Delete([]byte("a-1"))
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", backend, err)
+		}
+
+		err = store.View(func(txn StoreTxn) error {
+			if _, err := txn.Get([]byte("a-1")); err == nil {
+				return errors.New("a deleted key is found")
+			}
+			var keys []string
+			err := txn.Iterate([]byte("a-"), []byte("a-2"), func(key, _ []byte) error {
+				keys = append(keys, string(key))
+				if len(keys) == 2 {
+					return ErrStopIteration
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if fmt.Sprint(keys) != "[a-2 a-3]" {
+				return fmt.Errorf("iterated %v", keys)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Errorf("%s: %v", backend, err)
+		}
+	}
+}
+
+func TestStoreUpdateIsAtomic(t *testing.T) {
+	failed := errors.New("failed")
+	for backend, store := range testStores(t) {
+		err := store.Update(func(txn StoreTxn) error {
+			if err := txn.Set([]byte("key"), []byte("value")); err != nil {
+				return err
+			}
+			return failed
+		})
+		if !errors.Is(err, failed) {
+			t.Errorf("%s: update returned %v", backend, err)
+		}
+		err = store.View(func(txn StoreTxn) error {
+			_, err := txn.Get([]byte("key"))
+			return err
+		})
+		if err == nil {
+			t.Errorf("%s: the write of a failed update is kept", backend)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/Harshjha3006/golang-blockchain/script"
+	"github.com/Harshjha3006/golang-blockchain/wallet"
+)
+
+// SwapContract is an output paying to the script hash of an atomic swap
+// contract. The initiator of a swap pays the participant with a contract
+// whose secret only the initiator knows, the participant pays back with a
+// contract on the other chain for the same secret hash and a shorter lock
+// time. Claiming the participant's contract reveals the secret, which lets
+// the participant claim the initiator's contract in turn.
+type SwapContract struct {
+	script.AtomicSwap
+	Contract []byte
+	TxId     []byte
+	Index    int
+	Output   TxOutput
+}
+
+// NewSwapSecret returns a random secret for an atomic swap and its hash
+func NewSwapSecret() (secret, secretHash []byte) {
+	secret = make([]byte, script.SecretSize)
+	_, err := rand.Read(secret)
+	Handle(err)
+	hash := sha256.Sum256(secret)
+	return secret, hash[:]
+}
+
+// FindSwapContract looks up the output of a transaction paying to a contract
+func (chain *BlockChain) FindSwapContract(txId []byte, contract []byte) (*SwapContract, error) {
+	swap, err := script.ExtractAtomicSwap(contract)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := chain.FindTransaction(txId)
+	if err != nil {
+		return nil, err
+	}
+	for index, out := range tx.Outputs {
+		if class, hash := script.Classify(out.Script); class == script.ScriptHash && bytes.Equal(hash, script.Hash160(contract)) {
+			return &SwapContract{*swap, contract, tx.Id, index, out}, nil
+		}
+	}
+	return nil, fmt.Errorf("transaction %x does not pay to the contract", txId)
+}
+
+// RedeemTx returns the transaction claiming the contract with the secret,
+// paying its value to the recipient
+func (c *SwapContract) RedeemTx(w *wallet.Wallet, secret []byte) (*Transaction, error) {
+	if !bytes.Equal(wallet.PubkeyHash(w.PublicKey), c.RecipientHash) {
+		return nil, errors.New("wallet is not the recipient of the contract")
+	}
+	if hash := sha256.Sum256(secret); !bytes.Equal(hash[:], c.SecretHash) {
+		return nil, errors.New("secret does not match the secret hash of the contract")
+	}
+	return c.spend(w, 0, func(signature []byte) []byte {
+		return script.AtomicSwapRedeem(signature, w.PublicKey, secret, c.Contract)
+	})
+}
+
+// RefundTx returns the transaction refunding the contract to its sender,
+// which can only be mined once the lock time of the contract has passed
+func (c *SwapContract) RefundTx(w *wallet.Wallet) (*Transaction, error) {
+	if !bytes.Equal(wallet.PubkeyHash(w.PublicKey), c.RefundHash) {
+		return nil, errors.New("wallet is not the refund address of the contract")
+	}
+	return c.spend(w, c.LockTime, func(signature []byte) []byte {
+		return script.AtomicSwapRefund(signature, w.PublicKey, c.Contract)
+	})
+}
+
+func (c *SwapContract) spend(w *wallet.Wallet, lockTime int64, unlock func(signature []byte) []byte) (*Transaction, error) {
+	if !w.CanSign() {
+		return nil, ErrCannotSign
+	}
+	inputs := []TxInput{{Id: c.TxId, OutIndex: c.Index}}
+	outputs := []TxOutput{*NewTXOutput(string(w.Address()), c.Output.Value)}
+	tx := Transaction{nil, inputs, outputs, lockTime, nil, nil, nil}
+	tx.setId()
+
+	tx.Inputs[0].Script = unlock(tx.signature(0, w.PrivateKey, c.Output))
+	if !tx.verifyInput(0, c.Output) {
+		return nil, errors.New("contract spend does not verify")
+	}
+	return &tx, nil
+}
+
+// Secret returns the secret revealed by a transaction claiming the
+// contract, nil when it does not claim it
+func (c *SwapContract) Secret(tx *Transaction) []byte {
+	for _, in := range tx.Inputs {
+		if bytes.Equal(in.Id, c.TxId) && in.OutIndex == c.Index {
+			return script.ExtractSecret(in.Script, c.SecretHash)
+		}
+	}
+	return nil
+}
+
+// FindSpendingTransaction looks up the main chain transaction spending an
+// output, from the tip back
+func (chain *BlockChain) FindSpendingTransaction(txId []byte, index int) (*Transaction, error) {
+	iter := chain.Iterator()
+	for {
+		block := iter.Next()
+		for _, tx := range block.Transactions {
+			if tx.IsCoinbase() {
+				continue
+			}
+			for _, in := range tx.Inputs {
+				if bytes.Equal(in.Id, txId) && in.OutIndex == index {
+					return tx, nil
+				}
+			}
+		}
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+	return nil, errors.New("output is not spent")
+}
@@ -0,0 +1,126 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/Harshjha3006/golang-blockchain/script"
+	"github.com/Harshjha3006/golang-blockchain/wallet"
+)
+
+// fundContract pays amount from sender to a new contract and mines it
+func fundContract(t *testing.T, chain *BlockChain, sender *wallet.Wallet, swap script.AtomicSwap, amount int) *SwapContract {
+	t.Helper()
+	contract := script.AtomicSwapContract(swap)
+	tx := pay(t, chain, sender, string(wallet.ScriptAddress(contract)), amount)
+	mine(t, chain, sender, tx)
+	c, err := chain.FindSwapContract(tx.Id, contract)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// TestAtomicSwap swaps coins of two chains: alice initiates on chain a,
+// bob participates on chain b
+func TestAtomicSwap(t *testing.T) {
+	alice, bob := wallet.MakeWallet(), wallet.MakeWallet()
+	chainA := newTestChain(t, alice, false, false)
+	chainB := newTestChain(t, bob, false, false)
+	aliceHash, bobHash := wallet.PubkeyHash(alice.PublicKey), wallet.PubkeyHash(bob.PublicKey)
+
+	secret, secretHash := NewSwapSecret()
+	initiated := fundContract(t, chainA, alice, script.AtomicSwap{
+		SecretHash: secretHash, RecipientHash: bobHash, RefundHash: aliceHash, LockTime: 20,
+	}, 60)
+	// bob checks the contract before paying his side for the same secret hash
+	if !bytes.Equal(initiated.RecipientHash, bobHash) || initiated.Output.Value != 60 {
+		t.Fatalf("initiated contract %+v", initiated.AtomicSwap)
+	}
+	participated := fundContract(t, chainB, bob, script.AtomicSwap{
+		SecretHash: initiated.SecretHash, RecipientHash: aliceHash, RefundHash: bobHash, LockTime: 10,
+	}, 40)
+
+	if _, err := participated.RedeemTx(alice, make([]byte, len(secret))); err == nil {
+		t.Error("contract is redeemed with a wrong secret")
+	}
+	if _, err := participated.RedeemTx(bob, secret); err == nil {
+		t.Error("contract is redeemed by its sender")
+	}
+
+	// alice claims bob's side, revealing the secret on chain b
+	redeem, err := participated.RedeemTx(alice, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := chainB.VerifyTransaction(redeem); err != nil {
+		t.Fatalf("redeem does not verify: %v", err)
+	}
+	mine(t, chainB, bob, redeem)
+	if got := balance(chainB, alice); got != 40 {
+		t.Errorf("alice has %d on chain b, want 40", got)
+	}
+
+	// bob finds the secret on chain b and claims alice's side
+	spending, err := chainB.FindSpendingTransaction(participated.TxId, participated.Index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revealed := participated.Secret(spending)
+	if !bytes.Equal(revealed, secret) {
+		t.Fatalf("revealed secret %x, want %x", revealed, secret)
+	}
+	claim, err := initiated.RedeemTx(bob, revealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mine(t, chainA, alice, claim)
+	if got := balance(chainA, bob); got != 60 {
+		t.Errorf("bob has %d on chain a, want 60", got)
+	}
+	checkUndo(t, chainA)
+	checkUndo(t, chainB)
+}
+
+func TestAtomicSwapRefund(t *testing.T) {
+	alice, bob := wallet.MakeWallet(), wallet.MakeWallet()
+	chain := newTestChain(t, alice, false, false)
+	_, secretHash := NewSwapSecret()
+	c := fundContract(t, chain, alice, script.AtomicSwap{
+		SecretHash:    secretHash,
+		RecipientHash: wallet.PubkeyHash(bob.PublicKey),
+		RefundHash:    wallet.PubkeyHash(alice.PublicKey),
+		LockTime:      4,
+	}, 60)
+
+	if _, err := c.RefundTx(bob); err == nil {
+		t.Error("contract is refunded to its recipient")
+	}
+	refund, err := c.RefundTx(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// height 2
+	if _, err := chain.MineBlock([]*Transaction{CoinbaseTx(address(alice), ""), refund}); !errors.Is(err, ErrTimelocked) {
+		t.Errorf("refund before the lock time: %v", err)
+	}
+	mine(t, chain, alice)
+	mine(t, chain, alice)
+	// height 4
+	if err := chain.CheckLocks(refund); err != nil {
+		t.Fatal(err)
+	}
+	mine(t, chain, alice, refund)
+	if got := balance(chain, alice); got != 500 {
+		t.Errorf("alice has %d, want 500", got)
+	}
+
+	// a refund signed without the contract's lock time does not unlock it
+	early := Transaction{nil, []TxInput{{Id: c.TxId, OutIndex: c.Index}}, refund.Outputs, 0, nil, nil, nil}
+	early.setId()
+	early.Inputs[0].Script = script.AtomicSwapRefund(early.signature(0, alice.PrivateKey, c.Output), alice.PublicKey, c.Contract)
+	if early.verifyInput(0, c.Output) {
+		t.Error("a refund without lock time unlocks the contract")
+	}
+}
@@ -0,0 +1,282 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// TokenIssuance creates a token, whose id is the hash of the first output
+// spent by the issuing transaction, or mints more of a mintable token. The
+// issued supply is paid to the token outputs of the transaction. An issuance
+// with metadata mints an NFT, which has no name.
+type TokenIssuance struct {
+	TokenId  []byte // token minted, nil when issuing a new token
+	Name     string
+	Supply   int
+	Mintable bool
+	Issuer   []byte // pubkey hash of the issuer, who alone can mint more
+	Metadata *NFTMetadata
+}
+
+// Token is an entry of the token registry
+type Token struct {
+	Id       []byte
+	Name     string
+	Issuer   []byte
+	Mintable bool
+	Supply   int
+	Height   int // height of the block issuing it
+	Metadata *NFTMetadata
+}
+
+var (
+	tokenPrefix     = []byte("token-")     // token id -> Token
+	tokenNamePrefix = []byte("tokenname-") // token name -> token id
+)
+
+var tokenName = regexp.MustCompile(`^[A-Za-z0-9._-]{1,32}$`)
+
+var ErrUnknownToken = errors.New("unknown token")
+
+func tokenKey(tokenId []byte) []byte {
+	return append(append([]byte{}, tokenPrefix...), tokenId...)
+}
+
+func tokenNameKey(name string) []byte {
+	return append(append([]byte{}, tokenNamePrefix...), name...)
+}
+
+func (t Token) Serialize() []byte {
+	var res bytes.Buffer
+	err := gob.NewEncoder(&res).Encode(t)
+	Handle(err)
+	return res.Bytes()
+}
+
+func DeserializeToken(data []byte) Token {
+	var t Token
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&t)
+	Handle(err)
+	return t
+}
+
+func getToken(txn StoreTxn, tokenId []byte) (Token, error) {
+	data, err := txn.Get(tokenKey(tokenId))
+	if err != nil {
+		return Token{}, fmt.Errorf("%w %x", ErrUnknownToken, tokenId)
+	}
+	return DeserializeToken(data), nil
+}
+
+// IssuedTokenId is the id of the token a transaction issues or mints
+func (tx *Transaction) IssuedTokenId() []byte {
+	if tx.Issuance == nil || len(tx.Inputs) == 0 {
+		return nil
+	}
+	if tx.Issuance.TokenId != nil {
+		return tx.Issuance.TokenId
+	}
+	return newTokenId(tx.Inputs[0])
+}
+
+func newTokenId(in TxInput) []byte {
+	hash := sha256.Sum256(utxoKey(in.Id, in.OutIndex))
+	return hash[:]
+}
+
+// TokenAmounts sums the tokens carried by outputs per token id
+func TokenAmounts(outs []TxOutput) map[string]int {
+	amounts := make(map[string]int)
+	for _, out := range outs {
+		if out.TokenId != nil {
+			amounts[string(out.TokenId)] += out.Tokens
+		}
+	}
+	return amounts
+}
+
+// checkTokenBalance checks that the transaction's outputs carry, for every
+// token, the tokens carried by the outputs it spends plus those it issues
+func (tx *Transaction) checkTokenBalance(prevOuts []TxOutput) error {
+	for outIdx, out := range tx.Outputs {
+		if (out.TokenId != nil) != (out.Tokens != 0) || out.Tokens < 0 {
+			return fmt.Errorf("output %d carries an invalid token amount", outIdx)
+		}
+		if out.TokenId != nil && out.IsData() {
+			return fmt.Errorf("data output %d cannot carry tokens", outIdx)
+		}
+	}
+	in := TokenAmounts(prevOuts)
+	if tx.Issuance != nil {
+		if tx.Issuance.Supply <= 0 {
+			return errors.New("issued supply must be positive")
+		}
+		in[string(tx.IssuedTokenId())] += tx.Issuance.Supply
+	}
+	out := TokenAmounts(tx.Outputs)
+	for tokenId, amount := range out {
+		if in[tokenId] != amount {
+			return fmt.Errorf("token %x does not balance: %d in, %d out", tokenId, in[tokenId], amount)
+		}
+	}
+	for tokenId, amount := range in {
+		if out[tokenId] != amount {
+			return fmt.Errorf("token %x does not balance: %d in, %d out", tokenId, amount, out[tokenId])
+		}
+	}
+	return nil
+}
+
+// checkIssuance checks the issuance of a transaction against the token
+// registry: a new token needs an unused name and an issuer spending an
+// input, minting needs a mintable token and an input of its issuer
+func checkIssuance(txn StoreTxn, tx *Transaction, prevOuts []TxOutput) error {
+	issuance := tx.Issuance
+	if issuance == nil {
+		return nil
+	}
+	if tx.IsCoinbase() {
+		return errors.New("coinbase cannot issue tokens")
+	}
+	spendsFrom := func(pubKeyHash []byte) bool {
+		for _, prevOut := range prevOuts {
+			if bytes.Equal(prevOut.AddressHash(), pubKeyHash) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if issuance.TokenId != nil {
+		token, err := getToken(txn, issuance.TokenId)
+		if err != nil {
+			return err
+		}
+		if !token.Mintable {
+			return fmt.Errorf("token %s has a fixed supply", token.Name)
+		}
+		if !spendsFrom(token.Issuer) {
+			return fmt.Errorf("only the issuer of token %s can mint it", token.Name)
+		}
+		return nil
+	}
+
+	if issuance.Metadata != nil {
+		if err := checkNFT(txn, issuance); err != nil {
+			return err
+		}
+	} else if !tokenName.MatchString(issuance.Name) {
+		return fmt.Errorf("invalid token name %q", issuance.Name)
+	} else if _, err := txn.Get(tokenNameKey(issuance.Name)); err == nil {
+		return fmt.Errorf("token name %s is taken", issuance.Name)
+	}
+	if len(issuance.Issuer) == 0 || !spendsFrom(issuance.Issuer) {
+		return errors.New("token issuer must spend one of the inputs")
+	}
+	return nil
+}
+
+// applyIssuance records the token issued or minted by a transaction in the
+// token registry
+func applyIssuance(txn StoreTxn, tx *Transaction, height int) error {
+	issuance := tx.Issuance
+	if issuance == nil {
+		return nil
+	}
+	if issuance.TokenId != nil {
+		token, err := getToken(txn, issuance.TokenId)
+		if err != nil {
+			return err
+		}
+		token.Supply += issuance.Supply
+		return txn.Set(tokenKey(token.Id), token.Serialize())
+	}
+	token := Token{tx.IssuedTokenId(), issuance.Name, issuance.Issuer, issuance.Mintable, issuance.Supply, height, issuance.Metadata}
+	indexKey := tokenNameKey(token.Name)
+	if token.IsNFT() {
+		indexKey = nftKey(token.Metadata.ContentHash)
+	}
+	if err := txn.Set(indexKey, token.Id); err != nil {
+		return err
+	}
+	return txn.Set(tokenKey(token.Id), token.Serialize())
+}
+
+// revertIssuance undoes applyIssuance
+func revertIssuance(txn StoreTxn, tx *Transaction) error {
+	issuance := tx.Issuance
+	if issuance == nil {
+		return nil
+	}
+	if issuance.TokenId != nil {
+		token, err := getToken(txn, issuance.TokenId)
+		if err != nil {
+			return err
+		}
+		token.Supply -= issuance.Supply
+		return txn.Set(tokenKey(token.Id), token.Serialize())
+	}
+	indexKey := tokenNameKey(issuance.Name)
+	if issuance.Metadata != nil {
+		indexKey = nftKey(issuance.Metadata.ContentHash)
+	}
+	if err := txn.Delete(indexKey); err != nil {
+		return err
+	}
+	return txn.Delete(tokenKey(tx.IssuedTokenId()))
+}
+
+// CheckIssuance checks the token issued or minted by a transaction against
+// the token registry
+func (chain *BlockChain) CheckIssuance(tx *Transaction) error {
+	if tx.Issuance == nil {
+		return nil
+	}
+	prevOuts, err := UTXOSet{chain}.prevOutputs(tx)
+	if err != nil {
+		return err
+	}
+	return chain.Database.View(func(txn StoreTxn) error {
+		return checkIssuance(txn, tx, prevOuts)
+	})
+}
+
+// GetToken looks up a token by its hex id or its name
+func (chain *BlockChain) GetToken(idOrName string) (Token, error) {
+	var token Token
+	err := chain.Database.View(func(txn StoreTxn) error {
+		if tokenId, err := txn.Get(tokenNameKey(idOrName)); err == nil {
+			token, err = getToken(txn, tokenId)
+			return err
+		}
+		tokenId, err := hex.DecodeString(idOrName)
+		if err != nil {
+			return fmt.Errorf("%w %s", ErrUnknownToken, idOrName)
+		}
+		token, err = getToken(txn, tokenId)
+		return err
+	})
+	return token, err
+}
+
+// GetTokens lists the registered tokens by name
+func (chain *BlockChain) GetTokens() []Token {
+	var tokens []Token
+	err := chain.Database.View(func(txn StoreTxn) error {
+		return txn.Iterate(tokenPrefix, nil, func(_, v []byte) error {
+			tokens = append(tokens, DeserializeToken(v))
+			return nil
+		})
+	})
+	Handle(err)
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].Name < tokens[j].Name
+	})
+	return tokens
+}
@@ -0,0 +1,196 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/Harshjha3006/golang-blockchain/wallet"
+)
+
+func issue(chain *BlockChain, issuer *wallet.Wallet, issuance TokenIssuance) (*Transaction, error) {
+	return buildTx(chain, issuer, func(b *TxBuilder) error {
+		return b.IssueToken(issuance, address(issuer))
+	})
+}
+
+func tokenBalance(chain *BlockChain, w *wallet.Wallet, tokenId []byte) int {
+	total := 0
+	for _, coin := range (UTXOSet{chain}).FindSpendableTokens([][]byte{wallet.PubkeyHash(w.PublicKey)}, tokenId) {
+		total += coin.Output.Tokens
+	}
+	return total
+}
+
+func TestTokens(t *testing.T) {
+	alice, bob := wallet.MakeWallet(), wallet.MakeWallet()
+	chain := newTestChain(t, alice, false, false)
+	mine(t, chain, alice, pay(t, chain, alice, address(bob), 30))
+
+	tx, err := issue(chain, alice, TokenIssuance{Name: "GOLD", Supply: 1000, Mintable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mine(t, chain, alice, tx)
+	tokenId := tx.IssuedTokenId()
+	token, err := chain.GetToken("GOLD")
+	if err != nil || !bytes.Equal(token.Id, tokenId) || token.Supply != 1000 ||
+		!bytes.Equal(token.Issuer, wallet.PubkeyHash(alice.PublicKey)) {
+		t.Fatalf("GOLD is %+v, %v", token, err)
+	}
+	if byId, err := chain.GetToken(hex.EncodeToString(tokenId)); err != nil || byId.Name != "GOLD" {
+		t.Errorf("token by id %+v, %v", byId, err)
+	}
+
+	send, err := buildTx(chain, alice, func(b *TxBuilder) error {
+		return b.AddTokenRecipient(address(bob), tokenId, 300)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mine(t, chain, alice, send)
+	if tokenBalance(chain, alice, tokenId) != 700 || tokenBalance(chain, bob, tokenId) != 300 {
+		t.Errorf("alice holds %d tokens and bob %d", tokenBalance(chain, alice, tokenId), tokenBalance(chain, bob, tokenId))
+	}
+
+	if tx, err := issue(chain, bob, TokenIssuance{Name: "GOLD", Supply: 5}); err != nil || chain.CheckIssuance(tx) == nil {
+		t.Errorf("a taken token name is issued again: %v", err)
+	}
+	if tx, err := issue(chain, bob, TokenIssuance{Name: "no spaces", Supply: 5}); err != nil || chain.CheckIssuance(tx) == nil {
+		t.Errorf("an invalid token name is issued: %v", err)
+	}
+	if tx, err := issue(chain, bob, TokenIssuance{TokenId: tokenId, Supply: 5}); err != nil || chain.CheckIssuance(tx) == nil {
+		t.Errorf("a token is minted by someone other than its issuer: %v", err)
+	} else if _, err := chain.MineBlock([]*Transaction{CoinbaseTx(address(bob), ""), tx}); err == nil {
+		t.Error("a block minting a token of another issuer is accepted")
+	}
+
+	mint, err := issue(chain, alice, TokenIssuance{TokenId: tokenId, Supply: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.CheckIssuance(mint); err != nil {
+		t.Fatal(err)
+	}
+	mine(t, chain, alice, mint)
+	if token, _ := chain.GetToken("GOLD"); token.Supply != 1050 || tokenBalance(chain, alice, tokenId) != 750 {
+		t.Errorf("supply %d after minting, alice holds %d", token.Supply, tokenBalance(chain, alice, tokenId))
+	}
+	withReverted(t, chain, 1, func(txn StoreTxn) {
+		if token, _ := getToken(txn, tokenId); token.Supply != 1000 {
+			t.Errorf("supply %d with the mint reverted", token.Supply)
+		}
+	})
+
+	fixed, err := issue(chain, bob, TokenIssuance{Name: "SILVER", Supply: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mine(t, chain, alice, fixed)
+	if tx, err := issue(chain, bob, TokenIssuance{TokenId: fixed.IssuedTokenId(), Supply: 5}); err != nil || chain.CheckIssuance(tx) == nil {
+		t.Errorf("a token of fixed supply is minted: %v", err)
+	}
+	withReverted(t, chain, 1, func(txn StoreTxn) {
+		if _, err := getToken(txn, fixed.IssuedTokenId()); err == nil {
+			t.Error("token is registered with its issuance reverted")
+		}
+		if _, err := txn.Get(tokenNameKey("SILVER")); err == nil {
+			t.Error("token name is taken with its issuance reverted")
+		}
+	})
+	checkUndo(t, chain)
+}
+
+func TestTokensBalance(t *testing.T) {
+	alice, bob := wallet.MakeWallet(), wallet.MakeWallet()
+	chain := newTestChain(t, alice, false, false)
+	tx, err := issue(chain, alice, TokenIssuance{Name: "GOLD", Supply: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mine(t, chain, alice, tx)
+	send, err := buildTx(chain, alice, func(b *TxBuilder) error {
+		return b.AddTokenRecipient(address(bob), tx.IssuedTokenId(), 40)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prevOuts, err := UTXOSet{chain}.prevOutputs(send)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := send.checkTokenBalance(prevOuts); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tokens := range []int{41, 0, -40} {
+		unbalanced := DeserializeTransaction(send.Serialize())
+		unbalanced.Outputs[0].Tokens = tokens
+		if unbalanced.checkTokenBalance(prevOuts) == nil {
+			t.Errorf("an output of %d tokens out of 40 balances", tokens)
+		}
+	}
+	data, err := NewDataOutput([]byte("note"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data.TokenId, data.Tokens = tx.IssuedTokenId(), 40
+	unbalanced := DeserializeTransaction(send.Serialize())
+	unbalanced.Outputs[0] = *data
+	if unbalanced.checkTokenBalance(prevOuts) == nil {
+		t.Error("a data output carries tokens")
+	}
+}
+
+func TestNFTs(t *testing.T) {
+	alice, bob := wallet.MakeWallet(), wallet.MakeWallet()
+	chain := newTestChain(t, alice, false, false)
+	mine(t, chain, alice, pay(t, chain, alice, address(bob), 30))
+	content := sha256.Sum256([]byte("artwork"))
+	metadata := &NFTMetadata{ContentHash: content[:], URI: "ipfs://artwork"}
+
+	for _, issuance := range []TokenIssuance{
+		{Supply: 2, Metadata: metadata},
+		{Supply: 1, Mintable: true, Metadata: metadata},
+		{Supply: 1, Metadata: &NFTMetadata{ContentHash: []byte("short")}},
+	} {
+		tx, err := issue(chain, alice, issuance)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if chain.CheckIssuance(tx) == nil {
+			t.Errorf("invalid NFT %+v is minted", issuance)
+		}
+	}
+
+	tx, err := issue(chain, alice, TokenIssuance{Supply: 1, Metadata: metadata})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mine(t, chain, alice, tx)
+	nft, err := chain.GetNFT(hex.EncodeToString(tx.IssuedTokenId()))
+	if err != nil || !nft.IsNFT() || nft.Metadata.URI != "ipfs://artwork" {
+		t.Fatalf("NFT %+v, %v", nft, err)
+	}
+	withReverted(t, chain, 1, func(txn StoreTxn) {
+		if _, err := txn.Get(nftKey(metadata.ContentHash)); err == nil {
+			t.Error("content is minted with its NFT reverted")
+		}
+	})
+	if tx, err := issue(chain, bob, TokenIssuance{Supply: 1, Metadata: metadata}); err != nil || chain.CheckIssuance(tx) == nil {
+		t.Errorf("content already minted is minted again: %v", err)
+	}
+
+	send, err := buildTx(chain, alice, func(b *TxBuilder) error {
+		return b.AddTokenRecipient(address(bob), nft.Id, 1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mine(t, chain, alice, send)
+	if nfts := (UTXOSet{chain}).FindNFTs(wallet.PubkeyHash(bob.PublicKey)); len(nfts) != 1 || !bytes.Equal(nfts[0].Id, nft.Id) {
+		t.Errorf("bob holds NFTs %+v", nfts)
+	}
+	checkUndo(t, chain)
+}
@@ -8,28 +8,31 @@ import (
 	"crypto/sha256"
 	"encoding/gob"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"math/big"
 	"strings"
-	"time"
 
+	"github.com/Harshjha3006/golang-blockchain/script"
 	"github.com/Harshjha3006/golang-blockchain/wallet"
 )
 
 type Transaction struct {
-	Id      []byte
-	Inputs  []TxInput
-	Outputs []TxOutput
+	Id       []byte
+	Inputs   []TxInput
+	Outputs  []TxOutput
+	LockTime int64           // earliest block height, or unix time from LockTimeThreshold, the transaction can be mined at
+	Issuance *TokenIssuance  // token issued or minted, nil for most transactions
+	Name     *NameOperation  // name registered, renewed or transferred, nil for most transactions
+	Stake    *StakeOperation // staker registered, unregistered or slashed, nil for most transactions
 }
 
 func (tx *Transaction) setId() {
-	var buffer bytes.Buffer
 	var hash [32]byte
-	encoder := gob.NewEncoder(&buffer)
-	err := encoder.Encode(time.Now().Unix())
-	Handle(err)
-	hash = sha256.Sum256(buffer.Bytes())
+	txCopy := *tx
+	txCopy.Id = []byte{}
+	hash = sha256.Sum256(txCopy.Serialize())
 	tx.Id = hash[:]
 
 }
@@ -41,10 +44,10 @@ func CoinbaseTx(to string, data string) *Transaction {
 		Handle(err)
 		data = fmt.Sprintf("%x", randData)
 	}
-	txinput := TxInput{[]byte{}, -1, nil, []byte(data)}
+	txinput := TxInput{Id: []byte{}, OutIndex: -1, Script: []byte(data)}
 	txoutput := *NewTXOutput(to, 100)
 
-	tx := Transaction{nil, []TxInput{txinput}, []TxOutput{txoutput}}
+	tx := Transaction{nil, []TxInput{txinput}, []TxOutput{txoutput}, 0, nil, nil, nil}
 
 	tx.setId()
 
@@ -52,75 +55,185 @@ func CoinbaseTx(to string, data string) *Transaction {
 
 }
 
-func (tx *Transaction) isCoinbase() bool {
+func (tx *Transaction) IsCoinbase() bool {
 	return len(tx.Inputs) == 1 && len(tx.Inputs[0].Id) == 0 && tx.Inputs[0].OutIndex == -1
 }
 
-func NewTransaction(w *wallet.Wallet, to string, amount int, utxo UTXOSet) *Transaction {
-	var inputs []TxInput
-	var outputs []TxOutput
+var (
+	ErrNotEnoughFunds = errors.New("not enough funds")
+	ErrCannotSign     = errors.New("private key of the wallet is not available")
+)
 
-	pubKeyHash := wallet.PubkeyHash(w.PublicKey)
-	acc, validOpts := utxo.FindSpendableOutputs(pubKeyHash, amount)
+func NewTransaction(w *wallet.Wallet, to string, amount int, utxo UTXOSet) (*Transaction, error) {
+	builder := NewTxBuilder(utxo)
+	if err := builder.AddSigner(w); err != nil {
+		return nil, err
+	}
+	if err := builder.AddRecipient(to, amount); err != nil {
+		return nil, err
+	}
+	builder.ChangeAddress = func() (string, error) {
+		return string(w.Address()), nil
+	}
+	return builder.Build()
+}
 
-	from := string(w.Address())
-	if acc < amount {
-		log.Panic("Err : Not enough funds")
+func (tx *Transaction) Sign(private ecdsa.PrivateKey, prevTxs map[string]Transaction) {
+	if tx.IsCoinbase() {
+		return
 	}
+	for inId := range tx.Inputs {
+		tx.SignInput(inId, private, prevTxs)
+	}
+}
 
-	for txId, out := range validOpts {
-		txId, err := hex.DecodeString(txId)
-		Handle(err)
+// SignInput signs a single input, so that inputs spending outputs of
+// different keys can each be signed with their own key
+func (tx *Transaction) SignInput(inId int, private ecdsa.PrivateKey, prevTxs map[string]Transaction) {
+	in := tx.Inputs[inId]
+	prevTX := prevTxs[hex.EncodeToString(in.Id)]
+	if prevTX.Id == nil {
+		log.Panic("ERROR: Previous transaction is not correct")
+	}
+	pubKey := append(private.X.FillBytes(make([]byte, 32)), private.Y.FillBytes(make([]byte, 32))...)
+	tx.signWith(inId, &wallet.Wallet{PrivateKey: private, PublicKey: pubKey}, prevTX.Outputs[in.OutIndex])
+}
 
-		for outIdx := range out {
-			input := TxInput{txId, outIdx, nil, w.PublicKey}
-			inputs = append(inputs, input)
+// signWith adds the wallet's signature to the unlocking script of an input
+// if its key can help unlock the output the input spends, as the key of a
+// pubkey hash output or one of the keys of a multisig redeem script, and
+// reports whether it signed
+func (tx *Transaction) signWith(inId int, w *wallet.Wallet, prevOut TxOutput) bool {
+	if tx.verifyInput(inId, prevOut) {
+		return false
+	}
+	in := &tx.Inputs[inId]
+	switch class, hash := script.Classify(prevOut.Script); class {
+	case script.PubKeyHash:
+		if !bytes.Equal(hash, wallet.PubkeyHash(w.PublicKey)) {
+			return false
+		}
+		in.Script = script.PubKeyHashUnlock(tx.signature(inId, w.PrivateKey, prevOut), w.PublicKey)
+		return true
+	case script.ScriptHash:
+		policy, redeem, signatures := tx.multisigInput(inId, prevOut)
+		if policy == nil {
+			return false
+		}
+		keyIdx := policy.KeyIndex(w.PublicKey)
+		if keyIdx < 0 || signatures[keyIdx] != nil {
+			return false
 		}
+		signatures[keyIdx] = tx.signature(inId, w.PrivateKey, prevOut)
+		in.Script = multisigUnlock(policy, signatures, redeem)
+		return true
 	}
-	outputs = append(outputs, *NewTXOutput(to, amount))
+	return false
+}
 
-	if acc > amount {
-		outputs = append(outputs, *NewTXOutput(from, acc-amount))
+// multisigInput returns the multisig policy revealed by an input spending a
+// script hash output and the signatures it already carries, one slot per
+// key of the policy
+func (tx *Transaction) multisigInput(inId int, prevOut TxOutput) (*wallet.MultisigPolicy, []byte, [][]byte) {
+	pushes, err := script.Pushes(tx.Inputs[inId].Script)
+	if err != nil || len(pushes) == 0 {
+		return nil, nil, nil
+	}
+	redeem := pushes[len(pushes)-1]
+	if !bytes.Equal(script.Hash160(redeem), prevOut.AddressHash()) {
+		return nil, nil, nil
+	}
+	policy, err := wallet.ParseMultisigPolicy(redeem)
+	if err != nil {
+		return nil, nil, nil
 	}
-	tx := Transaction{nil, inputs, outputs}
-	tx.setId()
-	utxo.Blockchain.SignTransaction(w.PrivateKey, &tx)
 
-	return &tx
+	signatures := make([][]byte, len(policy.PubKeys))
+	for _, signature := range pushes[:len(pushes)-1] {
+		for keyIdx, pubKey := range policy.PubKeys {
+			if signatures[keyIdx] == nil && tx.verifySignature(inId, pubKey, signature, prevOut) {
+				signatures[keyIdx] = signature
+				break
+			}
+		}
+	}
+	return policy, redeem, signatures
 }
 
-func (tx *Transaction) Sign(private ecdsa.PrivateKey, prevTxs map[string]Transaction) {
-	if tx.isCoinbase() {
-		return
+// multisigUnlock is the unlocking script of a multisig redeem script with up
+// to M of the signatures, in the order of the keys
+func multisigUnlock(policy *wallet.MultisigPolicy, signatures [][]byte, redeem []byte) []byte {
+	b := script.NewBuilder()
+	added := 0
+	for _, signature := range signatures {
+		if signature != nil && added < policy.M {
+			b.AddData(signature)
+			added++
+		}
 	}
+	return b.AddData(redeem).Script()
+}
 
-	for _, in := range tx.Inputs {
-		if prevTxs[hex.EncodeToString(in.Id)].Id == nil {
-			log.Panic("ERROR: Previous transaction is not correct")
+// mergeInput merges the signatures of another copy of an input into it
+func (tx *Transaction) mergeInput(inId int, prevOut TxOutput, other []byte) {
+	in := &tx.Inputs[inId]
+	mine := in.Script
+	if tx.verifyInput(inId, prevOut) {
+		return
+	}
+	in.Script = other
+	if tx.verifyInput(inId, prevOut) {
+		return
+	}
+	_, _, theirs := tx.multisigInput(inId, prevOut)
+	in.Script = mine
+	policy, redeem, signatures := tx.multisigInput(inId, prevOut)
+	if theirs == nil {
+		return
+	}
+	if policy == nil {
+		in.Script = other
+		return
+	}
+	for keyIdx, signature := range theirs {
+		if signatures[keyIdx] == nil {
+			signatures[keyIdx] = signature
 		}
 	}
+	in.Script = multisigUnlock(policy, signatures, redeem)
+}
 
-	txCopy := tx.TrimmedCopy()
-
-	for inId, in := range txCopy.Inputs {
-		prevTX := prevTxs[hex.EncodeToString(in.Id)]
-		txCopy.Inputs[inId].Signature = nil
-		txCopy.Inputs[inId].PubKey = prevTX.Outputs[in.OutIndex].PubKeyHash
-
-		dataToSign := fmt.Sprintf("%x\n", txCopy)
+// signature signs the transaction for an input, committing to the locking
+// script of the output it spends
+func (tx *Transaction) signature(inId int, private ecdsa.PrivateKey, prevOut TxOutput) []byte {
+	return signData(private, tx.sigHash(inId, prevOut))
+}
 
-		r, s, err := ecdsa.Sign(rand.Reader, &private, []byte(dataToSign))
-		Handle(err)
-		signature := append(r.Bytes(), s.Bytes()...)
+// sigHash is what the signatures of an input sign: the hash of the trimmed
+// transaction, serialized with every field, with the locking script of the
+// output the input spends in place of its unlocking script, followed by that
+// whole output. Committing to the value spent keeps a signer, like one of a
+// partial transaction, from being misled about the fee it pays. Signatures
+// made over the printout of the trimmed transaction, as earlier versions
+// did, do not verify.
+func (tx *Transaction) sigHash(inId int, prevOut TxOutput) []byte {
+	txCopy := tx.TrimmedCopy()
+	txCopy.Inputs[inId].Script = prevOut.Script
 
-		tx.Inputs[inId].Signature = signature
-		txCopy.Inputs[inId].PubKey = nil
-	}
+	data := append(txCopy.Serialize(), TxOutputs{[]TxOutput{prevOut}}.Serialize()...)
+	hash := sha256.Sum256(data)
+	return hash[:]
+}
 
+// signData signs data with a private key, returning r and s concatenated
+func signData(private ecdsa.PrivateKey, data []byte) []byte {
+	r, s, err := ecdsa.Sign(rand.Reader, &private, data)
+	Handle(err)
+	return append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
 }
 
 func (tx *Transaction) Verify(prevTxs map[string]Transaction) bool {
-	if tx.isCoinbase() {
+	if tx.IsCoinbase() {
 		return true
 	}
 
@@ -130,71 +243,167 @@ func (tx *Transaction) Verify(prevTxs map[string]Transaction) bool {
 		}
 	}
 
-	txCopy := tx.TrimmedCopy()
-	curve := elliptic.P256()
-
-	for inId, in := range tx.Inputs {
+	var prevOuts []TxOutput
+	for _, in := range tx.Inputs {
 		prevTx := prevTxs[hex.EncodeToString(in.Id)]
-		txCopy.Inputs[inId].Signature = nil
-		txCopy.Inputs[inId].PubKey = prevTx.Outputs[in.OutIndex].PubKeyHash
+		if in.OutIndex < 0 || in.OutIndex >= len(prevTx.Outputs) {
+			return false
+		}
+		prevOuts = append(prevOuts, prevTx.Outputs[in.OutIndex])
+	}
+	return tx.verifySpend(prevOuts) == nil && tx.checkTokenBalance(prevOuts) == nil
+}
 
-		r := big.Int{}
-		s := big.Int{}
+// verifySpend checks the scripts of the inputs against the outputs they
+// spend, given in input order, and that the outputs are valid and worth no
+// more than the outputs spent
+func (tx *Transaction) verifySpend(prevOuts []TxOutput) error {
+	inputValue, outputValue := 0, 0
+	for inId, prevOut := range prevOuts {
+		if !tx.verifyInput(inId, prevOut) {
+			return fmt.Errorf("input %d does not satisfy the script of the output it spends", inId)
+		}
+		inputValue += prevOut.Value
+	}
+	for _, out := range tx.Outputs {
+		if out.Value < 0 {
+			return errors.New("output value is negative")
+		}
+		if _, err := script.ExtractNullData(out.Script); out.IsData() && err != nil {
+			return err
+		}
+		outputValue += out.Value
+	}
+	if outputValue > inputValue {
+		return fmt.Errorf("outputs are worth %d, more than the %d spent", outputValue, inputValue)
+	}
+	return nil
+}
 
-		sigLen := len(in.Signature)
-		r.SetBytes(in.Signature[:(sigLen / 2)])
-		s.SetBytes(in.Signature[(sigLen / 2):])
+func (tx *Transaction) verifyInput(inId int, prevOut TxOutput) bool {
+	checker := txChecker{tx, inId, prevOut}
+	return script.Verify(tx.Inputs[inId].Script, prevOut.Script, checker) == nil
+}
 
-		x := big.Int{}
-		y := big.Int{}
-		keyLen := len(in.PubKey)
-		x.SetBytes(in.PubKey[:(keyLen / 2)])
-		y.SetBytes(in.PubKey[(keyLen / 2):])
+// txChecker checks the signatures and timelocks of the scripts of an input
+type txChecker struct {
+	tx      *Transaction
+	inId    int
+	prevOut TxOutput
+}
 
-		dataToVerify := fmt.Sprintf("%x\n", txCopy)
+func (c txChecker) CheckSig(signature, pubKey []byte) bool {
+	return c.tx.verifySignature(c.inId, pubKey, signature, c.prevOut)
+}
 
-		rawPubKey := ecdsa.PublicKey{Curve: curve, X: &x, Y: &y}
-		if !ecdsa.Verify(&rawPubKey, []byte(dataToVerify), &r, &s) {
-			return false
-		}
-		txCopy.Inputs[inId].PubKey = nil
+// CheckLockTime checks a lock time required by a script against the lock
+// time of the transaction, both being heights or both timestamps
+func (c txChecker) CheckLockTime(lockTime int64) bool {
+	if (lockTime < LockTimeThreshold) != (c.tx.LockTime < LockTimeThreshold) {
+		return false
 	}
+	return lockTime <= c.tx.LockTime
+}
+
+func (c txChecker) CheckSequence(sequence int64) bool {
+	return sequence <= int64(c.tx.Inputs[c.inId].Sequence)
+}
 
-	return true
+func (tx *Transaction) verifySignature(inId int, pubKey []byte, signature []byte, prevOut TxOutput) bool {
+	return verifyData(pubKey, signature, tx.sigHash(inId, prevOut))
 }
+
+// verifyData checks a signature made by signData
+func verifyData(pubKey []byte, signature []byte, data []byte) bool {
+	if len(signature) != 64 || len(pubKey) != 64 {
+		return false
+	}
+	r := big.Int{}
+	s := big.Int{}
+	r.SetBytes(signature[:32])
+	s.SetBytes(signature[32:])
+
+	x := big.Int{}
+	y := big.Int{}
+	x.SetBytes(pubKey[:32])
+	y.SetBytes(pubKey[32:])
+
+	rawPubKey := ecdsa.PublicKey{Curve: elliptic.P256(), X: &x, Y: &y}
+	if !rawPubKey.Curve.IsOnCurve(&x, &y) {
+		return false
+	}
+	return ecdsa.Verify(&rawPubKey, data, &r, &s)
+}
+
 func (tx *Transaction) TrimmedCopy() Transaction {
 	var inputs []TxInput
 	var outputs []TxOutput
 
 	for _, in := range tx.Inputs {
-		inputs = append(inputs, TxInput{in.Id, in.OutIndex, nil, nil})
+		inputs = append(inputs, TxInput{Id: in.Id, OutIndex: in.OutIndex, Sequence: in.Sequence})
 	}
 
 	for _, out := range tx.Outputs {
-		outputs = append(outputs, TxOutput{out.Value, out.PubKeyHash})
+		outputs = append(outputs, TxOutput{out.Value, out.Script, out.TokenId, out.Tokens})
 
 	}
 
-	return Transaction{tx.Id, inputs, outputs}
+	return Transaction{tx.Id, inputs, outputs, tx.LockTime, tx.Issuance, tx.Name, tx.Stake}
 }
 
 func (tx Transaction) String() string {
 	var lines []string
 
 	lines = append(lines, fmt.Sprintf("--- Transaction %x:", tx.Id))
+	if tx.IsCoinbase() {
+		lines = append(lines, "     Coinbase")
+	}
+	if tx.LockTime != 0 {
+		lines = append(lines, fmt.Sprintf("     Locked until %s", DescribeLockTime(tx.LockTime)))
+	}
+	if issuance := tx.Issuance; issuance != nil && issuance.Metadata != nil {
+		lines = append(lines, fmt.Sprintf("     Mints NFT %x of content %x at %s", tx.IssuedTokenId(), issuance.Metadata.ContentHash, issuance.Metadata.URI))
+	} else if issuance != nil {
+		lines = append(lines, fmt.Sprintf("     Issues %d of token %s %x", issuance.Supply, issuance.Name, tx.IssuedTokenId()))
+	}
+	if op := tx.Name; op != nil {
+		lines = append(lines, fmt.Sprintf("     Name %s %s to %s", op.Op, op.Name, wallet.AddressFromPubKeyHash(op.Owner)))
+	}
+	if op := tx.Stake; op != nil && op.Evidence != nil {
+		offender, _ := op.Evidence.Verify()
+		lines = append(lines, fmt.Sprintf("     Slashes %s for signing two blocks at height %d", wallet.AddressFromPubKeyHash(offender), op.Evidence.First.Header.Height))
+	} else if op != nil {
+		lines = append(lines, fmt.Sprintf("     Stake %s output %d", op.Op, op.Output))
+	}
 	for i, input := range tx.Inputs {
 		lines = append(lines, fmt.Sprintf("     Input %d:", i))
 		lines = append(lines, fmt.Sprintf("       TXID:     %x", input.Id))
 		lines = append(lines, fmt.Sprintf("       Out:       %d", input.OutIndex))
-		lines = append(lines, fmt.Sprintf("       Signature: %x", input.Signature))
-		lines = append(lines, fmt.Sprintf("       PubKey:    %x", input.PubKey))
+		if tx.IsCoinbase() {
+			lines = append(lines, fmt.Sprintf("       Script:    %x", input.Script))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("       Script:    %s", script.Disasm(input.Script)))
+		if input.Sequence != 0 {
+			lines = append(lines, fmt.Sprintf("       Sequence:  %d", input.Sequence))
+		}
+		if address := input.Address(); address != "" {
+			lines = append(lines, fmt.Sprintf("       Address:   %s", address))
+		}
 	}
 
+	total := 0
 	for i, output := range tx.Outputs {
 		lines = append(lines, fmt.Sprintf("     Output %d:", i))
 		lines = append(lines, fmt.Sprintf("       Value:  %d", output.Value))
-		lines = append(lines, fmt.Sprintf("       Script: %x", output.PubKeyHash))
+		if output.TokenId != nil {
+			lines = append(lines, fmt.Sprintf("       Tokens: %d of %x", output.Tokens, output.TokenId))
+		}
+		lines = append(lines, fmt.Sprintf("       Script: %s", script.Disasm(output.Script)))
+		lines = append(lines, fmt.Sprintf("       Address: %s", output.Address()))
+		total += output.Value
 	}
+	lines = append(lines, fmt.Sprintf("     Total output: %d", total))
 
 	return strings.Join(lines, "\n")
 }
@@ -0,0 +1,193 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/Harshjha3006/golang-blockchain/wallet"
+)
+
+func prevTransactions(t *testing.T, chain *BlockChain, tx *Transaction) map[string]Transaction {
+	t.Helper()
+	prevTxs := make(map[string]Transaction)
+	for _, in := range tx.Inputs {
+		prevTx, err := chain.FindTransaction(in.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prevTxs[hex.EncodeToString(prevTx.Id)] = prevTx
+	}
+	return prevTxs
+}
+
+func TestSignatureCoversTransaction(t *testing.T) {
+	alice, bob := wallet.MakeWallet(), wallet.MakeWallet()
+	chain := newTestChain(t, alice, false, false)
+	tx := pay(t, chain, alice, address(bob), 30)
+	prevTxs := prevTransactions(t, chain, tx)
+	if !tx.Verify(prevTxs) {
+		t.Fatal("signed transaction does not verify")
+	}
+
+	mutations := map[string]func(tx *Transaction){
+		"lock time":    func(tx *Transaction) { tx.LockTime = 1 },
+		"output value": func(tx *Transaction) { tx.Outputs[0].Value = 29 },
+		"output owner": func(tx *Transaction) { tx.Outputs[0].Lock([]byte(address(alice))) },
+		"sequence":     func(tx *Transaction) { tx.Inputs[0].Sequence = 1 },
+		"issuance": func(tx *Transaction) {
+			tx.Issuance = &TokenIssuance{Name: "GOLD", Supply: 1, Issuer: wallet.PubkeyHash(alice.PublicKey)}
+		},
+		"name operation": func(tx *Transaction) {
+			tx.Name = &NameOperation{NameRegister, "alice", wallet.PubkeyHash(alice.PublicKey)}
+		},
+		"stake operation": func(tx *Transaction) { tx.Stake = &StakeOperation{Op: StakeRegister} },
+	}
+	for name, mutate := range mutations {
+		copied := DeserializeTransaction(tx.Serialize())
+		mutate(&copied)
+		if copied.Verify(prevTxs) {
+			t.Errorf("signature does not cover the %s", name)
+		}
+	}
+}
+
+func TestMultisigSpend(t *testing.T) {
+	alice := wallet.MakeWallet()
+	signers := []*wallet.Wallet{wallet.MakeWallet(), wallet.MakeWallet(), wallet.MakeWallet()}
+	var pubKeys [][]byte
+	for _, w := range signers {
+		pubKeys = append(pubKeys, w.PublicKey)
+	}
+	policy, err := wallet.NewMultisigPolicy(2, pubKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chain := newTestChain(t, alice, false, false)
+	mine(t, chain, alice, pay(t, chain, alice, string(policy.Address()), 60))
+	if got := SumCoins(UTXOSet{chain}.FindSpendableCoins([][]byte{policy.Hash()})); got != 60 {
+		t.Fatalf("multisig address holds %d, want 60", got)
+	}
+
+	b := NewTxBuilder(UTXOSet{chain})
+	b.AddRedeemScript(policy.Script())
+	if err := b.AddRecipient(address(alice), 60); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Build(); err == nil {
+		t.Fatal("a multisig spend is built without its signers")
+	}
+	partial, err := b.BuildPartial()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// each signer signs their own copy
+	first, _ := DeserializePartial(partial.Serialize())
+	second, _ := DeserializePartial(partial.Serialize())
+	if n, err := first.Sign(signers[0]); err != nil || n != 1 {
+		t.Fatalf("first signer signed %d inputs, %v", n, err)
+	}
+	if _, err := first.Finalize(); err == nil {
+		t.Error("a 2-of-3 spend with one signature is final")
+	}
+	if n, err := second.Sign(signers[2]); err != nil || n != 1 {
+		t.Fatalf("second signer signed %d inputs, %v", n, err)
+	}
+	combined, err := CombinePartial(first, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := combined.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.VerifyTransaction(tx); err != nil {
+		t.Fatalf("multisig spend does not verify: %v", err)
+	}
+	mine(t, chain, alice, tx)
+	if got := balance(chain, alice); got != 300 {
+		t.Errorf("alice has %d, want 300", got)
+	}
+}
+
+func TestSignatureCoversSpentValue(t *testing.T) {
+	alice, bob := wallet.MakeWallet(), wallet.MakeWallet()
+	chain := newTestChain(t, alice, false, false)
+	tx := pay(t, chain, alice, address(bob), 30)
+	coin, err := UTXOSet{chain}.GetUnspentOutput(tx.Inputs[0].Id, tx.Inputs[0].OutIndex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prevOut := coin.Output
+	prevOut.Value--
+	if tx.verifyInput(0, prevOut) {
+		t.Error("signature verifies against another value of the spent output")
+	}
+
+	// a signer given a lower value of the coin than the one on chain signs
+	// a spend the chain rejects
+	b := NewTxBuilder(UTXOSet{chain})
+	if err := b.AddWatchOnly(address(alice)); err != nil {
+		t.Fatal(err)
+	}
+	b.ChangeAddress = func() (string, error) {
+		return address(alice), nil
+	}
+	if err := b.AddRecipient(address(bob), 30); err != nil {
+		t.Fatal(err)
+	}
+	partial, err := b.BuildPartial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	partial.PrevOutputs[0].Value = 30
+	if n, err := partial.Sign(alice); err != nil || n != 1 {
+		t.Fatalf("signed %d inputs, %v", n, err)
+	}
+	signed, err := partial.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.VerifyTransaction(signed); err == nil {
+		t.Error("a spend signed for another value of the coin verifies")
+	}
+}
+
+func TestRejectsInvalidTransactions(t *testing.T) {
+	alice, bob := wallet.MakeWallet(), wallet.MakeWallet()
+	chain := newTestChain(t, alice, false, false)
+	genesis := tip(t, chain)
+	coinbase := genesis.Transactions[0]
+
+	// a signed spend paying more than the coin holds
+	overpaying := Transaction{nil, []TxInput{{Id: coinbase.Id, OutIndex: 0}},
+		[]TxOutput{*NewTXOutput(address(bob), coinbase.Outputs[0].Value+1)}, 0, nil, nil, nil}
+	overpaying.setId()
+	if !overpaying.signWith(0, alice, coinbase.Outputs[0]) {
+		t.Fatal("alice cannot sign for her output")
+	}
+	// a spend changed after it was signed
+	tampered := DeserializeTransaction(pay(t, chain, alice, address(bob), 30).Serialize())
+	tampered.Outputs[0].Value = 40
+	tampered.setId()
+
+	for name, tx := range map[string]*Transaction{"overpaying": &overpaying, "tampered": &tampered} {
+		if err := chain.VerifyTransaction(tx); err == nil {
+			t.Errorf("%s transaction verifies", name)
+		}
+		if _, err := chain.MineBlock([]*Transaction{CoinbaseTx(address(alice), ""), tx}); err == nil {
+			t.Errorf("%s transaction is accepted in a block", name)
+		}
+	}
+	if !bytes.Equal(chain.LastHash, genesis.Hash) {
+		t.Error("a rejected block moved the tip")
+	}
+
+	unknown := DeserializeTransaction(overpaying.Serialize())
+	unknown.Inputs[0].Id = []byte("unknown")
+	if err := chain.VerifyTransaction(&unknown); err == nil {
+		t.Error("a transaction spending an unknown transaction verifies")
+	}
+}
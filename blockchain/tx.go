@@ -3,47 +3,125 @@ package blockchain
 import (
 	"bytes"
 	"encoding/gob"
+	"fmt"
 
+	"github.com/Harshjha3006/golang-blockchain/script"
 	"github.com/Harshjha3006/golang-blockchain/wallet"
 )
 
 type TxOutput struct {
-	Value      int    // value contained in the output
-	PubKeyHash []byte // PubKeyHash of the owner
+	Value   int    // value contained in the output
+	Script  []byte // locking script, the conditions to spend the output
+	TokenId []byte // token carried by the output, nil for coins only
+	Tokens  int    // amount of the token carried
 }
 
 type TxOutputs struct {
 	Outputs []TxOutput
 }
 type TxInput struct {
-	Id        []byte // id of the transaction referred
-	OutIndex  int    // index of the specific output referred
-	Signature []byte // signature generated by the private key of the spender
-	PubKey    []byte // Pub key of the spender
+	Id       []byte // id of the transaction referred
+	OutIndex int    // index of the specific output referred
+	Script   []byte // unlocking script, satisfying the locking script of the output referred
+	Sequence int    // relative lock time, blocks the output referred must be confirmed for
 }
 
-func (in *TxInput) CanUseKey(pubKeyHash []byte) bool {
-	lockingHash := wallet.PubkeyHash(in.PubKey)
+// LockingHash is the hash of the last item pushed by the unlocking script,
+// the public key of a pubkey hash spend or the redeem script of a script
+// hash spend
+func (in *TxInput) LockingHash() []byte {
+	pushes, err := script.Pushes(in.Script)
+	if err != nil || len(pushes) == 0 {
+		return nil
+	}
+	return script.Hash160(pushes[len(pushes)-1])
+}
 
-	return bytes.Equal(lockingHash, pubKeyHash)
+// Address returns the address an input spends from, as far as its
+// unlocking script tells
+func (in *TxInput) Address() string {
+	pushes, err := script.Pushes(in.Script)
+	if err != nil || len(pushes) == 0 {
+		return ""
+	}
+	last := pushes[len(pushes)-1]
+	if len(pushes) == 2 && len(last) == 64 {
+		return string(wallet.AddressFromPubKeyHash(wallet.PubkeyHash(last)))
+	}
+	return string(wallet.ScriptAddress(last))
+}
+
+func (in *TxInput) CanUseKey(pubKeyHash []byte) bool {
+	return bytes.Equal(in.LockingHash(), pubKeyHash)
 }
 
 func (out *TxOutput) Lock(address []byte) {
 	pubKeyHash := wallet.Base58Decode(address)
 	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	out.PubKeyHash = pubKeyHash
+	if wallet.IsScriptAddress(string(address)) {
+		out.Script = script.PayToScriptHash(pubKeyHash)
+		return
+	}
+	out.Script = script.PayToPubKeyHash(pubKeyHash)
+}
+
+// Timelock returns the lock time and relative lock time in blocks the
+// output is locked with, zero when it is not
+func (out *TxOutput) Timelock() (int64, int) {
+	lockTime, afterBlocks, _ := script.Timelock(out.Script)
+	return lockTime, int(afterBlocks)
+}
+
+// AddressHash is the pubkey hash or script hash the output's address
+// encodes, nil for non standard scripts
+func (out *TxOutput) AddressHash() []byte {
+	_, hash := script.Classify(out.Script)
+	return hash
+}
+
+// Address returns the address the output is locked to
+func (out *TxOutput) Address() string {
+	switch class, hash := script.Classify(out.Script); class {
+	case script.PubKeyHash:
+		return string(wallet.AddressFromPubKeyHash(hash))
+	case script.ScriptHash:
+		return string(wallet.ScriptAddressFromHash(hash))
+	}
+	return ""
 }
 
 func (out *TxOutput) IsLockedWithKey(pubKeyHash []byte) bool {
-	return bytes.Equal(out.PubKeyHash, pubKeyHash)
+	return bytes.Equal(out.AddressHash(), pubKeyHash)
 }
 
 func NewTXOutput(address string, value int) *TxOutput {
-	txo := TxOutput{value, nil}
+	txo := TxOutput{value, nil, nil, 0}
 	txo.Lock([]byte(address))
 	return &txo
 }
 
+// NewTokenOutput returns an output carrying tokens to an address
+func NewTokenOutput(address string, tokenId []byte, amount int) *TxOutput {
+	txo := NewTXOutput(address, 0)
+	txo.TokenId = tokenId
+	txo.Tokens = amount
+	return txo
+}
+
+// NewDataOutput returns an unspendable output carrying data
+func NewDataOutput(data []byte) (*TxOutput, error) {
+	if len(data) > script.MaxDataSize {
+		return nil, fmt.Errorf("data is larger than %d bytes", script.MaxDataSize)
+	}
+	return &TxOutput{0, script.NullDataScript(data), nil, 0}, nil
+}
+
+// IsData reports whether the output is unspendable, carrying data rather
+// than coins, and is never added to the UTXO set
+func (out *TxOutput) IsData() bool {
+	return script.IsUnspendable(out.Script)
+}
+
 func (outs TxOutputs) Serialize() []byte {
 	var res bytes.Buffer
 	encoder := gob.NewEncoder(&res)
@@ -0,0 +1,138 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+)
+
+var (
+	txIndexPrefix = []byte("txi-")    // txid -> location of the transaction in the main chain
+	txIndexFlag   = []byte("txindex") // present when the transaction index is enabled
+)
+
+type TxLocation struct {
+	BlockHash []byte // hash of the main chain block containing the transaction
+	Position  int    // index of the transaction in the block
+}
+
+func txIndexKey(txId []byte) []byte {
+	key := append([]byte{}, txIndexPrefix...)
+	return append(key, txId...)
+}
+
+func (loc TxLocation) Serialize() []byte {
+	var res bytes.Buffer
+	encoder := gob.NewEncoder(&res)
+	err := encoder.Encode(loc)
+	Handle(err)
+	return res.Bytes()
+}
+
+func DeserializeTxLocation(data []byte) TxLocation {
+	var loc TxLocation
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	err := decoder.Decode(&loc)
+	Handle(err)
+	return loc
+}
+
+func txIndexEnabled(txn StoreTxn) bool {
+	return hasKey(txn, txIndexFlag)
+}
+
+func indexTransactions(txn StoreTxn, block *Block) error {
+	for pos, tx := range block.Transactions {
+		loc := TxLocation{block.Hash, pos}
+		if err := txn.Set(txIndexKey(tx.Id), loc.Serialize()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func unindexTransactions(txn StoreTxn, block *Block) error {
+	for _, tx := range block.Transactions {
+		loc, err := getTxLocation(txn, tx.Id)
+		if err != nil || !bytes.Equal(loc.BlockHash, block.Hash) {
+			continue
+		}
+		if err := txn.Delete(txIndexKey(tx.Id)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getTxLocation(txn StoreTxn, txId []byte) (TxLocation, error) {
+	data, err := txn.Get(txIndexKey(txId))
+	if err != nil {
+		return TxLocation{}, errors.New("Transaction does not exist")
+	}
+	return DeserializeTxLocation(data), nil
+}
+
+// TxIndexEnabled reports whether the chain maintains a transaction index
+func (chain *BlockChain) TxIndexEnabled() bool {
+	var enabled bool
+	err := chain.Database.View(func(txn StoreTxn) error {
+		enabled = txIndexEnabled(txn)
+		return nil
+	})
+	Handle(err)
+	return enabled
+}
+
+// ReIndexTransactions rebuilds the transaction index from the main chain and
+// enables it, so that it is kept up to date as blocks are connected
+func (chain *BlockChain) ReIndexTransactions() {
+	utxo := UTXOSet{chain}
+	utxo.DeleteByPrefix(txIndexPrefix)
+
+	iter := chain.IteratorFrom(0)
+	for {
+		block := iter.Next()
+		if block == nil {
+			break
+		}
+		err := chain.Database.Update(func(txn StoreTxn) error {
+			return indexTransactions(txn, block)
+		})
+		Handle(err)
+	}
+
+	err := chain.Database.Update(func(txn StoreTxn) error {
+		return txn.Set(txIndexFlag, []byte{1})
+	})
+	Handle(err)
+}
+
+// FindTransactionLocation returns the block and position of a main chain
+// transaction using the transaction index
+func (chain *BlockChain) FindTransactionLocation(ID []byte) (TxLocation, error) {
+	var loc TxLocation
+	err := chain.Database.View(func(txn StoreTxn) error {
+		if !txIndexEnabled(txn) {
+			return errors.New("Transaction index is not enabled")
+		}
+		var err error
+		loc, err = getTxLocation(txn, ID)
+		return err
+	})
+	return loc, err
+}
+
+func findIndexedTransaction(txn StoreTxn, ID []byte) (Transaction, error) {
+	loc, err := getTxLocation(txn, ID)
+	if err != nil {
+		return Transaction{}, err
+	}
+	block, err := getBlock(txn, loc.BlockHash)
+	if err != nil {
+		return Transaction{}, err
+	}
+	if loc.Position >= len(block.Transactions) {
+		return Transaction{}, errors.New("Transaction index is corrupted")
+	}
+	return *block.Transactions[loc.Position], nil
+}
@@ -2,163 +2,380 @@ package blockchain
 
 import (
 	"bytes"
-	"encoding/hex"
+	"encoding/gob"
+	"errors"
+	"fmt"
 	"log"
-
-	"github.com/dgraph-io/badger"
+	"time"
 )
 
 type UTXOSet struct {
 	Blockchain *BlockChain
 }
 
+// UnspentOutput is an entry of the UTXO set
+type UnspentOutput struct {
+	TxId   []byte
+	Index  int
+	Output TxOutput
+	Height int // height of the block containing the transaction
+}
+
 var (
-	utxoPrefix = []byte("utxo-")
+	utxoPrefix   = []byte("utxo-") // txid, output index -> UnspentOutput
+	undoPrefix   = []byte("undo-") // block hash -> outputs spent by the block
+	utxoBestHash = []byte("ub")    // hash of the block the UTXO set is up to date with
 )
 
-func (u UTXOSet) FindUTXO(pubKeyHash []byte) []TxOutput {
-	var UTXOs []TxOutput
+func utxoKey(txId []byte, index int) []byte {
+	key := append([]byte{}, utxoPrefix...)
+	key = append(key, txId...)
+	return append(key, ToHex(int64(index))...)
+}
 
-	db := u.Blockchain.Database
+func undoKey(blockHash []byte) []byte {
+	key := append([]byte{}, undoPrefix...)
+	return append(key, blockHash...)
+}
 
-	err := db.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
+func (u UnspentOutput) Serialize() []byte {
+	var res bytes.Buffer
+	encoder := gob.NewEncoder(&res)
+	err := encoder.Encode(u)
+	Handle(err)
+	return res.Bytes()
+}
+
+func DeserializeUnspentOutput(data []byte) UnspentOutput {
+	var u UnspentOutput
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	err := decoder.Decode(&u)
+	Handle(err)
+	return u
+}
 
-		it := txn.NewIterator(opts)
-		defer it.Close()
+func serializeUndo(spent []UnspentOutput) []byte {
+	var res bytes.Buffer
+	encoder := gob.NewEncoder(&res)
+	err := encoder.Encode(spent)
+	Handle(err)
+	return res.Bytes()
+}
 
-		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
-			item := it.Item()
-			v, err := item.Value()
-			Handle(err)
-			outs := DeserializeTxOutputs(v)
+func deserializeUndo(data []byte) []UnspentOutput {
+	var spent []UnspentOutput
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	err := decoder.Decode(&spent)
+	Handle(err)
+	return spent
+}
 
-			for _, out := range outs.Outputs {
-				if out.IsLockedWithKey(pubKeyHash) {
-					UTXOs = append(UTXOs, out)
+func getUnspentOutput(txn StoreTxn, txId []byte, index int) (UnspentOutput, error) {
+	data, err := txn.Get(utxoKey(txId, index))
+	if err != nil {
+		return UnspentOutput{}, fmt.Errorf("output %x:%d is not in the UTXO set", txId, index)
+	}
+	return DeserializeUnspentOutput(data), nil
+}
+
+// applyBlock removes the outputs spent by the block from the UTXO set, adds
+// the spendable outputs it creates, registers the tokens it issues, updates
+// the name index and the stake registry and records undo data to reverse it.
+// It fails if an input does not satisfy the script of the output it spends,
+// if a transaction pays more than it spends, if its timelocks do not allow
+// it in the block, if its tokens do not balance or if its name or stake
+// operation is not allowed.
+func applyBlock(txn StoreTxn, block *Block) error {
+	var spent []UnspentOutput
+
+	for _, tx := range block.Transactions {
+		var prevOuts []TxOutput
+		if !tx.IsCoinbase() {
+			var prevHeights []int
+			for _, in := range tx.Inputs {
+				out, err := getUnspentOutput(txn, in.Id, in.OutIndex)
+				if err != nil {
+					return err
+				}
+				if err := txn.Delete(utxoKey(in.Id, in.OutIndex)); err != nil {
+					return err
 				}
+				spent = append(spent, out)
+				prevHeights = append(prevHeights, out.Height)
+				prevOuts = append(prevOuts, out.Output)
+			}
+			if err := checkLocks(tx, prevHeights, block.Height, block.Timstamp); err != nil {
+				return fmt.Errorf("transaction %x: %w", tx.Id, err)
+			}
+			if err := tx.verifySpend(prevOuts); err != nil {
+				return fmt.Errorf("transaction %x: %w", tx.Id, err)
+			}
+		}
+		if err := tx.checkTokenBalance(prevOuts); err != nil {
+			return fmt.Errorf("transaction %x: %w", tx.Id, err)
+		}
+		if err := checkIssuance(txn, tx, prevOuts); err != nil {
+			return fmt.Errorf("transaction %x: %w", tx.Id, err)
+		}
+		if err := applyIssuance(txn, tx, block.Height); err != nil {
+			return err
+		}
+		if err := checkNameOperation(txn, tx, prevOuts, block.Height); err != nil {
+			return fmt.Errorf("transaction %x: %w", tx.Id, err)
+		}
+		if err := applyNameOperation(txn, tx, block.Height); err != nil {
+			return err
+		}
+		if err := checkStakeOperation(txn, tx, prevOuts, block.Height); err != nil {
+			return fmt.Errorf("transaction %x: %w", tx.Id, err)
+		}
+		burnt, err := applyStakeOperation(txn, tx, block.Height)
+		if err != nil {
+			return err
+		}
+		spent = append(spent, burnt...)
+		for outIdx, out := range tx.Outputs {
+			if out.IsData() {
+				continue
+			}
+			entry := UnspentOutput{tx.Id, outIdx, out, block.Height}
+			if err := txn.Set(utxoKey(tx.Id, outIdx), entry.Serialize()); err != nil {
+				return err
 			}
 		}
+	}
+	if err := txn.Set(undoKey(block.Hash), serializeUndo(spent)); err != nil {
+		return err
+	}
+	return txn.Set(utxoBestHash, block.Hash)
+}
 
-		return nil
-	})
-	Handle(err)
+// revertBlock undoes applyBlock for the tip of the UTXO set
+func revertBlock(txn StoreTxn, block *Block) error {
+	data, err := txn.Get(undoKey(block.Hash))
+	if err != nil {
+		return errors.New("Undo data of the block is missing, run reindexutxo")
+	}
+	spent := deserializeUndo(data)
 
-	return UTXOs
+	inBlock := make(map[string]bool)
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		tx := block.Transactions[i]
+		inBlock[string(tx.Id)] = true
+		if err := revertIssuance(txn, tx); err != nil {
+			return err
+		}
+		if err := revertNameOperation(txn, tx); err != nil {
+			return err
+		}
+		if err := revertStakeOperation(txn, tx, block.Height); err != nil {
+			return err
+		}
+		for outIdx, out := range tx.Outputs {
+			if out.IsData() {
+				continue
+			}
+			if err := txn.Delete(utxoKey(tx.Id, outIdx)); err != nil {
+				return err
+			}
+		}
+	}
+	for _, out := range spent {
+		// outputs created and spent within the block were never unspent
+		// before it
+		if inBlock[string(out.TxId)] {
+			continue
+		}
+		if err := txn.Set(utxoKey(out.TxId, out.Index), out.Serialize()); err != nil {
+			return err
+		}
+	}
+	if err := txn.Delete(undoKey(block.Hash)); err != nil {
+		return err
+	}
+	return txn.Set(utxoBestHash, block.PrevHash)
 }
-func (utxo UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int) {
-	unspentOuts := make(map[string][]int)
-	accumulated := 0
 
-	db := utxo.Blockchain.Database
+func (u UTXOSet) FindUTXO(pubKeyHash []byte) []TxOutput {
+	var UTXOs []TxOutput
 
-	err := db.View(func(txn *badger.Txn) error {
-		iter := txn.NewIterator(badger.DefaultIteratorOptions)
-		defer iter.Close()
-
-		for iter.Seek(utxoPrefix); iter.ValidForPrefix(utxoPrefix); iter.Next() {
-			item := iter.Item()
-			key := item.Key()
-			key = bytes.TrimPrefix(key, utxoPrefix)
-			v, err := item.Value()
-			Handle(err)
-			outputs := DeserializeTxOutputs(v)
-			txId := hex.EncodeToString(key)
-			for outId, out := range outputs.Outputs {
-				if out.IsLockedWithKey(pubKeyHash) && accumulated < amount {
-					accumulated += out.Value
-					unspentOuts[txId] = append(unspentOuts[txId], outId)
+	if u.Blockchain.AddrIndexEnabled() {
+		unspent, err := u.Blockchain.GetAddressUnspent(pubKeyHash)
+		Handle(err)
+		err = u.Blockchain.Database.View(func(txn StoreTxn) error {
+			for _, e := range unspent {
+				out, err := getUnspentOutput(txn, e.TxId, e.Index)
+				if err != nil {
+					return err
 				}
+				UTXOs = append(UTXOs, out.Output)
 			}
+			return nil
+		})
+		Handle(err)
+		return UTXOs
+	}
 
-		}
-		return nil
+	db := u.Blockchain.Database
+
+	err := db.View(func(txn StoreTxn) error {
+		return txn.Iterate(utxoPrefix, nil, func(_, v []byte) error {
+			out := DeserializeUnspentOutput(v)
+			if out.Output.IsLockedWithKey(pubKeyHash) {
+				UTXOs = append(UTXOs, out.Output)
+			}
+			return nil
+		})
 	})
 	Handle(err)
-	return accumulated, unspentOuts
 
+	return UTXOs
 }
-func (utxo UTXOSet) ReIndex() {
-	db := utxo.Blockchain.Database
-	utxo.DeleteByPrefix(utxoPrefix)
-	UTXO := utxo.Blockchain.FindUTXO()
-	err := db.Update(func(txn *badger.Txn) error {
-		for txId, outs := range UTXO {
-			key, err := hex.DecodeString(txId)
-			if err != nil {
-				return err
+
+// FindSpendableCoins returns the unspent outputs locked to any of the pubkey
+// hashes whose timelocks allow spending them in the next block, leaving out
+// outputs carrying tokens and registered stake
+func (utxo UTXOSet) FindSpendableCoins(pubKeyHashes [][]byte) []UnspentOutput {
+	return utxo.findSpendable(pubKeyHashes, nil)
+}
+
+// FindSpendableTokens is FindSpendableCoins for the outputs carrying a token
+func (utxo UTXOSet) FindSpendableTokens(pubKeyHashes [][]byte, tokenId []byte) []UnspentOutput {
+	return utxo.findSpendable(pubKeyHashes, tokenId)
+}
+
+func (utxo UTXOSet) findSpendable(pubKeyHashes [][]byte, tokenId []byte) []UnspentOutput {
+	owned := make(map[string]bool)
+	for _, pubKeyHash := range pubKeyHashes {
+		owned[string(pubKeyHash)] = true
+	}
+	height, now := utxo.Blockchain.GetBestHeight()+1, time.Now().Unix()
+
+	var coins []UnspentOutput
+	err := utxo.Blockchain.Database.View(func(txn StoreTxn) error {
+		return txn.Iterate(utxoPrefix, nil, func(_, v []byte) error {
+			out := DeserializeUnspentOutput(v)
+			if owned[string(out.Output.AddressHash())] && bytes.Equal(out.Output.TokenId, tokenId) && out.Spendable(height, now) &&
+				!isStaked(txn, out.TxId, out.Index) {
+				coins = append(coins, out)
 			}
-			key = append(utxoPrefix, key...)
-			err = txn.Set(key, outs.Serialize())
-			Handle(err)
+			return nil
+		})
+	})
+	Handle(err)
+	return coins
+}
+
+func (utxo UTXOSet) GetUnspentOutput(txId []byte, index int) (UnspentOutput, error) {
+	var out UnspentOutput
+	err := utxo.Blockchain.Database.View(func(txn StoreTxn) error {
+		var err error
+		out, err = getUnspentOutput(txn, txId, index)
+		return err
+	})
+	return out, err
+}
+
+// checkSpendable returns an error unless a coin is unspent and can be spent
+// in the next block, and is not registered stake unless allowStaked
+func (utxo UTXOSet) checkSpendable(coin UnspentOutput, allowStaked bool) error {
+	height, now := utxo.Blockchain.GetBestHeight()+1, time.Now().Unix()
+	return utxo.Blockchain.Database.View(func(txn StoreTxn) error {
+		out, err := getUnspentOutput(txn, coin.TxId, coin.Index)
+		if err != nil {
+			return err
+		}
+		if !out.Spendable(height, now) {
+			return fmt.Errorf("output %x:%d is timelocked", coin.TxId, coin.Index)
+		}
+		if !allowStaked && isStaked(txn, coin.TxId, coin.Index) {
+			return fmt.Errorf("output %x:%d is registered stake, unregister it first", coin.TxId, coin.Index)
 		}
 		return nil
 	})
-	Handle(err)
 }
 
-func (utxo UTXOSet) Update(block *Block) {
-	db := utxo.Blockchain.Database
+// prevOutputs returns the unspent outputs spent by a transaction
+func (utxo UTXOSet) prevOutputs(tx *Transaction) ([]TxOutput, error) {
+	var prevOuts []TxOutput
+	for _, in := range tx.Inputs {
+		out, err := utxo.GetUnspentOutput(in.Id, in.OutIndex)
+		if err != nil {
+			return nil, err
+		}
+		prevOuts = append(prevOuts, out.Output)
+	}
+	return prevOuts, nil
+}
 
-	err := db.Update(func(txn *badger.Txn) error {
-		for _, tx := range block.Transactions {
-			if !tx.isCoinbase() {
-				for _, in := range tx.Inputs {
-					UpdatedOutputs := TxOutputs{}
-					inId := append(utxoPrefix, in.Id...)
-					item, err := txn.Get(inId)
-					Handle(err)
-					v, err := item.Value()
-					Handle(err)
-					outputs := DeserializeTxOutputs(v)
-
-					for outId, out := range outputs.Outputs {
-						if outId != in.OutIndex {
-							UpdatedOutputs.Outputs = append(UpdatedOutputs.Outputs, out)
-						}
-					}
-
-					if len(UpdatedOutputs.Outputs) == 0 {
-						err := txn.Delete(inId)
-						Handle(err)
-					} else {
-						if err := txn.Set(inId, UpdatedOutputs.Serialize()); err != nil {
-							log.Panic(err)
-						}
-					}
+// ReIndex rebuilds the UTXO set and its undo data by replaying the main
+// chain. The best block marker is removed first so that an interrupted
+// rebuild is detected on startup.
+func (utxo UTXOSet) ReIndex() {
+	db := utxo.Blockchain.Database
+	err := db.Update(func(txn StoreTxn) error {
+		return txn.Delete(utxoBestHash)
+	})
+	Handle(err)
+	utxo.DeleteByPrefix(utxoPrefix)
+	utxo.DeleteByPrefix(undoPrefix)
+	utxo.DeleteByPrefix(tokenPrefix)
+	utxo.DeleteByPrefix(tokenNamePrefix)
+	utxo.DeleteByPrefix(nftPrefix)
+	utxo.DeleteByPrefix(namePrefix)
+	utxo.DeleteByPrefix(nameUndoPrefix)
+	utxo.DeleteByPrefix(stakePrefix)
+	utxo.DeleteByPrefix(evidencePrefix)
 
-				}
-			}
-			newOutputs := TxOutputs{}
+	iter := utxo.Blockchain.IteratorFrom(0)
+	for {
+		block := iter.Next()
+		if block == nil {
+			break
+		}
+		err = db.Update(func(txn StoreTxn) error {
+			return applyBlock(txn, block)
+		})
+		Handle(err)
+	}
+}
 
-			newOutputs.Outputs = append(newOutputs.Outputs, tx.Outputs...)
-			prefix := append(utxoPrefix, tx.Id...)
-			if err := txn.Set(prefix, newOutputs.Serialize()); err != nil {
-				log.Panic(err)
-			}
+// IsConsistent reports whether the UTXO set is up to date with the chain tip
+func (utxo UTXOSet) IsConsistent() bool {
+	consistent := false
+	err := utxo.Blockchain.Database.View(func(txn StoreTxn) error {
+		best, err := txn.Get(utxoBestHash)
+		if err != nil {
+			return nil
 		}
-		return nil
+		lastHash, err := getLastHash(txn)
+		consistent = err == nil && bytes.Equal(best, lastHash)
+		return err
 	})
 	Handle(err)
+	return consistent
 }
+
 func (utxo UTXOSet) CountTransactions() int {
 	db := utxo.Blockchain.Database
 	count := 0
-	err := db.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		iter := txn.NewIterator(opts)
-		defer iter.Close()
-		for iter.Seek(utxoPrefix); iter.ValidForPrefix(utxoPrefix); iter.Next() {
-			count++
-		}
-		return nil
+	var lastTxId []byte
+	err := db.View(func(txn StoreTxn) error {
+		return txn.Iterate(utxoPrefix, nil, func(key, _ []byte) error {
+			txId := key[len(utxoPrefix) : len(key)-8]
+			if !bytes.Equal(txId, lastTxId) {
+				count++
+				lastTxId = txId
+			}
+			return nil
+		})
 	})
 	Handle(err)
 	return count
 }
 func (utxo *UTXOSet) DeleteByPrefix(prefix []byte) {
 	deleteKeys := func(keyList [][]byte) error {
-		if err := utxo.Blockchain.Database.Update(func(txn *badger.Txn) error {
+		if err := utxo.Blockchain.Database.Update(func(txn StoreTxn) error {
 			for _, key := range keyList {
 				if err := txn.Delete(key); err != nil {
 					return err
@@ -172,30 +389,23 @@ func (utxo *UTXOSet) DeleteByPrefix(prefix []byte) {
 	}
 
 	collectSize := 100000
-	utxo.Blockchain.Database.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		opts.PrefetchValues = false
-		iter := txn.NewIterator(opts)
-		defer iter.Close()
-		keysList := make([][]byte, 0, collectSize)
-		keysCollected := 0
-		for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
-			key := iter.Item().KeyCopy(nil)
+	var keysList [][]byte
+	err := utxo.Blockchain.Database.View(func(txn StoreTxn) error {
+		return txn.Iterate(prefix, nil, func(key, _ []byte) error {
 			keysList = append(keysList, key)
-			keysCollected++
-			if keysCollected == collectSize {
-				if err := deleteKeys(keysList); err != nil {
-					log.Panic(err)
-				}
-				keysList = make([][]byte, 0, collectSize)
-				keysCollected = 0
-			}
+			return nil
+		})
+	})
+	Handle(err)
+
+	for len(keysList) > 0 {
+		n := collectSize
+		if len(keysList) < n {
+			n = len(keysList)
 		}
-		if keysCollected > 0 {
-			if err := deleteKeys(keysList); err != nil {
-				log.Panic(err)
-			}
+		if err := deleteKeys(keysList[:n]); err != nil {
+			log.Panic(err)
 		}
-		return nil
-	})
+		keysList = keysList[n:]
+	}
 }
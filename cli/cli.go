@@ -1,30 +1,91 @@
 package cli
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"runtime"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Harshjha3006/golang-blockchain/blockchain"
 	"github.com/Harshjha3006/golang-blockchain/network"
+	"github.com/Harshjha3006/golang-blockchain/script"
 	"github.com/Harshjha3006/golang-blockchain/wallet"
+	"github.com/Harshjha3006/golang-blockchain/walletdb"
 )
 
 type Cmd struct{}
 
 func (cli *Cmd) printUsage() {
 	fmt.Println("Usage:")
-	fmt.Println("getbalance -address ADDRESS - prints the balance of the specified address")
-	fmt.Println("createblockchain - address ADDRESS - creats a new blockchain and sends genesis reward to specified address")
-	fmt.Println("printchain - prints the entire blockchain")
-	fmt.Println(" send -from FROM -to TO -amount AMOUNT -mine - Send amount of coins. Then -mine flag is set, mine off of this node")
-	fmt.Println("createwallet - Creates a New Wallet")
+	fmt.Println("getbalance -address ADDRESS - prints the balance of the specified address, or of every wallet address")
+	fmt.Println("createblockchain - address ADDRESS -txindex -addrindex -consensus CONSENSUS -signers SIGNERS - creats a new blockchain and sends genesis reward to specified address. -txindex and -addrindex enable the transaction and address indexes. -consensus poa with comma separated -signers addresses selects proof of authority instead of proof of work, the signers take turns signing blocks. -consensus pos selects proof of stake, the -signers producing blocks until stake is registered")
+	fmt.Println("printchain -from FROM -to TO - prints the entire blockchain, or the blocks between heights FROM and TO")
+	fmt.Println("getblock -height HEIGHT - prints the main chain block at the specified height")
+	fmt.Println(" send -from FROM -to TO -amount AMOUNT -mine -strategy STRATEGY -utxos TXID:VOUT,... -locktime LOCKTIME -after-blocks BLOCKS - Send amount of coins from one or more wallet addresses, all of them when -from is empty. Then -mine flag is set, mine off of this node. -locktime (a height, or a unix time from 500000000) and -after-blocks lock the coins sent until then. TO may be a registered @name")
+	fmt.Println("createrawtransaction -inputs TXID:VOUT,... -outputs ADDRESS:AMOUNT,... - Prints the hex of an unsigned transaction")
+	fmt.Println("decoderawtransaction -hex HEX -json - Prints a raw transaction, as JSON with -json")
+	fmt.Println("signrawtransaction -hex HEX - Signs the inputs of a raw transaction spending outputs of your wallet")
+	fmt.Println("sendrawtransaction -hex HEX -node NODE -mine - Submits a signed raw transaction to a node, or mines it on this node")
+	fmt.Println("createpsbt -from FROM -to TO -amount AMOUNT -file FILE -change CHANGE -out OUT - Creates an unsigned transaction spending from watch-only addresses, for signing offline")
+	fmt.Println("signpsbt -in IN -out OUT - Signs the inputs of a partial transaction that this wallet holds keys for, needs no blockchain")
+	fmt.Println("combinepsbt -in IN1,IN2,... -out OUT - Merges the signatures of copies of a partial transaction")
+	fmt.Println("finalizepsbt -in IN -mine - Checks that a partial transaction is fully signed and broadcasts or mines it")
+	fmt.Println("issuetoken -name NAME -supply SUPPLY -mintable -from FROM -to TO -mine - Issues a token with the given supply, paid to TO, or the first -from address. The issuer of a -mintable token mints more with issuetoken on the same name")
+	fmt.Println("sendtoken -token TOKEN -from FROM -to TO -amount AMOUNT -mine - Sends an amount of a token, by name or id")
+	fmt.Println("mintnft -file FILE -hash HASH -uri URI -from FROM -to TO -mine - Mints an NFT for the content of a file, or its sha256 hash, paid to TO, or the first -from address")
+	fmt.Println("transfernft -id ID -from FROM -to TO -mine - Sends an NFT to an address")
+	fmt.Println("listnfts -address ADDRESS - Lists the NFTs owned by an address, or by every wallet address")
+	fmt.Println("registername -name NAME -owner OWNER -from FROM -mine - Registers a name resolving to OWNER, or the first -from address, for a fee. Send to it with -to @NAME")
+	fmt.Println("renewname -name NAME -mine - Extends the registration of a name you own")
+	fmt.Println("transfername -name NAME -to TO -mine - Hands a name you own over to another address")
+	fmt.Println("resolvename -name NAME - Prints the address a name resolves to")
+	fmt.Println("listnames -address ADDRESS - Lists the names owned by an address, or by every wallet address")
+	fmt.Println("stake -address ADDRESS -amount AMOUNT -from FROM -mine - Locks coins as stake of ADDRESS, or of the first -from address, on a proof of stake chain")
+	fmt.Println("unstake -address ADDRESS -mine - Unregisters the stake of an address, its coins are paid back after the unbonding period")
+	fmt.Println("stakers - Lists the stake registry and the share of each staker in producing the next block")
+	fmt.Println("reportdoublesign -block1 HASH -block2 HASH -from FROM -mine - Slashes the staker who signed both blocks at the same height, burning their stake")
+	fmt.Println("listdoublesigns - Lists the signers this node saw sign two blocks at the same height")
+	fmt.Println("notarize -file FILE -from FROM -mine - Anchors the sha256 digest of a file on chain")
+	fmt.Println("verifynotarization -file FILE - Finds the transaction and block that anchored the digest of a file")
+	fmt.Println("initiateswap -from FROM -to PARTICIPANT -amount AMOUNT -locktime LOCKTIME -mine - Starts an atomic swap by paying the participant with a contract for a new secret, refundable after LOCKTIME, 48 hours by default")
+	fmt.Println("participateswap -from FROM -to INITIATOR -amount AMOUNT -secrethash HASH -locktime LOCKTIME -mine - Pays the initiator of an atomic swap with a contract for the secret hash of the initiator's contract, refundable after LOCKTIME, 24 hours by default")
+	fmt.Println("redeemswap -contract CONTRACT -txid TXID -secret SECRET -mine - Claims a contract paying your wallet with its secret")
+	fmt.Println("refundswap -contract CONTRACT -txid TXID -mine - Takes back the coins of a contract you paid once its lock time has passed")
+	fmt.Println("auditswap -contract CONTRACT -txid TXID - Prints the terms of a contract, and the secret it was redeemed with")
+	fmt.Println(" sendmany -file FILE -from FROM -mine -strategy STRATEGY -utxos TXID:VOUT,... - Pays every address of a JSON or CSV address to amount map in a single transaction")
+	fmt.Println("createwallet -mnemonic -words WORDS -passphrase PASS - Creates a New Wallet. -mnemonic creates an HD wallet backed up by a word list")
+	fmt.Println("restorewallet -mnemonic WORDS -passphrase PASS - Restores a wallet from its word list and rescans the chain for its funds")
 	fmt.Println("listaddress - Lists all addresses in your wallet")
+	fmt.Println("dumpprivkey -address ADDRESS - Prints the private key of a wallet address")
+	fmt.Println("getpubkey -address ADDRESS - Prints the public key of a wallet address, to share for a multisig address")
+	fmt.Println("createmultisig -m M -pubkeys PUBKEY1,PUBKEY2,... - Adds an address spendable with M signatures of the given public keys, spent with createpsbt and signpsbt")
+	fmt.Println("addscript -script HEX - Adds the pay-to-script-hash address of a redeem script to your wallet")
+	fmt.Println("decodescript -hex HEX - Prints a script, its type and its pay-to-script-hash address")
+	fmt.Println("importprivkey -key KEY -rescan - Adds a private key to your wallet. -rescan scans the chain for its transactions")
+	fmt.Println("importaddress -address ADDRESS -rescan - Watches an address without its private key")
+	fmt.Println("encryptwallet -passphrase PASS - Encrypts the private keys of your wallet")
+	fmt.Println("getxpub - Prints the extended public key of your wallet account")
+	fmt.Println("deriveaddress -xpub XPUB -index INDEX -change - Derives a watch-only address from an extended public key")
 	fmt.Println("reindexutxo - Reindexes your utxo database")
-	fmt.Println(" startnode -miner ADDRESS - Start a node with ID specified in NODE_ID env. var. -miner enables mining")
+	fmt.Println("reindextx - Rebuilds and enables the transaction index")
+	fmt.Println("reindexaddr - Rebuilds and enables the address index")
+	fmt.Println("listtransactions -count COUNT - Lists the latest transactions of your wallet")
+	fmt.Println("getwalletbalance -minconf MINCONF - Prints the balance of your wallet, per label and in total")
+	fmt.Println("setlabel -address ADDRESS -label LABEL - Sets the label of a wallet address")
+	fmt.Println("history -address ADDRESS - Lists the transactions of an address with confirmations and running balance")
+	fmt.Println(" startnode -miner ADDRESS - Start a node with ID specified in NODE_ID env. var. -miner enables mining, on a proof of stake chain the node forges a block whenever one of its stakers is drawn")
+	fmt.Println("The database backend is chosen with the DB_BACKEND env. var. (badger, bolt or memory) and its location with DB_PATH")
 }
 func (cli *Cmd) validateArgs() {
 	if len(os.Args) < 2 {
@@ -41,6 +102,10 @@ func (cli *Cmd) startNode(nodeId, minerAddress string) {
 		} else {
 			log.Panic("Invalid address")
 		}
+		// blocks sealed with a signature are signed with the wallet keys
+		if wallets, err := wallet.CreateWallets(nodeId); err == nil {
+			unlockWallet(nodeId, wallets)
+		}
 	}
 	network.StartServer(nodeId, minerAddress)
 }
@@ -53,35 +118,122 @@ func (cli *Cmd) reindex(nodeId string) {
 	fmt.Printf("There are %v transactions in the UTXO set \n", count)
 }
 
-func (cli *Cmd) printChain(nodeId string) {
+func (cli *Cmd) reindexTx(nodeId string) {
+	chain := blockchain.ContinueBlockChain(nodeId)
+	defer chain.Database.Close()
+	chain.ReIndexTransactions()
+	fmt.Printf("Transaction index rebuilt up to height %d\n", chain.GetBestHeight())
+}
+
+func (cli *Cmd) reindexAddr(nodeId string) {
+	chain := blockchain.ContinueBlockChain(nodeId)
+	defer chain.Database.Close()
+	chain.ReIndexAddresses()
+	fmt.Printf("Address index rebuilt up to height %d\n", chain.GetBestHeight())
+}
+
+func (cli *Cmd) history(address string, nodeId string) {
+	if !wallet.ValidateAddress(address) {
+		log.Panic("Address is not valid")
+	}
+	chain := blockchain.ContinueBlockChain(nodeId)
+	defer chain.Database.Close()
+	pubKeyHash := wallet.Base58Decode([]byte(address))
+	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
+
+	entries, err := chain.ScanAddress(pubKeyHash)
+	if err != nil {
+		fmt.Println("Error:", err)
+		runtime.Goexit()
+	}
+	balance := printHistory(entries, chain.GetBestHeight())
+	fmt.Printf("The balance of %s is %d\n", address, balance)
+}
+
+func printHistory(entries []blockchain.AddressEntry, bestHeight int) int {
+	balance := 0
+	for i := 0; i < len(entries); {
+		txId, height := entries[i].TxId, entries[i].Height
+		amount := 0
+		for ; i < len(entries) && bytes.Equal(entries[i].TxId, txId); i++ {
+			if entries[i].Spent {
+				amount -= entries[i].Value
+			} else {
+				amount += entries[i].Value
+			}
+		}
+		balance += amount
+
+		kind := "received"
+		if amount < 0 {
+			kind = "sent"
+		}
+		fmt.Printf("%x %-8s %+d height: %d confirmations: %d balance: %d\n", txId, kind, amount, height, bestHeight-height+1, balance)
+	}
+	return balance
+}
+
+func (cli *Cmd) printChain(nodeId string, from, to int) {
 	chain := blockchain.ContinueBlockChain(nodeId)
 	defer chain.Database.Close()
+
+	if from >= 0 || to >= 0 {
+		for _, block := range chain.GetBlocksInRange(from, to) {
+			printBlock(chain, block)
+		}
+		return
+	}
+
 	iter := chain.Iterator()
 
 	for {
 		block := iter.Next()
-
-		fmt.Printf("PrevHash: %x\nHash: %x\n", block.PrevHash, block.Hash)
-		pow := blockchain.InitPow(block)
-		fmt.Printf("POW : %s", strconv.FormatBool(pow.Validate()))
-		for _, tx := range block.Transactions {
-			fmt.Println(tx)
-		}
-		fmt.Println()
+		printBlock(chain, block)
 		if len(block.PrevHash) == 0 {
 			break
 		}
 	}
 }
-func (cli *Cmd) createBlockChain(address string, nodeId string) {
+
+func (cli *Cmd) getBlock(nodeId string, height int) {
+	chain := blockchain.ContinueBlockChain(nodeId)
+	defer chain.Database.Close()
+
+	block, err := chain.GetBlockByHeight(height)
+	if err != nil {
+		log.Panic(err)
+	}
+	printBlock(chain, &block)
+}
+
+func printBlock(chain *blockchain.BlockChain, block *blockchain.Block) {
+	fmt.Printf("Height: %d\nPrevHash: %x\nHash: %x\n", block.Height, block.PrevHash, block.Hash)
+	if block.Signer != nil {
+		fmt.Printf("Signer: %s\n", wallet.AddressFromPubKeyHash(wallet.PubkeyHash(block.Signer)))
+	}
+	err := chain.Engine.VerifySeal(block)
+	fmt.Printf("%s : %s\n", strings.ToUpper(chain.Engine.Name()), strconv.FormatBool(err == nil))
+	for _, tx := range block.Transactions {
+		fmt.Println(tx)
+	}
+	fmt.Println()
+}
+func (cli *Cmd) createBlockChain(address string, nodeId string, consensus string, signers string, txIndex, addrIndex bool) {
 	if !wallet.ValidateAddress(address) {
 		log.Panic("Address is not valid")
 	}
-	chain := blockchain.InitBlockChain(address, nodeId)
+	params := blockchain.ChainParams{Consensus: consensus}
+	if signers != "" {
+		for _, signer := range strings.Split(signers, ",") {
+			if !wallet.ValidateAddress(signer) {
+				fmt.Println("Error: signer address", signer, "is not valid")
+				runtime.Goexit()
+			}
+			params.Signers = append(params.Signers, addressPubKeyHash(signer))
+		}
+	}
+	chain := blockchain.InitBlockChain(address, nodeId, params, txIndex, addrIndex)
 	defer chain.Database.Close()
-
-	UtxoSet := blockchain.UTXOSet{Blockchain: chain}
-	UtxoSet.ReIndex()
 	fmt.Println("Finished")
 }
 
@@ -102,162 +254,2267 @@ func (cli *Cmd) getBalance(address string, nodeId string) {
 		balance += out.Value
 	}
 	fmt.Printf("The balance of %s is %d\n", address, balance)
+	printTokenBalances(chain, blockchain.TokenAmounts(utxos), "  ")
 }
 
-func (cli *Cmd) send(from string, to string, amount int, nodeId string, mine bool) {
-	if !wallet.ValidateAddress(from) {
-		log.Panic("Address is not valid")
-	}
-	if !wallet.ValidateAddress(to) {
-		log.Panic("Address is not valid")
-	}
+func (cli *Cmd) getWalletBalances(nodeId string) {
 	chain := blockchain.ContinueBlockChain(nodeId)
 	defer chain.Database.Close()
+	wallets, _ := wallet.CreateWallets(nodeId)
 	utxoSet := blockchain.UTXOSet{Blockchain: chain}
-	wallets, err := wallet.CreateWallets(nodeId)
-	if err != nil {
-		log.Panic(err)
+
+	total := 0
+	totalTokens := make(map[string]int)
+	printBalance := func(address string, note string) {
+		balance := 0
+		utxos := utxoSet.FindUTXO(addressPubKeyHash(address))
+		for _, out := range utxos {
+			balance += out.Value
+		}
+		total += balance
+		fmt.Printf("%s %d%s\n", address, balance, note)
+		tokens := blockchain.TokenAmounts(utxos)
+		for tokenId, amount := range tokens {
+			totalTokens[tokenId] += amount
+		}
+		printTokenBalances(chain, tokens, "  ")
+	}
+	for _, address := range wallets.GetAllAddresses() {
+		printBalance(address, "")
+	}
+	for _, address := range wallets.GetWatchOnlyAddresses() {
+		printBalance(address, " (watch-only)")
 	}
-	wallet := wallets.GetWallet(from)
-	txn := blockchain.NewTransaction(&wallet, to, amount, utxoSet)
+	for _, address := range wallets.GetScriptAddresses() {
+		printBalance(address, fmt.Sprintf(" (%s)", describeScript(wallets.Scripts[address])))
+	}
+	fmt.Printf("Total balance is %d\n", total)
+	printTokenBalances(chain, totalTokens, "")
+}
 
-	if mine {
-		cbtx := blockchain.CoinbaseTx(from, "")
-		txns := []*blockchain.Transaction{cbtx, txn}
-		block := chain.MineBlock(txns)
-		utxoSet.Update(block)
+// printTokenBalances prints token amounts by token name
+func printTokenBalances(chain *blockchain.BlockChain, amounts map[string]int, indent string) {
+	var lines []string
+	for tokenId, amount := range amounts {
+		token, err := chain.GetToken(hex.EncodeToString([]byte(tokenId)))
+		if err != nil {
+			token.Name = "unknown"
+		} else if token.IsNFT() {
+			token.Name = "NFT"
+		}
+		lines = append(lines, fmt.Sprintf("%s%s %d (token %x)", indent, token.Name, amount, tokenId))
+	}
+	sort.Strings(lines)
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+}
 
-	} else {
-		network.SendTransaction(network.KnownNodes[0], txn)
-		fmt.Println("Transaction sent")
+func addressPubKeyHash(address string) []byte {
+	pubKeyHash := wallet.Base58Decode([]byte(address))
+	return pubKeyHash[1 : len(pubKeyHash)-4]
+}
+
+func (cli *Cmd) dumpPrivKey(nodeId string, address string) {
+	wallets, _ := wallet.CreateWallets(nodeId)
+	key, err := wallets.DumpPrivKey(address)
+	if err != nil {
+		fmt.Println("Error:", err)
+		runtime.Goexit()
 	}
-	fmt.Println("success")
+	fmt.Println(key)
 }
 
-func (cli *Cmd) createWallet(nodeId string) {
+func (cli *Cmd) getPubKey(nodeId string, address string) {
+	wallets, _ := wallet.CreateWallets(nodeId)
+	w, ok := wallets.Wallets[address]
+	if !ok {
+		fmt.Printf("Error: address %s is not in your wallet\n", address)
+		runtime.Goexit()
+	}
+	fmt.Printf("%x\n", w.PublicKey)
+}
+
+func (cli *Cmd) createMultisig(nodeId string, m int, pubKeys string) {
+	var keys [][]byte
+	for _, encoded := range strings.Split(pubKeys, ",") {
+		key, err := hex.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			fmt.Println("Error: invalid public key", encoded)
+			runtime.Goexit()
+		}
+		keys = append(keys, key)
+	}
+	policy, err := wallet.NewMultisigPolicy(m, keys)
+	if err != nil {
+		fmt.Println("Error:", err)
+		runtime.Goexit()
+	}
+
 	wallets, _ := wallet.CreateWallets(nodeId)
-	address := wallets.AddWallet()
+	address, err := wallets.AddScript(policy.Script())
+	if err != nil {
+		fmt.Println("Error:", err)
+		runtime.Goexit()
+	}
 	wallets.SaveFile(nodeId)
-	fmt.Printf("The new Address is %s\n", address)
+	fmt.Printf("Multisig address %s (%s)\n", address, policy)
+	fmt.Printf("Redeem script %x\n", policy.Script())
 }
-func (cli *Cmd) listAddress(nodeId string) {
+
+func (cli *Cmd) addScript(nodeId string, encoded string) {
+	redeemScript, err := hex.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		fmt.Println("Error: invalid script", err)
+		runtime.Goexit()
+	}
 	wallets, _ := wallet.CreateWallets(nodeId)
-	addresses := wallets.GetAllAddresses()
+	address, err := wallets.AddScript(redeemScript)
+	if err != nil {
+		fmt.Println("Error:", err)
+		runtime.Goexit()
+	}
+	wallets.SaveFile(nodeId)
+	fmt.Printf("Script address %s (%s)\n", address, describeScript(redeemScript))
+}
 
-	for _, address := range addresses {
-		fmt.Println(address)
+func (cli *Cmd) decodeScript(encoded string) {
+	data, err := hex.DecodeString(strings.TrimSpace(encoded))
+	if err == nil {
+		err = script.Validate(data)
+	}
+	if err != nil {
+		fmt.Println("Error: invalid script", err)
+		runtime.Goexit()
+	}
+	class, hash := script.Classify(data)
+	fmt.Println("Asm:", script.Disasm(data))
+	fmt.Println("Type:", class)
+	switch class {
+	case script.PubKeyHash:
+		fmt.Println("Address:", string(wallet.AddressFromPubKeyHash(hash)))
+	case script.ScriptHash:
+		fmt.Println("Address:", string(wallet.ScriptAddressFromHash(hash)))
 	}
+	if class != script.ScriptHash {
+		fmt.Println("Script address:", string(wallet.ScriptAddress(data)))
+	}
+}
 
+// describeScript names the kind of a redeem script
+func describeScript(redeemScript []byte) string {
+	if policy, err := wallet.ParseMultisigPolicy(redeemScript); err == nil {
+		return "multisig " + policy.String()
+	}
+	if _, err := script.ExtractAtomicSwap(redeemScript); err == nil {
+		return "atomic swap contract"
+	}
+	return "script"
 }
-func (cli *Cmd) Run() {
-	cli.validateArgs()
 
-	nodeId := os.Getenv("NODE_ID")
-	if nodeId == "" {
-		fmt.Printf("nodeId env variable not set")
+func (cli *Cmd) importPrivKey(nodeId string, key string, rescan bool) {
+	wallets, _ := wallet.CreateWallets(nodeId)
+	address, err := wallets.ImportPrivKey(key)
+	if err != nil {
+		fmt.Println("Error:", err)
 		runtime.Goexit()
 	}
+	wallets.SaveFile(nodeId)
+	fmt.Printf("Imported %s\n", address)
+	if rescan {
+		cli.rescan(nodeId, address)
+	}
+}
 
-	getBalanceCmd := flag.NewFlagSet("getbalance", flag.ExitOnError)
-	createBlockchainCmd := flag.NewFlagSet("createblockchain", flag.ExitOnError)
-	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
-	printChainCmd := flag.NewFlagSet("printchain", flag.ExitOnError)
-	createWalletCmd := flag.NewFlagSet("createwallet", flag.ExitOnError)
-	listAddressCmd := flag.NewFlagSet("listaddress", flag.ExitOnError)
-	reindexUtxo := flag.NewFlagSet("reindexutxo", flag.ExitOnError)
-	startNodeCmd := flag.NewFlagSet("startnode", flag.ExitOnError)
-
-	getBalanceAddress := getBalanceCmd.String("address", "", "The address to get balance for")
-	createBlockchainAddress := createBlockchainCmd.String("address", "", "The address to send genesis block reward to")
-	sendFrom := sendCmd.String("from", "", "Source wallet address")
-	sendTo := sendCmd.String("to", "", "Destination wallet address")
-	sendAmount := sendCmd.Int("amount", 0, "Amount to send")
-	sendMine := sendCmd.Bool("mine", false, "Mine immediately on the same node")
-	startNodeMiner := startNodeCmd.String("miner", "", "Enable miner and you can mine blocks and send reward to Address")
+func (cli *Cmd) importAddress(nodeId string, address string, rescan bool) {
+	wallets, _ := wallet.CreateWallets(nodeId)
+	if err := wallets.ImportAddress(address); err != nil {
+		fmt.Println("Error:", err)
+		runtime.Goexit()
+	}
+	wallets.SaveFile(nodeId)
+	fmt.Printf("Watching %s\n", address)
+	if rescan {
+		cli.rescan(nodeId, address)
+	}
+}
 
-	switch os.Args[1] {
-	case "startnode":
-		err := startNodeCmd.Parse(os.Args[2:])
-		if err != nil {
-			log.Panic(err)
-		}
-	case "createwallet":
-		err := createWalletCmd.Parse(os.Args[2:])
-		if err != nil {
-			log.Panic(err)
-		}
-	case "listaddress":
-		err := listAddressCmd.Parse(os.Args[2:])
-		if err != nil {
-			log.Panic(err)
-		}
-	case "getbalance":
-		err := getBalanceCmd.Parse(os.Args[2:])
-		if err != nil {
-			log.Panic(err)
-		}
-	case "createblockchain":
-		err := createBlockchainCmd.Parse(os.Args[2:])
-		if err != nil {
-			log.Panic(err)
-		}
-	case "printchain":
-		err := printChainCmd.Parse(os.Args[2:])
-		if err != nil {
-			log.Panic(err)
-		}
-	case "send":
-		err := sendCmd.Parse(os.Args[2:])
-		if err != nil {
-			log.Panic(err)
-		}
-	case "reindexutxo":
-		err := reindexUtxo.Parse(os.Args[2:])
-		if err != nil {
-			log.Panic(err)
-		}
-	default:
-		cli.printUsage()
+func (cli *Cmd) rescan(nodeId string, address string) {
+	chain := blockchain.ContinueBlockChain(nodeId)
+	defer chain.Database.Close()
+	entries, err := chain.ScanAddress(addressPubKeyHash(address))
+	if err != nil {
+		fmt.Println("Error:", err)
 		runtime.Goexit()
 	}
+	balance := printHistory(entries, chain.GetBestHeight())
+	fmt.Printf("Rescan found %d entries, the balance of %s is %d\n", len(entries), address, balance)
 
-	if reindexUtxo.Parsed() {
-		cli.reindex(nodeId)
+	wallets, _ := wallet.CreateWallets(nodeId)
+	openWalletDB(nodeId, wallets, chain, true)
+}
+
+// openWalletDB brings the wallet database up to date with the chain,
+// rebuilding it from genesis when rescan is set
+func openWalletDB(nodeId string, wallets *wallet.Wallets, chain *blockchain.BlockChain, rescan bool) *walletdb.WalletDB {
+	db, err := walletdb.Load(nodeId)
+	if err != nil {
+		log.Panic(err)
 	}
-	if getBalanceCmd.Parsed() {
-		if *getBalanceAddress == "" {
-			getBalanceCmd.Usage()
-			runtime.Goexit()
+	db.AddAddresses(wallets)
+	if rescan {
+		err = db.Rescan(chain)
+	} else {
+		err = db.Sync(chain)
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+	if err := db.Save(); err != nil {
+		log.Panic(err)
+	}
+	return db
+}
+
+func (cli *Cmd) listTransactions(nodeId string, count int) {
+	chain := blockchain.ContinueBlockChain(nodeId)
+	defer chain.Database.Close()
+	wallets, _ := wallet.CreateWallets(nodeId)
+	db := openWalletDB(nodeId, wallets, chain, false)
+
+	records := db.Transactions()
+	if count > 0 && len(records) > count {
+		records = records[len(records)-count:]
+	}
+	for _, record := range records {
+		category := "send"
+		switch {
+		case record.Coinbase:
+			category = "generate"
+		case record.Net() > 0:
+			category = "receive"
+		case record.Net() == 0:
+			category = "move"
 		}
-		cli.getBalance(*getBalanceAddress, nodeId)
+		fmt.Printf("%x %-8s %+d height: %d confirmations: %d time: %s\n", record.TxId, category, record.Net(),
+			record.Height, db.Confirmations(record.Height), time.Unix(record.Timestamp, 0).Format(time.RFC3339))
 	}
+}
 
-	if createBlockchainCmd.Parsed() {
-		if *createBlockchainAddress == "" {
-			createBlockchainCmd.Usage()
-			runtime.Goexit()
+func (cli *Cmd) getWalletBalance(nodeId string, minConf int) {
+	chain := blockchain.ContinueBlockChain(nodeId)
+	defer chain.Database.Close()
+	wallets, _ := wallet.CreateWallets(nodeId)
+	db := openWalletDB(nodeId, wallets, chain, false)
+
+	balances := db.LabelBalances(minConf)
+	var labels []string
+	for label := range balances {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		name := label
+		if name == "" {
+			name = "(no label)"
 		}
-		cli.createBlockChain(*createBlockchainAddress, nodeId)
+		fmt.Printf("%s: %d\n", name, balances[label])
 	}
+	fmt.Printf("Wallet balance is %d\n", db.Balance(minConf))
+}
 
-	if printChainCmd.Parsed() {
-		cli.printChain(nodeId)
+func (cli *Cmd) setLabel(nodeId string, address string, label string) {
+	wallets, _ := wallet.CreateWallets(nodeId)
+	db, err := walletdb.Load(nodeId)
+	if err != nil {
+		log.Panic(err)
+	}
+	db.AddAddresses(wallets)
+	if err := db.SetLabel(address, label); err != nil {
+		fmt.Println("Error:", err)
+		runtime.Goexit()
+	}
+	if err := db.Save(); err != nil {
+		log.Panic(err)
 	}
+	fmt.Printf("Label of %s set to %q\n", address, label)
+}
 
-	if sendCmd.Parsed() {
-		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 {
-			sendCmd.Usage()
-			runtime.Goexit()
-		}
+func (cli *Cmd) send(from string, to string, amount int, nodeId string, mine bool, strategy string, utxos string, lock blockchain.Timelock) {
+	cli.pay(nodeId, from, []recipient{{to, amount}}, nil, lock, mine, strategy, utxos)
+}
 
-		cli.send(*sendFrom, *sendTo, *sendAmount, nodeId, *sendMine)
+func (cli *Cmd) sendMany(from string, file string, nodeId string, mine bool, strategy string, utxos string) {
+	recipients, err := readRecipients(file)
+	if err != nil {
+		fmt.Println("Error:", err)
+		runtime.Goexit()
 	}
-	if createWalletCmd.Parsed() {
-		cli.createWallet(nodeId)
+	cli.pay(nodeId, from, recipients, nil, blockchain.Timelock{}, mine, strategy, utxos)
+}
+
+type recipient struct {
+	address string
+	amount  int
+}
+
+// readRecipients reads the address -> amount map of a sendmany file, either
+// a JSON object or CSV lines of address,amount
+func readRecipients(file string) ([]recipient, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
 	}
-	if listAddressCmd.Parsed() {
+
+	var recipients []recipient
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		amounts := make(map[string]int)
+		if err := json.Unmarshal(trimmed, &amounts); err != nil {
+			return nil, err
+		}
+		for address, amount := range amounts {
+			recipients = append(recipients, recipient{address, amount})
+		}
+		sort.Slice(recipients, func(i, j int) bool {
+			return recipients[i].address < recipients[j].address
+		})
+		return recipients, nil
+	}
+
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	for i, record := range records {
+		if len(record) != 2 {
+			return nil, fmt.Errorf("line %d: expected address,amount", i+1)
+		}
+		amount, err := strconv.Atoi(strings.TrimSpace(record[1]))
+		if err != nil {
+			if i == 0 {
+				continue // header
+			}
+			return nil, fmt.Errorf("line %d: invalid amount %q", i+1, record[1])
+		}
+		recipients = append(recipients, recipient{strings.TrimSpace(record[0]), amount})
+	}
+	return recipients, nil
+}
+
+// pay builds a single transaction paying every recipient, in outputs locked
+// with lock when it is set, and carrying data when it is given. Recipients
+// may be registered names prefixed with @.
+func (cli *Cmd) pay(nodeId string, from string, recipients []recipient, data []byte, lock blockchain.Timelock, mine bool, strategy string, utxos string) *blockchain.Transaction {
+	return cli.buildAndSend(nodeId, from, mine, strategy, utxos, func(chain *blockchain.BlockChain, builder *blockchain.TxBuilder) error {
+		for _, r := range recipients {
+			address, err := resolveAddress(chain, r.address)
+			if err != nil {
+				return err
+			}
+			if err := builder.AddLockedRecipient(address, r.amount, lock); err != nil {
+				return err
+			}
+		}
+		if data != nil {
+			return builder.AddData(data)
+		}
+		return nil
+	})
+}
+
+// buildAndSend builds a transaction set up by setup, funded from the from
+// addresses, or from the whole wallet when from is empty, and broadcasts it
+// or mines it on this node
+func (cli *Cmd) buildAndSend(nodeId string, from string, mine bool, strategy string, utxos string, setup func(chain *blockchain.BlockChain, builder *blockchain.TxBuilder) error) *blockchain.Transaction {
+	chain := blockchain.ContinueBlockChain(nodeId)
+	defer chain.Database.Close()
+	utxoSet := blockchain.UTXOSet{Blockchain: chain}
+	wallets, err := wallet.CreateWallets(nodeId)
+	if err != nil {
+		log.Panic(err)
+	}
+	unlockWallet(nodeId, wallets)
+
+	builder := blockchain.NewTxBuilder(utxoSet)
+	builder.Strategy = strategy
+	builder.ChangeAddress = wallets.AddChangeWallet
+
+	addresses := wallets.GetAllAddresses()
+	if from != "" {
+		addresses = strings.Split(from, ",")
+	}
+	for _, address := range addresses {
+		w, ok := wallets.Wallets[address]
+		if !ok {
+			fmt.Println("Error:", address, "is not in your wallet")
+			runtime.Goexit()
+		}
+		if w.CanSign() {
+			builder.AddSigner(w)
+		}
+	}
+	if err := setup(chain, builder); err != nil {
+		fmt.Println("Error:", err)
+		runtime.Goexit()
+	}
+	if utxos != "" {
+		if builder.Coins, err = parseOutpoints(utxoSet, utxos); err != nil {
+			fmt.Println("Error:", err)
+			runtime.Goexit()
+		}
+	}
+
+	txn, err := builder.Build()
+	if err != nil {
+		fmt.Println("Error:", err)
+		runtime.Goexit()
+	}
+	if err := chain.CheckLocks(txn); err != nil {
+		fmt.Println("Error:", err)
+		runtime.Goexit()
+	}
+	if err := chain.CheckIssuance(txn); err != nil {
+		fmt.Println("Error:", err)
+		runtime.Goexit()
+	}
+	if err := chain.CheckNameOperation(txn); err != nil {
+		fmt.Println("Error:", err)
+		runtime.Goexit()
+	}
+	if err := chain.CheckStakeOperation(txn); err != nil {
+		fmt.Println("Error:", err)
+		runtime.Goexit()
+	}
+	if err := chain.VerifyTransaction(txn); err != nil {
+		fmt.Println("Error: transaction does not verify against the chain:", err)
+		runtime.Goexit()
+	}
+	var reward string
+	if mine {
+		reward = rewardAddress(wallets, from)
+	}
+	wallets.SaveFile(nodeId)
+	fmt.Printf("Paying %d to %d recipients from %d outputs\n", builder.Amount(), len(builder.Outputs), len(txn.Inputs))
+
+	if mine {
+		cbtx := blockchain.CoinbaseTx(reward, "")
+		txns := []*blockchain.Transaction{cbtx, txn}
+		if _, err := chain.MineBlock(txns); err != nil {
+			fmt.Println("Error:", err)
+			runtime.Goexit()
+		}
+
+	} else {
+		network.SendTransaction(network.KnownNodes[0], txn)
+		fmt.Println("Transaction sent")
+	}
+	fmt.Println("success")
+	return txn
+}
+
+// rewardAddress is the address a block mined on this node pays its reward
+// to: the first from address, or a new address of the wallet
+func rewardAddress(wallets *wallet.Wallets, from string) string {
+	if from != "" {
+		return strings.Split(from, ",")[0]
+	}
+	address, err := wallets.AddWallet()
+	if err != nil {
+		fmt.Println("Error:", err)
+		runtime.Goexit()
+	}
+	return address
+}
+
+// issueToken issues a new token paid to an address, or mints more of a
+// mintable token, funded by its issuer
+func (cli *Cmd) issueToken(nodeId string, from string, name string, supply int, mintable bool, to string, mine bool) {
+	issuance := blockchain.TokenIssuance{Name: name, Supply: supply, Mintable: mintable}
+	chain := blockchain.ContinueBlockChain(nodeId)
+	if token, err := chain.GetToken(name); err == nil {
+		issuance.TokenId = token.Id
+		from = string(wallet.AddressFromPubKeyHash(token.Issuer))
+	}
+	chain.Database.Close()
+	if to == "" {
+		to = defaultIssueAddress(nodeId, from)
+	}
+
+	txn := cli.buildAndSend(nodeId, from, mine, blockchain.BranchAndBound, "", func(_ *blockchain.BlockChain, builder *blockchain.TxBuilder) error {
+		return builder.IssueToken(issuance, to)
+	})
+	if issuance.TokenId != nil {
+		fmt.Printf("Minted %d of token %s to %s\n", supply, name, to)
+		return
+	}
+	fmt.Printf("Issued %d of token %s %x to %s\n", supply, name, txn.IssuedTokenId(), to)
+}
+
+// sendToken pays tokens to an address, funded from the from addresses, or
+// from the whole wallet when from is empty
+func (cli *Cmd) sendToken(nodeId string, from string, tokenName string, to string, amount int, mine bool) {
+	txn := cli.buildAndSend(nodeId, from, mine, blockchain.BranchAndBound, "", func(chain *blockchain.BlockChain, builder *blockchain.TxBuilder) error {
+		token, err := chain.GetToken(tokenName)
+		if err != nil {
+			return err
+		}
+		return builder.AddTokenRecipient(to, token.Id, amount)
+	})
+	fmt.Printf("Sent %d of token %s to %s in transaction %x\n", amount, tokenName, to, txn.Id)
+}
+
+// defaultIssueAddress is the address issued tokens are paid to when none is
+// given: the first from address, or the first wallet address
+func defaultIssueAddress(nodeId string, from string) string {
+	if from != "" {
+		return strings.Split(from, ",")[0]
+	}
+	wallets, _ := wallet.CreateWallets(nodeId)
+	if addresses := wallets.GetAllAddresses(); len(addresses) > 0 {
+		return addresses[0]
+	}
+	return ""
+}
+
+// mintNFT mints an NFT for a content hash paid to an address, the owner of
+// the first coin spent becomes its issuer
+func (cli *Cmd) mintNFT(nodeId string, from string, contentHash []byte, uri string, to string, mine bool) {
+	if to == "" {
+		to = defaultIssueAddress(nodeId, from)
+	}
+	metadata := &blockchain.NFTMetadata{ContentHash: contentHash, URI: uri}
+	issuance := blockchain.TokenIssuance{Supply: 1, Metadata: metadata}
+	txn := cli.buildAndSend(nodeId, from, mine, blockchain.BranchAndBound, "", func(_ *blockchain.BlockChain, builder *blockchain.TxBuilder) error {
+		return builder.IssueToken(issuance, to)
+	})
+	fmt.Printf("Minted NFT %x of content %x to %s\n", txn.IssuedTokenId(), contentHash, to)
+}
+
+// transferNFT sends an NFT to an address
+func (cli *Cmd) transferNFT(nodeId string, from string, id string, to string, mine bool) {
+	txn := cli.buildAndSend(nodeId, from, mine, blockchain.BranchAndBound, "", func(chain *blockchain.BlockChain, builder *blockchain.TxBuilder) error {
+		token, err := chain.GetNFT(id)
+		if err != nil {
+			return err
+		}
+		return builder.AddTokenRecipient(to, token.Id, 1)
+	})
+	fmt.Printf("Sent NFT %s to %s in transaction %x\n", id, to, txn.Id)
+}
+
+// listNFTs prints the NFTs owned by an address, or by every wallet address
+func (cli *Cmd) listNFTs(nodeId string, address string) {
+	addresses := []string{address}
+	if address == "" {
+		wallets, _ := wallet.CreateWallets(nodeId)
+		addresses = wallets.GetAllAddresses()
+	} else if !wallet.ValidateAddress(address) {
+		fmt.Println("Error: address is not valid")
+		runtime.Goexit()
+	}
+	chain := blockchain.ContinueBlockChain(nodeId)
+	defer chain.Database.Close()
+	utxoSet := blockchain.UTXOSet{Blockchain: chain}
+
+	for _, address := range addresses {
+		nfts := utxoSet.FindNFTs(addressPubKeyHash(address))
+		fmt.Printf("%s owns %d NFTs\n", address, len(nfts))
+		for _, nft := range nfts {
+			fmt.Printf("  %x content: %x uri: %s issuer: %s\n", nft.Id, nft.Metadata.ContentHash, nft.Metadata.URI,
+				wallet.AddressFromPubKeyHash(nft.Issuer))
+		}
+	}
+}
+
+// resolveAddress resolves an @name to the address it is registered to,
+// other addresses are returned as they are
+func resolveAddress(chain *blockchain.BlockChain, address string) (string, error) {
+	if !strings.HasPrefix(address, "@") {
+		return address, nil
+	}
+	record, err := chain.ResolveName(address[1:])
+	if err != nil {
+		return "", err
+	}
+	return record.Address(), nil
+}
+
+// registerName claims a name resolving to the owner address, burning the
+// name fee
+func (cli *Cmd) registerName(nodeId string, from string, name string, owner string, mine bool) {
+	if owner == "" {
+		owner = defaultIssueAddress(nodeId, from)
+	}
+	if !wallet.ValidateAddress(owner) {
+		fmt.Println("Error: owner address is not valid")
+		runtime.Goexit()
+	}
+	op := blockchain.NameOperation{Op: blockchain.NameRegister, Name: name, Owner: addressPubKeyHash(owner)}
+	txn := cli.buildAndSend(nodeId, from, mine, blockchain.BranchAndBound, "", func(_ *blockchain.BlockChain, builder *blockchain.TxBuilder) error {
+		builder.SetNameOperation(op)
+		return nil
+	})
+	fmt.Printf("Registered @%s to %s for %d blocks in transaction %x\n", name, owner, blockchain.NameLifetime, txn.Id)
+}
+
+// renewName extends the registration of a name, funded by its owner
+func (cli *Cmd) renewName(nodeId string, name string, mine bool) {
+	record := cli.nameRecord(nodeId, name)
+	op := blockchain.NameOperation{Op: blockchain.NameRenew, Name: name, Owner: record.Owner}
+	txn := cli.buildAndSend(nodeId, record.Address(), mine, blockchain.BranchAndBound, "", func(_ *blockchain.BlockChain, builder *blockchain.TxBuilder) error {
+		builder.SetNameOperation(op)
+		return nil
+	})
+	fmt.Printf("Renewed @%s until height %d in transaction %x\n", name, record.Expires+blockchain.NameLifetime, txn.Id)
+}
+
+// transferName hands a name over to a new owner, funded by its owner
+func (cli *Cmd) transferName(nodeId string, name string, to string, mine bool) {
+	record := cli.nameRecord(nodeId, name)
+	txn := cli.buildAndSend(nodeId, record.Address(), mine, blockchain.BranchAndBound, "", func(chain *blockchain.BlockChain, builder *blockchain.TxBuilder) error {
+		address, err := resolveAddress(chain, to)
+		if err != nil {
+			return err
+		}
+		if !wallet.ValidateAddress(address) {
+			return fmt.Errorf("address %s is not valid", address)
+		}
+		builder.SetNameOperation(blockchain.NameOperation{Op: blockchain.NameTransfer, Name: name, Owner: addressPubKeyHash(address)})
+		return nil
+	})
+	fmt.Printf("Transferred @%s to %s in transaction %x\n", name, to, txn.Id)
+}
+
+func (cli *Cmd) nameRecord(nodeId string, name string) blockchain.NameRecord {
+	chain := blockchain.ContinueBlockChain(nodeId)
+	defer chain.Database.Close()
+	record, err := chain.ResolveName(name)
+	if err != nil {
+		fmt.Println("Error:", err)
+		runtime.Goexit()
+	}
+	return record
+}
+
+// resolveName prints the address a name resolves to
+func (cli *Cmd) resolveName(nodeId string, name string) {
+	record := cli.nameRecord(nodeId, name)
+	fmt.Printf("@%s resolves to %s, registered at height %d, expires at height %d\n", record.Name, record.Address(), record.Registered, record.Expires)
+}
+
+// listNames prints the names owned by an address, or by every wallet address
+func (cli *Cmd) listNames(nodeId string, address string) {
+	addresses := []string{address}
+	if address == "" {
+		wallets, _ := wallet.CreateWallets(nodeId)
+		addresses = wallets.GetAllAddresses()
+	} else if !wallet.ValidateAddress(address) {
+		fmt.Println("Error: address is not valid")
+		runtime.Goexit()
+	}
+	chain := blockchain.ContinueBlockChain(nodeId)
+	defer chain.Database.Close()
+
+	for _, address := range addresses {
+		for _, record := range chain.GetNames(addressPubKeyHash(address)) {
+			fmt.Printf("@%s %s expires at height %d\n", record.Name, address, record.Expires)
+		}
+	}
+}
+
+// stake registers coins paid to an address as its stake
+func (cli *Cmd) stake(nodeId string, from string, address string, amount int, mine bool) {
+	if address == "" {
+		address = defaultIssueAddress(nodeId, from)
+	}
+	txn := cli.buildAndSend(nodeId, from, mine, blockchain.BranchAndBound, "", func(_ *blockchain.BlockChain, builder *blockchain.TxBuilder) error {
+		return builder.AddStake(address, amount)
+	})
+	fmt.Printf("Staked %d for %s in transaction %x\n", amount, address, txn.Id)
+}
+
+// unstake unregisters the stake of an address, paying it back locked for
+// the unbonding period
+func (cli *Cmd) unstake(nodeId string, address string, mine bool) {
+	if !wallet.ValidateAddress(address) {
+		fmt.Println("Error: address is not valid")
+		runtime.Goexit()
+	}
+	txn := cli.buildAndSend(nodeId, address, mine, blockchain.BranchAndBound, "", func(chain *blockchain.BlockChain, builder *blockchain.TxBuilder) error {
+		utxoSet := blockchain.UTXOSet{Blockchain: chain}
+		var stakes []blockchain.UnspentOutput
+		for _, stake := range chain.GetStakes() {
+			if bytes.Equal(stake.Staker, addressPubKeyHash(address)) && stake.Unbonding == 0 && stake.Slashed == 0 {
+				out, err := utxoSet.GetUnspentOutput(stake.TxId, stake.Index)
+				if err != nil {
+					return err
+				}
+				stakes = append(stakes, out)
+			}
+		}
+		return builder.Unstake(stakes)
+	})
+	fmt.Printf("Unstaked %d of %s in transaction %x, spendable after %d blocks\n", txn.Outputs[txn.Stake.Output].Value,
+		address, txn.Id, blockchain.StakeUnbondingPeriod)
+}
+
+// stakers prints the stake registry
+func (cli *Cmd) stakers(nodeId string) {
+	chain := blockchain.ContinueBlockChain(nodeId)
+	defer chain.Database.Close()
+	height := chain.GetBestHeight() + 1
+
+	total := 0
+	active := make(map[string]int)
+	for _, stake := range chain.GetStakes() {
+		address := string(wallet.AddressFromPubKeyHash(stake.Staker))
+		switch {
+		case stake.Slashed != 0:
+			fmt.Printf("%s %d slashed at height %d\n", address, stake.Amount, stake.Slashed)
+		case stake.Unbonding != 0:
+			fmt.Printf("%s %d unregistered at height %d\n", address, stake.Amount, stake.Unbonding)
+		case stake.ActiveAt(height):
+			active[address] += stake.Amount
+			total += stake.Amount
+		default:
+			fmt.Printf("%s %d active from height %d\n", address, stake.Amount, stake.Registered+1)
+		}
+	}
+	var addresses []string
+	for address := range active {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	for _, address := range addresses {
+		fmt.Printf("%s %d staking, %.1f%% of the stake\n", address, active[address], 100*float64(active[address])/float64(total))
+	}
+	fmt.Printf("Total stake is %d\n", total)
+}
+
+func (cli *Cmd) listDoubleSigns(nodeId string) {
+	chain := blockchain.ContinueBlockChain(nodeId)
+	defer chain.Database.Close()
+	for _, double := range chain.DoubleSigns() {
+		fmt.Printf("%s signed blocks %x and %x at height %d\n", wallet.AddressFromPubKeyHash(double.Signer),
+			double.First, double.Second, double.Height)
+	}
+}
+
+// reportDoubleSign slashes the staker who signed two stored blocks at the
+// same height
+func (cli *Cmd) reportDoubleSign(nodeId string, from string, first []byte, second []byte, mine bool) {
+	txn := cli.buildAndSend(nodeId, from, mine, blockchain.BranchAndBound, "", func(chain *blockchain.BlockChain, builder *blockchain.TxBuilder) error {
+		evidence, err := chain.DoubleSignEvidence(first, second)
+		if err != nil {
+			return err
+		}
+		return builder.Slash(evidence)
+	})
+	fmt.Printf("Reported double signing in transaction %x\n", txn.Id)
+}
+
+// notarize anchors the sha256 digest of a file on chain, in a data output
+func (cli *Cmd) notarize(nodeId string, file string, from string, mine bool) {
+	digest := fileDigest(file)
+	txn := cli.pay(nodeId, from, nil, digest, blockchain.Timelock{}, mine, blockchain.BranchAndBound, "")
+	fmt.Printf("Digest %x of %s anchored by transaction %x\n", digest, file, txn.Id)
+}
+
+// verifyNotarization finds the transaction which first anchored the digest
+// of a file
+func (cli *Cmd) verifyNotarization(nodeId string, file string) {
+	digest := fileDigest(file)
+	chain := blockchain.ContinueBlockChain(nodeId)
+	defer chain.Database.Close()
+	txn, block, err := chain.FindData(digest)
+	if err != nil {
+		fmt.Printf("Error: digest %x of %s is not anchored\n", digest, file)
+		runtime.Goexit()
+	}
+	fmt.Printf("Digest %x of %s anchored by transaction %x\n", digest, file, txn.Id)
+	fmt.Printf("Block %x height: %d confirmations: %d time: %s\n", block.Hash, block.Height,
+		chain.GetBestHeight()-block.Height+1, time.Unix(block.Timstamp, 0).Format(time.RFC3339))
+}
+
+func fileDigest(file string) []byte {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		fmt.Println("Error:", err)
+		runtime.Goexit()
+	}
+	digest := sha256.Sum256(data)
+	return digest[:]
+}
+
+// initiateSwap pays the participant of an atomic swap with a contract for a
+// new secret, which the initiator keeps until claiming the participant's
+// contract
+func (cli *Cmd) initiateSwap(nodeId string, from string, to string, amount int, lockTime int64, mine bool) {
+	secret, secretHash := blockchain.NewSwapSecret()
+	if lockTime == 0 {
+		lockTime = time.Now().Add(48 * time.Hour).Unix()
+	}
+	cli.createSwap(nodeId, from, to, amount, secretHash, lockTime, mine)
+	fmt.Printf("Secret:               %x\n", secret)
+	fmt.Printf("Secret hash:          %x\n", secretHash)
+}
+
+// participateSwap pays the initiator of an atomic swap with a contract for
+// the secret hash of the initiator's contract, refundable before it
+func (cli *Cmd) participateSwap(nodeId string, from string, to string, amount int, secretHash string, lockTime int64, mine bool) {
+	hash, err := hex.DecodeString(secretHash)
+	if err != nil || len(hash) != sha256.Size {
+		fmt.Println("Error: invalid secret hash")
+		runtime.Goexit()
+	}
+	if lockTime == 0 {
+		lockTime = time.Now().Add(24 * time.Hour).Unix()
+	}
+	cli.createSwap(nodeId, from, to, amount, hash, lockTime, mine)
+}
+
+// createSwap pays a contract claimable by the counterparty with the secret,
+// and refundable to a new wallet address after the lock time
+func (cli *Cmd) createSwap(nodeId string, from string, to string, amount int, secretHash []byte, lockTime int64, mine bool) {
+	if !wallet.ValidateAddress(to) || wallet.IsScriptAddress(to) {
+		fmt.Println("Error: the counterparty address must be a pubkey hash address")
+		runtime.Goexit()
+	}
+	wallets, _ := wallet.CreateWallets(nodeId)
+	unlockWallet(nodeId, wallets)
+	refund, err := wallets.AddChangeWallet()
+	if err != nil {
+		fmt.Println("Error:", err)
+		runtime.Goexit()
+	}
+	wallets.SaveFile(nodeId)
+
+	contract := script.AtomicSwapContract(script.AtomicSwap{
+		SecretHash:    secretHash,
+		RecipientHash: addressPubKeyHash(to),
+		RefundHash:    addressPubKeyHash(refund),
+		LockTime:      lockTime,
+	})
+	address := string(wallet.ScriptAddress(contract))
+	txn := cli.pay(nodeId, from, []recipient{{address, amount}}, nil, blockchain.Timelock{}, mine, blockchain.BranchAndBound, "")
+	fmt.Printf("Contract:             %x\n", contract)
+	fmt.Printf("Contract address:     %s\n", address)
+	fmt.Printf("Contract transaction: %x\n", txn.Id)
+	fmt.Printf("Refund address:       %s\n", refund)
+	fmt.Printf("Refundable after:     %s\n", blockchain.DescribeLockTime(lockTime))
+}
+
+// redeemSwap claims a contract paying this wallet with its secret
+func (cli *Cmd) redeemSwap(nodeId string, contract string, txId string, secret string, mine bool) {
+	swap := findSwapContract(nodeId, contract, txId)
+	secretBytes, err := hex.DecodeString(secret)
+	if err != nil {
+		fmt.Println("Error: invalid secret")
+		runtime.Goexit()
+	}
+	w := swapWallet(nodeId, swap.RecipientHash)
+	txn, err := swap.RedeemTx(w, secretBytes)
+	if err != nil {
+		fmt.Println("Error:", err)
+		runtime.Goexit()
+	}
+	submitTransaction(nodeId, txn, network.KnownNodes[0], mine)
+	fmt.Printf("Contract redeemed by transaction %x\n", txn.Id)
+}
+
+// refundSwap takes back the coins of a contract this wallet paid, once its
+// lock time has passed
+func (cli *Cmd) refundSwap(nodeId string, contract string, txId string, mine bool) {
+	swap := findSwapContract(nodeId, contract, txId)
+	w := swapWallet(nodeId, swap.RefundHash)
+	txn, err := swap.RefundTx(w)
+	if err != nil {
+		fmt.Println("Error:", err)
+		runtime.Goexit()
+	}
+	submitTransaction(nodeId, txn, network.KnownNodes[0], mine)
+	fmt.Printf("Contract refunded by transaction %x\n", txn.Id)
+}
+
+// auditSwap prints the terms of a contract and whether it was claimed,
+// with the secret it was claimed with
+func (cli *Cmd) auditSwap(nodeId string, contract string, txId string) {
+	swap := findSwapContract(nodeId, contract, txId)
+	fmt.Printf("Contract address:     %s\n", swap.Output.Address())
+	fmt.Printf("Contract value:       %d\n", swap.Output.Value)
+	fmt.Printf("Recipient address:    %s\n", wallet.AddressFromPubKeyHash(swap.RecipientHash))
+	fmt.Printf("Refund address:       %s\n", wallet.AddressFromPubKeyHash(swap.RefundHash))
+	fmt.Printf("Secret hash:          %x\n", swap.SecretHash)
+	fmt.Printf("Refundable after:     %s\n", blockchain.DescribeLockTime(swap.LockTime))
+
+	chain := blockchain.ContinueBlockChain(nodeId)
+	defer chain.Database.Close()
+	spender, err := chain.FindSpendingTransaction(swap.TxId, swap.Index)
+	switch {
+	case err != nil:
+		fmt.Println("Status:               unspent")
+	case swap.Secret(spender) != nil:
+		fmt.Printf("Status:               redeemed by transaction %x\n", spender.Id)
+		fmt.Printf("Secret:               %x\n", swap.Secret(spender))
+	default:
+		fmt.Printf("Status:               refunded by transaction %x\n", spender.Id)
+	}
+}
+
+func findSwapContract(nodeId string, contract string, txId string) *blockchain.SwapContract {
+	contractBytes, err := hex.DecodeString(contract)
+	if err != nil {
+		fmt.Println("Error: invalid contract")
+		runtime.Goexit()
+	}
+	id, err := hex.DecodeString(txId)
+	if err != nil {
+		fmt.Println("Error: invalid transaction id")
+		runtime.Goexit()
+	}
+	chain := blockchain.ContinueBlockChain(nodeId)
+	defer chain.Database.Close()
+	swap, err := chain.FindSwapContract(id, contractBytes)
+	if err != nil {
+		fmt.Println("Error:", err)
+		runtime.Goexit()
+	}
+	return swap
+}
+
+// swapWallet returns the wallet of the key a contract pays to
+func swapWallet(nodeId string, pubKeyHash []byte) *wallet.Wallet {
+	wallets, _ := wallet.CreateWallets(nodeId)
+	address := string(wallet.AddressFromPubKeyHash(pubKeyHash))
+	w, ok := wallets.Wallets[address]
+	if !ok {
+		fmt.Printf("Error: address %s is not in your wallet\n", address)
+		runtime.Goexit()
+	}
+	unlockWallet(nodeId, wallets)
+	w = wallets.Wallets[address]
+	return w
+}
+
+func (cli *Cmd) createPartial(nodeId string, from string, recipients []recipient, change string, strategy string, utxos string, out string) {
+	chain := blockchain.ContinueBlockChain(nodeId)
+	defer chain.Database.Close()
+	utxoSet := blockchain.UTXOSet{Blockchain: chain}
+	wallets, _ := wallet.CreateWallets(nodeId)
+
+	builder := blockchain.NewTxBuilder(utxoSet)
+	builder.Strategy = strategy
+
+	addresses := append(wallets.GetAllAddresses(), wallets.GetWatchOnlyAddresses()...)
+	addresses = append(addresses, wallets.GetScriptAddresses()...)
+	if from != "" {
+		addresses = strings.Split(from, ",")
+	}
+	if len(addresses) == 0 {
+		fmt.Println("Error: no addresses to spend from")
+		runtime.Goexit()
+	}
+	for _, address := range addresses {
+		if wallet.IsScriptAddress(address) {
+			redeemScript, ok := wallets.Scripts[address]
+			if !ok {
+				fmt.Printf("Error: script address %s is not in your wallet\n", address)
+				runtime.Goexit()
+			}
+			builder.AddRedeemScript(redeemScript)
+			continue
+		}
+		if err := builder.AddWatchOnly(address); err != nil {
+			fmt.Println("Error:", err)
+			runtime.Goexit()
+		}
+	}
+	if change == "" {
+		change = addresses[0]
+	}
+	builder.ChangeAddress = func() (string, error) {
+		return change, nil
+	}
+	for _, r := range recipients {
+		if err := builder.AddRecipient(r.address, r.amount); err != nil {
+			fmt.Println("Error:", err)
+			runtime.Goexit()
+		}
+	}
+	if utxos != "" {
+		var err error
+		if builder.Coins, err = parseOutpoints(utxoSet, utxos); err != nil {
+			fmt.Println("Error:", err)
+			runtime.Goexit()
+		}
+	}
+
+	partial, err := builder.BuildPartial()
+	if err != nil {
+		fmt.Println("Error:", err)
+		runtime.Goexit()
+	}
+	writePartial(out, partial)
+	fmt.Printf("Created transaction %x paying %d from %d outputs\n", partial.Tx.Id, builder.Amount(), len(partial.Tx.Inputs))
+}
+
+func (cli *Cmd) signPartial(nodeId string, in string, out string) {
+	partial := readPartial(in)
+	wallets, err := wallet.CreateWallets(nodeId)
+	if err != nil {
+		log.Panic(err)
+	}
+	unlockWallet(nodeId, wallets)
+
+	signed := 0
+	for _, address := range wallets.GetAllAddresses() {
+		w := wallets.Wallets[address]
+		if !w.CanSign() {
+			continue
+		}
+		n, err := partial.Sign(w)
+		if err != nil {
+			fmt.Println("Error:", err)
+			runtime.Goexit()
+		}
+		signed += n
+	}
+	writePartial(out, partial)
+	fmt.Printf("Signed %d inputs, %d of %d inputs are signed\n", signed, partial.Signed(), len(partial.Tx.Inputs))
+}
+
+func (cli *Cmd) combinePartial(in string, out string) {
+	var parts []*blockchain.PartialTransaction
+	for _, file := range strings.Split(in, ",") {
+		parts = append(parts, readPartial(file))
+	}
+	combined, err := blockchain.CombinePartial(parts...)
+	if err != nil {
+		fmt.Println("Error:", err)
+		runtime.Goexit()
+	}
+	writePartial(out, combined)
+	fmt.Printf("%d of %d inputs are signed\n", combined.Signed(), len(combined.Tx.Inputs))
+}
+
+func (cli *Cmd) finalizePartial(nodeId string, in string, mine bool) {
+	partial := readPartial(in)
+	txn, err := partial.Finalize()
+	if err != nil {
+		fmt.Println("Error:", err)
+		runtime.Goexit()
+	}
+
+	submitTransaction(nodeId, txn, network.KnownNodes[0], mine)
+	fmt.Printf("Transaction %x finalized\n", txn.Id)
+}
+
+// submitTransaction checks a signed transaction against the local chain and
+// sends it to a node, or mines it on this node
+func submitTransaction(nodeId string, txn *blockchain.Transaction, node string, mine bool) {
+	chain := blockchain.ContinueBlockChain(nodeId)
+	defer chain.Database.Close()
+	utxoSet := blockchain.UTXOSet{Blockchain: chain}
+	for _, in := range txn.Inputs {
+		if _, err := utxoSet.GetUnspentOutput(in.Id, in.OutIndex); err != nil {
+			fmt.Println("Error:", err)
+			runtime.Goexit()
+		}
+	}
+	if err := chain.VerifyTransaction(txn); err != nil {
+		fmt.Println("Error: transaction does not verify against the chain:", err)
+		runtime.Goexit()
+	}
+	if err := chain.CheckLocks(txn); err != nil {
+		fmt.Println("Error:", err)
+		runtime.Goexit()
+	}
+
+	if mine {
+		wallets, err := wallet.CreateWallets(nodeId)
+		if err != nil {
+			fmt.Println("Error:", err)
+			runtime.Goexit()
+		}
+		reward := rewardAddress(wallets, "")
+		wallets.SaveFile(nodeId)
+		cbtx := blockchain.CoinbaseTx(reward, "")
+		if _, err := chain.MineBlock([]*blockchain.Transaction{cbtx, txn}); err != nil {
+			fmt.Println("Error:", err)
+			runtime.Goexit()
+		}
+	} else {
+		network.SendTransaction(node, txn)
+		fmt.Println("Transaction sent")
+	}
+}
+
+func (cli *Cmd) createRawTransaction(inputs string, outputs string) {
+	var ins []blockchain.TxInput
+	for _, outpoint := range strings.Split(inputs, ",") {
+		txId, index, err := parseOutpoint(outpoint)
+		if err != nil {
+			fmt.Println("Error:", err)
+			runtime.Goexit()
+		}
+		ins = append(ins, blockchain.TxInput{Id: txId, OutIndex: index})
+	}
+
+	var outs []blockchain.TxOutput
+	for _, output := range strings.Split(outputs, ",") {
+		parts := strings.Split(output, ":")
+		if len(parts) != 2 || !wallet.ValidateAddress(parts[0]) {
+			fmt.Printf("Error: invalid output %q, expected address:amount\n", output)
+			runtime.Goexit()
+		}
+		amount, err := strconv.Atoi(parts[1])
+		if err != nil || amount <= 0 {
+			fmt.Printf("Error: invalid output %q, expected address:amount\n", output)
+			runtime.Goexit()
+		}
+		outs = append(outs, *blockchain.NewTXOutput(parts[0], amount))
+	}
+
+	fmt.Println(blockchain.EncodeRawTransaction(blockchain.CreateRawTransaction(ins, outs)))
+}
+
+func decodeRawTransaction(encoded string) *blockchain.Transaction {
+	txn, err := blockchain.DecodeRawTransaction(encoded)
+	if err != nil {
+		fmt.Println("Error:", err)
+		runtime.Goexit()
+	}
+	return txn
+}
+
+func (cli *Cmd) decodeRawTransaction(encoded string, asJSON bool) {
+	txn := decodeRawTransaction(encoded)
+	if asJSON {
+		fmt.Println(string(txn.JSON()))
+	} else {
+		fmt.Println(txn)
+	}
+}
+
+func (cli *Cmd) signRawTransaction(nodeId string, encoded string) {
+	txn := decodeRawTransaction(encoded)
+	wallets, err := wallet.CreateWallets(nodeId)
+	if err != nil {
+		log.Panic(err)
+	}
+	unlockWallet(nodeId, wallets)
+	var keys []*wallet.Wallet
+	for _, address := range wallets.GetAllAddresses() {
+		keys = append(keys, wallets.Wallets[address])
+	}
+	var redeemScripts [][]byte
+	for _, redeemScript := range wallets.Scripts {
+		redeemScripts = append(redeemScripts, redeemScript)
+	}
+
+	chain := blockchain.ContinueBlockChain(nodeId)
+	defer chain.Database.Close()
+	signed, complete, err := chain.SignRawTransaction(txn, keys, redeemScripts)
+	if err != nil {
+		fmt.Println("Error:", err)
+		runtime.Goexit()
+	}
+	fmt.Println(blockchain.EncodeRawTransaction(txn))
+	fmt.Printf("Signed %d inputs, complete: %t\n", signed, complete)
+}
+
+func (cli *Cmd) sendRawTransaction(nodeId string, encoded string, node string, mine bool) {
+	txn := decodeRawTransaction(encoded)
+	submitTransaction(nodeId, txn, node, mine)
+	fmt.Printf("Transaction %x submitted\n", txn.Id)
+}
+
+// readPartial and writePartial exchange partial transactions as hex text,
+// on stdout when no file is given
+func readPartial(file string) *blockchain.PartialTransaction {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		fmt.Println("Error:", err)
+		runtime.Goexit()
+	}
+	partial, err := blockchain.DecodePartial(string(data))
+	if err != nil {
+		fmt.Println("Error: invalid partial transaction:", err)
+		runtime.Goexit()
+	}
+	return partial
+}
+
+func writePartial(file string, partial *blockchain.PartialTransaction) {
+	encoded := blockchain.EncodePartial(partial)
+	if file == "" {
+		fmt.Println(encoded)
+		return
+	}
+	if err := os.WriteFile(file, []byte(encoded+"\n"), 0644); err != nil {
+		log.Panic(err)
+	}
+}
+
+// parseOutpoints looks up a comma separated list of txid:vout outputs
+func parseOutpoints(utxoSet blockchain.UTXOSet, list string) ([]blockchain.UnspentOutput, error) {
+	var coins []blockchain.UnspentOutput
+	for _, outpoint := range strings.Split(list, ",") {
+		txId, index, err := parseOutpoint(outpoint)
+		if err != nil {
+			return nil, err
+		}
+		coin, err := utxoSet.GetUnspentOutput(txId, index)
+		if err != nil {
+			return nil, err
+		}
+		coins = append(coins, coin)
+	}
+	return coins, nil
+}
+
+func parseOutpoint(outpoint string) ([]byte, int, error) {
+	parts := strings.Split(outpoint, ":")
+	if len(parts) != 2 {
+		return nil, 0, fmt.Errorf("invalid output %q, expected txid:vout", outpoint)
+	}
+	txId, err := hex.DecodeString(parts[0])
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid output %q, expected txid:vout", outpoint)
+	}
+	index, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid output %q, expected txid:vout", outpoint)
+	}
+	return txId, index, nil
+}
+
+func (cli *Cmd) createWallet(nodeId string) {
+	wallets, _ := wallet.CreateWallets(nodeId)
+	unlockWallet(nodeId, wallets)
+	address, err := wallets.AddWallet()
+	if err != nil {
+		fmt.Println("Error:", err)
+		runtime.Goexit()
+	}
+	wallets.SaveFile(nodeId)
+	fmt.Printf("The new Address is %s\n", address)
+}
+
+func (cli *Cmd) createMnemonicWallet(nodeId string, words int, passphrase string) {
+	wallets, _ := wallet.CreateWallets(nodeId)
+	unlockWallet(nodeId, wallets)
+
+	entropy, err := wallet.NewEntropy(words)
+	if err != nil {
+		fmt.Println("Error:", err)
+		runtime.Goexit()
+	}
+	mnemonic, err := wallet.NewMnemonic(entropy)
+	if err != nil {
+		log.Panic(err)
+	}
+	seed, err := wallet.MnemonicSeed(mnemonic, passphrase)
+	if err != nil {
+		log.Panic(err)
+	}
+	if err := wallets.SetSeed(seed); err != nil {
+		fmt.Println("Error:", err)
+		runtime.Goexit()
+	}
+	address, err := wallets.AddWallet()
+	if err != nil {
+		fmt.Println("Error:", err)
+		runtime.Goexit()
+	}
+	wallets.SaveFile(nodeId)
+
+	fmt.Println("Write down these words, they are the only way to restore this wallet:")
+	fmt.Println(mnemonic)
+	fmt.Printf("The new Address is %s\n", address)
+}
+
+func (cli *Cmd) restoreWallet(nodeId string, mnemonic string, passphrase string) {
+	seed, err := wallet.MnemonicSeed(mnemonic, passphrase)
+	if err != nil {
+		fmt.Println("Error:", err)
+		runtime.Goexit()
+	}
+	wallets, _ := wallet.CreateWallets(nodeId)
+	unlockWallet(nodeId, wallets)
+	if err := wallets.SetSeed(seed); err != nil {
+		fmt.Println("Error:", err)
+		runtime.Goexit()
+	}
+
+	if blockchain.StoreExists(blockchain.DefaultStoreConfig(nodeId)) {
+		chain := blockchain.ContinueBlockChain(nodeId)
+		defer chain.Database.Close()
+		used := chain.UsedPubKeyHashes()
+
+		found, err := wallets.DiscoverKeys(func(pubKeyHash []byte) bool {
+			return used[string(pubKeyHash)]
+		}, 20)
+		if err != nil {
+			fmt.Println("Error:", err)
+			runtime.Goexit()
+		}
+		fmt.Printf("Found %d used addresses\n", found)
+
+		utxoSet := blockchain.UTXOSet{Blockchain: chain}
+		balance := 0
+		for _, address := range wallets.GetAllAddresses() {
+			w := wallets.GetWallet(address)
+			for _, out := range utxoSet.FindUTXO(wallet.PubkeyHash(w.PublicKey)) {
+				balance += out.Value
+			}
+		}
+		fmt.Printf("Restored balance is %d\n", balance)
+		openWalletDB(nodeId, wallets, chain, true)
+	}
+	if len(wallets.GetAllAddresses()) == 0 {
+		if _, err := wallets.AddWallet(); err != nil {
+			fmt.Println("Error:", err)
+			runtime.Goexit()
+		}
+	}
+	wallets.SaveFile(nodeId)
+
+	for _, address := range wallets.GetAllAddresses() {
+		fmt.Println(address)
+	}
+}
+func (cli *Cmd) getXPub(nodeId string) {
+	wallets, _ := wallet.CreateWallets(nodeId)
+	xpub, err := wallets.AccountXPub()
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Println(xpub)
+}
+
+func (cli *Cmd) deriveAddress(xpub string, index uint, change bool) {
+	chain := wallet.ExternalChain
+	if change {
+		chain = wallet.InternalChain
+	}
+	address, err := wallet.DeriveAddress(xpub, chain, uint32(index))
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Println(address)
+}
+
+func (cli *Cmd) encryptWallet(nodeId string, passphrase string) {
+	wallets, err := wallet.CreateWallets(nodeId)
+	if err != nil {
+		log.Panic(err)
+	}
+	if err := wallets.Encrypt(passphrase); err != nil {
+		fmt.Println("Error:", err)
+		runtime.Goexit()
+	}
+	wallets.SaveFile(nodeId)
+	fmt.Println("Wallet encrypted, commands signing with it ask for the passphrase")
+}
+
+// unlockWallet asks for the passphrase of a locked wallet and keeps it
+// unlocked for the rest of the command
+func unlockWallet(nodeId string, wallets *wallet.Wallets) {
+	if !wallets.Locked() {
+		return
+	}
+	fmt.Print("Wallet passphrase: ")
+	passphrase, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && passphrase == "" {
+		fmt.Println("Error:", wallet.ErrWalletLocked)
+		runtime.Goexit()
+	}
+	if err := wallets.Unlock(strings.TrimRight(passphrase, "\r\n")); err != nil {
+		fmt.Println("Error:", err)
+		runtime.Goexit()
+	}
+	if err := wallets.KeepUnlocked(nodeId); err != nil {
+		log.Panic(err)
+	}
+}
+
+func (cli *Cmd) listAddress(nodeId string) {
+	wallets, _ := wallet.CreateWallets(nodeId)
+	addresses := wallets.GetAllAddresses()
+
+	for _, address := range addresses {
+		fmt.Println(address)
+	}
+	for _, address := range wallets.GetWatchOnlyAddresses() {
+		fmt.Println(address, "(watch-only)")
+	}
+	for _, address := range wallets.GetScriptAddresses() {
+		fmt.Printf("%s (%s)\n", address, describeScript(wallets.Scripts[address]))
+	}
+}
+func (cli *Cmd) Run() {
+	cli.validateArgs()
+
+	nodeId := os.Getenv("NODE_ID")
+	if nodeId == "" {
+		fmt.Printf("nodeId env variable not set")
+		runtime.Goexit()
+	}
+
+	getBalanceCmd := flag.NewFlagSet("getbalance", flag.ExitOnError)
+	createBlockchainCmd := flag.NewFlagSet("createblockchain", flag.ExitOnError)
+	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
+	sendManyCmd := flag.NewFlagSet("sendmany", flag.ExitOnError)
+	createPsbtCmd := flag.NewFlagSet("createpsbt", flag.ExitOnError)
+	createRawTxCmd := flag.NewFlagSet("createrawtransaction", flag.ExitOnError)
+	decodeRawTxCmd := flag.NewFlagSet("decoderawtransaction", flag.ExitOnError)
+	signRawTxCmd := flag.NewFlagSet("signrawtransaction", flag.ExitOnError)
+	sendRawTxCmd := flag.NewFlagSet("sendrawtransaction", flag.ExitOnError)
+	signPsbtCmd := flag.NewFlagSet("signpsbt", flag.ExitOnError)
+	combinePsbtCmd := flag.NewFlagSet("combinepsbt", flag.ExitOnError)
+	finalizePsbtCmd := flag.NewFlagSet("finalizepsbt", flag.ExitOnError)
+	printChainCmd := flag.NewFlagSet("printchain", flag.ExitOnError)
+	getBlockCmd := flag.NewFlagSet("getblock", flag.ExitOnError)
+	createWalletCmd := flag.NewFlagSet("createwallet", flag.ExitOnError)
+	restoreWalletCmd := flag.NewFlagSet("restorewallet", flag.ExitOnError)
+	listAddressCmd := flag.NewFlagSet("listaddress", flag.ExitOnError)
+	getXPubCmd := flag.NewFlagSet("getxpub", flag.ExitOnError)
+	dumpPrivKeyCmd := flag.NewFlagSet("dumpprivkey", flag.ExitOnError)
+	getPubKeyCmd := flag.NewFlagSet("getpubkey", flag.ExitOnError)
+	createMultisigCmd := flag.NewFlagSet("createmultisig", flag.ExitOnError)
+	addScriptCmd := flag.NewFlagSet("addscript", flag.ExitOnError)
+	decodeScriptCmd := flag.NewFlagSet("decodescript", flag.ExitOnError)
+	listTransactionsCmd := flag.NewFlagSet("listtransactions", flag.ExitOnError)
+	getWalletBalanceCmd := flag.NewFlagSet("getwalletbalance", flag.ExitOnError)
+	setLabelCmd := flag.NewFlagSet("setlabel", flag.ExitOnError)
+	importPrivKeyCmd := flag.NewFlagSet("importprivkey", flag.ExitOnError)
+	importAddressCmd := flag.NewFlagSet("importaddress", flag.ExitOnError)
+	encryptWalletCmd := flag.NewFlagSet("encryptwallet", flag.ExitOnError)
+	deriveAddressCmd := flag.NewFlagSet("deriveaddress", flag.ExitOnError)
+	reindexUtxo := flag.NewFlagSet("reindexutxo", flag.ExitOnError)
+	reindexTxCmd := flag.NewFlagSet("reindextx", flag.ExitOnError)
+	reindexAddrCmd := flag.NewFlagSet("reindexaddr", flag.ExitOnError)
+	historyCmd := flag.NewFlagSet("history", flag.ExitOnError)
+	issueTokenCmd := flag.NewFlagSet("issuetoken", flag.ExitOnError)
+	sendTokenCmd := flag.NewFlagSet("sendtoken", flag.ExitOnError)
+	mintNFTCmd := flag.NewFlagSet("mintnft", flag.ExitOnError)
+	transferNFTCmd := flag.NewFlagSet("transfernft", flag.ExitOnError)
+	listNFTsCmd := flag.NewFlagSet("listnfts", flag.ExitOnError)
+	registerNameCmd := flag.NewFlagSet("registername", flag.ExitOnError)
+	renewNameCmd := flag.NewFlagSet("renewname", flag.ExitOnError)
+	transferNameCmd := flag.NewFlagSet("transfername", flag.ExitOnError)
+	resolveNameCmd := flag.NewFlagSet("resolvename", flag.ExitOnError)
+	listNamesCmd := flag.NewFlagSet("listnames", flag.ExitOnError)
+	stakeCmd := flag.NewFlagSet("stake", flag.ExitOnError)
+	unstakeCmd := flag.NewFlagSet("unstake", flag.ExitOnError)
+	stakersCmd := flag.NewFlagSet("stakers", flag.ExitOnError)
+	reportDoubleSignCmd := flag.NewFlagSet("reportdoublesign", flag.ExitOnError)
+	listDoubleSignsCmd := flag.NewFlagSet("listdoublesigns", flag.ExitOnError)
+	notarizeCmd := flag.NewFlagSet("notarize", flag.ExitOnError)
+	verifyNotarizationCmd := flag.NewFlagSet("verifynotarization", flag.ExitOnError)
+	initiateSwapCmd := flag.NewFlagSet("initiateswap", flag.ExitOnError)
+	participateSwapCmd := flag.NewFlagSet("participateswap", flag.ExitOnError)
+	redeemSwapCmd := flag.NewFlagSet("redeemswap", flag.ExitOnError)
+	refundSwapCmd := flag.NewFlagSet("refundswap", flag.ExitOnError)
+	auditSwapCmd := flag.NewFlagSet("auditswap", flag.ExitOnError)
+	startNodeCmd := flag.NewFlagSet("startnode", flag.ExitOnError)
+
+	getBalanceAddress := getBalanceCmd.String("address", "", "The address to get balance for")
+	createBlockchainAddress := createBlockchainCmd.String("address", "", "The address to send genesis block reward to")
+	createBlockchainTxIndex := createBlockchainCmd.Bool("txindex", false, "Maintain an index of all transactions")
+	createBlockchainAddrIndex := createBlockchainCmd.Bool("addrindex", false, "Maintain an index of the transactions of every address")
+	createBlockchainConsensus := createBlockchainCmd.String("consensus", blockchain.ConsensusPoW, "Consensus engine, pow, poa or pos")
+	createBlockchainSigners := createBlockchainCmd.String("signers", "", "Comma separated addresses of the proof of authority signers, or of the proof of stake bootstrap signers")
+	historyAddress := historyCmd.String("address", "", "The address to list transactions for")
+	sendFrom := sendCmd.String("from", "", "Comma separated source wallet addresses, all wallet addresses when empty")
+	sendTo := sendCmd.String("to", "", "Destination wallet address, or a registered @name")
+	sendAmount := sendCmd.Int("amount", 0, "Amount to send")
+	sendMine := sendCmd.Bool("mine", false, "Mine immediately on the same node")
+	createRawTxInputs := createRawTxCmd.String("inputs", "", "Comma separated txid:vout outputs to spend")
+	createRawTxOutputs := createRawTxCmd.String("outputs", "", "Comma separated address:amount outputs to create")
+	decodeRawTxHex := decodeRawTxCmd.String("hex", "", "Hex of the raw transaction")
+	decodeRawTxJSON := decodeRawTxCmd.Bool("json", false, "Print the transaction as JSON")
+	signRawTxHex := signRawTxCmd.String("hex", "", "Hex of the raw transaction")
+	sendRawTxHex := sendRawTxCmd.String("hex", "", "Hex of the signed raw transaction")
+	sendRawTxNode := sendRawTxCmd.String("node", network.KnownNodes[0], "Address of the node to submit the transaction to")
+	sendRawTxMine := sendRawTxCmd.Bool("mine", false, "Mine immediately on the same node instead of submitting")
+	createPsbtFrom := createPsbtCmd.String("from", "", "Comma separated addresses to spend from, all wallet and watch-only addresses when empty")
+	createPsbtTo := createPsbtCmd.String("to", "", "Destination address")
+	createPsbtAmount := createPsbtCmd.Int("amount", 0, "Amount to send")
+	createPsbtFile := createPsbtCmd.String("file", "", "JSON object or CSV file mapping addresses to amounts, instead of -to and -amount")
+	createPsbtChange := createPsbtCmd.String("change", "", "Change address, the first -from address when empty")
+	createPsbtStrategy := createPsbtCmd.String("strategy", blockchain.BranchAndBound, "Coin selection strategy: bnb, largest or random")
+	createPsbtUtxos := createPsbtCmd.String("utxos", "", "Comma separated txid:vout outputs to spend instead of selecting coins")
+	createPsbtOut := createPsbtCmd.String("out", "", "File to write the partial transaction to, stdout when empty")
+	signPsbtIn := signPsbtCmd.String("in", "", "File of the partial transaction")
+	signPsbtOut := signPsbtCmd.String("out", "", "File to write the signed partial transaction to, stdout when empty")
+	combinePsbtIn := combinePsbtCmd.String("in", "", "Comma separated files of partial transactions")
+	combinePsbtOut := combinePsbtCmd.String("out", "", "File to write the combined partial transaction to, stdout when empty")
+	finalizePsbtIn := finalizePsbtCmd.String("in", "", "File of the signed partial transaction")
+	finalizePsbtMine := finalizePsbtCmd.Bool("mine", false, "Mine immediately on the same node instead of broadcasting")
+	sendManyFrom := sendManyCmd.String("from", "", "Comma separated source wallet addresses, all wallet addresses when empty")
+	sendManyFile := sendManyCmd.String("file", "", "JSON object or CSV file mapping addresses to amounts")
+	sendManyMine := sendManyCmd.Bool("mine", false, "Mine immediately on the same node")
+	sendManyStrategy := sendManyCmd.String("strategy", blockchain.BranchAndBound, "Coin selection strategy: bnb, largest or random")
+	sendManyUtxos := sendManyCmd.String("utxos", "", "Comma separated txid:vout outputs to spend instead of selecting coins")
+	sendStrategy := sendCmd.String("strategy", blockchain.BranchAndBound, "Coin selection strategy: bnb, largest or random")
+	sendUtxos := sendCmd.String("utxos", "", "Comma separated txid:vout outputs to spend instead of selecting coins")
+	sendLockTime := sendCmd.Int64("locktime", 0, "Block height, or unix time from 500000000, before which the coins sent cannot be spent")
+	sendAfterBlocks := sendCmd.Int("after-blocks", 0, "Number of blocks the coins sent must be confirmed for before they can be spent")
+	printChainFrom := printChainCmd.Int("from", -1, "Height of the first block to print")
+	printChainTo := printChainCmd.Int("to", -1, "Height of the last block to print")
+	getBlockHeight := getBlockCmd.Int("height", -1, "Height of the block to print")
+	deriveAddressXPub := deriveAddressCmd.String("xpub", "", "Extended public key to derive from")
+	deriveAddressIndex := deriveAddressCmd.Uint("index", 0, "Index of the address")
+	deriveAddressChange := deriveAddressCmd.Bool("change", false, "Derive a change address")
+	createWalletMnemonic := createWalletCmd.Bool("mnemonic", false, "Create an HD wallet backed up by a mnemonic word list")
+	createWalletWords := createWalletCmd.Int("words", 12, "Number of words in the mnemonic")
+	createWalletPassphrase := createWalletCmd.String("passphrase", "", "Optional passphrase protecting the mnemonic")
+	restoreWalletMnemonic := restoreWalletCmd.String("mnemonic", "", "Mnemonic word list of the wallet")
+	restoreWalletPassphrase := restoreWalletCmd.String("passphrase", "", "Passphrase used when the wallet was created")
+	encryptWalletPassphrase := encryptWalletCmd.String("passphrase", "", "Passphrase to encrypt the wallet with")
+	listTransactionsCount := listTransactionsCmd.Int("count", 10, "Number of transactions to list, 0 lists all")
+	getWalletBalanceMinConf := getWalletBalanceCmd.Int("minconf", 1, "Confirmations an output needs to be counted")
+	setLabelAddress := setLabelCmd.String("address", "", "Wallet address to label")
+	setLabelLabel := setLabelCmd.String("label", "", "Label of the address, empty removes it")
+	dumpPrivKeyAddress := dumpPrivKeyCmd.String("address", "", "Wallet address of the key")
+	getPubKeyAddress := getPubKeyCmd.String("address", "", "Wallet address of the key")
+	createMultisigM := createMultisigCmd.Int("m", 0, "Number of signatures required to spend")
+	createMultisigPubKeys := createMultisigCmd.String("pubkeys", "", "Comma separated hex public keys")
+	addScriptScript := addScriptCmd.String("script", "", "Hex of the redeem script")
+	decodeScriptHex := decodeScriptCmd.String("hex", "", "Hex of the script")
+	importPrivKeyKey := importPrivKeyCmd.String("key", "", "Encoded private key")
+	importPrivKeyRescan := importPrivKeyCmd.Bool("rescan", false, "Scan the chain for transactions of the key")
+	importAddressAddress := importAddressCmd.String("address", "", "Address to watch")
+	importAddressRescan := importAddressCmd.Bool("rescan", false, "Scan the chain for transactions of the address")
+	issueTokenName := issueTokenCmd.String("name", "", "Name of the token, or of the token to mint more of")
+	issueTokenSupply := issueTokenCmd.Int("supply", 0, "Amount of the token issued")
+	issueTokenMintable := issueTokenCmd.Bool("mintable", false, "Let the issuer mint more of the token later")
+	issueTokenFrom := issueTokenCmd.String("from", "", "Comma separated wallet addresses paying for the transaction, the first coin spent becomes the issuer")
+	issueTokenTo := issueTokenCmd.String("to", "", "Address the issued tokens are paid to")
+	issueTokenMine := issueTokenCmd.Bool("mine", false, "Mine immediately on the same node")
+	sendTokenToken := sendTokenCmd.String("token", "", "Name or id of the token")
+	sendTokenFrom := sendTokenCmd.String("from", "", "Comma separated source wallet addresses, all wallet addresses when empty")
+	sendTokenTo := sendTokenCmd.String("to", "", "Destination address")
+	sendTokenAmount := sendTokenCmd.Int("amount", 0, "Amount of the token to send")
+	sendTokenMine := sendTokenCmd.Bool("mine", false, "Mine immediately on the same node")
+	mintNFTFile := mintNFTCmd.String("file", "", "File the NFT stands for")
+	mintNFTHash := mintNFTCmd.String("hash", "", "Hex sha256 hash of the content the NFT stands for, instead of -file")
+	mintNFTURI := mintNFTCmd.String("uri", "", "URI of the content")
+	mintNFTFrom := mintNFTCmd.String("from", "", "Comma separated wallet addresses paying for the transaction, the first coin spent becomes the issuer")
+	mintNFTTo := mintNFTCmd.String("to", "", "Address the NFT is paid to")
+	mintNFTMine := mintNFTCmd.Bool("mine", false, "Mine immediately on the same node")
+	transferNFTId := transferNFTCmd.String("id", "", "Id of the NFT")
+	transferNFTFrom := transferNFTCmd.String("from", "", "Comma separated source wallet addresses, all wallet addresses when empty")
+	transferNFTTo := transferNFTCmd.String("to", "", "Destination address")
+	transferNFTMine := transferNFTCmd.Bool("mine", false, "Mine immediately on the same node")
+	listNFTsAddress := listNFTsCmd.String("address", "", "Address whose NFTs are listed")
+	registerNameName := registerNameCmd.String("name", "", "Name to register")
+	registerNameOwner := registerNameCmd.String("owner", "", "Address the name resolves to")
+	registerNameFrom := registerNameCmd.String("from", "", "Comma separated wallet addresses paying for the transaction, all wallet addresses when empty")
+	registerNameMine := registerNameCmd.Bool("mine", false, "Mine immediately on the same node")
+	renewNameName := renewNameCmd.String("name", "", "Name to renew")
+	renewNameMine := renewNameCmd.Bool("mine", false, "Mine immediately on the same node")
+	transferNameName := transferNameCmd.String("name", "", "Name to transfer")
+	transferNameTo := transferNameCmd.String("to", "", "Address of the new owner")
+	transferNameMine := transferNameCmd.Bool("mine", false, "Mine immediately on the same node")
+	resolveNameName := resolveNameCmd.String("name", "", "Name to resolve")
+	listNamesAddress := listNamesCmd.String("address", "", "Address whose names are listed")
+	stakeAddress := stakeCmd.String("address", "", "Address of the staker")
+	stakeAmount := stakeCmd.Int("amount", 0, "Amount to stake")
+	stakeFrom := stakeCmd.String("from", "", "Comma separated wallet addresses paying the stake, all wallet addresses when empty")
+	stakeMine := stakeCmd.Bool("mine", false, "Mine immediately on the same node")
+	unstakeAddress := unstakeCmd.String("address", "", "Address of the staker")
+	unstakeMine := unstakeCmd.Bool("mine", false, "Mine immediately on the same node")
+	reportDoubleSignBlock1 := reportDoubleSignCmd.String("block1", "", "Hash of the first block")
+	reportDoubleSignBlock2 := reportDoubleSignCmd.String("block2", "", "Hash of the second block")
+	reportDoubleSignFrom := reportDoubleSignCmd.String("from", "", "Comma separated wallet addresses paying for the transaction, all wallet addresses when empty")
+	reportDoubleSignMine := reportDoubleSignCmd.Bool("mine", false, "Mine immediately on the same node")
+	notarizeFile := notarizeCmd.String("file", "", "File to notarize")
+	notarizeFrom := notarizeCmd.String("from", "", "Comma separated wallet addresses paying for the transaction, all wallet addresses when empty")
+	notarizeMine := notarizeCmd.Bool("mine", false, "Mine immediately on the same node")
+	verifyNotarizationFile := verifyNotarizationCmd.String("file", "", "File to look up the notarization of")
+	initiateSwapFrom := initiateSwapCmd.String("from", "", "Comma separated source wallet addresses, all wallet addresses when empty")
+	initiateSwapTo := initiateSwapCmd.String("to", "", "Address of the participant")
+	initiateSwapAmount := initiateSwapCmd.Int("amount", 0, "Amount to pay the participant")
+	initiateSwapLockTime := initiateSwapCmd.Int64("locktime", 0, "Block height, or unix time from 500000000, after which the contract can be refunded")
+	initiateSwapMine := initiateSwapCmd.Bool("mine", false, "Mine immediately on the same node")
+	participateSwapFrom := participateSwapCmd.String("from", "", "Comma separated source wallet addresses, all wallet addresses when empty")
+	participateSwapTo := participateSwapCmd.String("to", "", "Address of the initiator")
+	participateSwapAmount := participateSwapCmd.Int("amount", 0, "Amount to pay the initiator")
+	participateSwapSecretHash := participateSwapCmd.String("secrethash", "", "Secret hash of the initiator's contract")
+	participateSwapLockTime := participateSwapCmd.Int64("locktime", 0, "Block height, or unix time from 500000000, after which the contract can be refunded")
+	participateSwapMine := participateSwapCmd.Bool("mine", false, "Mine immediately on the same node")
+	redeemSwapContract := redeemSwapCmd.String("contract", "", "Hex of the contract")
+	redeemSwapTxId := redeemSwapCmd.String("txid", "", "Id of the transaction paying to the contract")
+	redeemSwapSecret := redeemSwapCmd.String("secret", "", "Hex of the secret")
+	redeemSwapMine := redeemSwapCmd.Bool("mine", false, "Mine immediately on the same node")
+	refundSwapContract := refundSwapCmd.String("contract", "", "Hex of the contract")
+	refundSwapTxId := refundSwapCmd.String("txid", "", "Id of the transaction paying to the contract")
+	refundSwapMine := refundSwapCmd.Bool("mine", false, "Mine immediately on the same node")
+	auditSwapContract := auditSwapCmd.String("contract", "", "Hex of the contract")
+	auditSwapTxId := auditSwapCmd.String("txid", "", "Id of the transaction paying to the contract")
+	startNodeMiner := startNodeCmd.String("miner", "", "Enable miner and you can mine blocks and send reward to Address")
+
+	switch os.Args[1] {
+	case "startnode":
+		err := startNodeCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "createwallet":
+		err := createWalletCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "restorewallet":
+		err := restoreWalletCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "listaddress":
+		err := listAddressCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "encryptwallet":
+		err := encryptWalletCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "listtransactions":
+		err := listTransactionsCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getwalletbalance":
+		err := getWalletBalanceCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "setlabel":
+		err := setLabelCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "dumpprivkey":
+		err := dumpPrivKeyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getpubkey":
+		err := getPubKeyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "createmultisig":
+		err := createMultisigCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "addscript":
+		err := addScriptCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "decodescript":
+		err := decodeScriptCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "importprivkey":
+		err := importPrivKeyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "importaddress":
+		err := importAddressCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getxpub":
+		err := getXPubCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "deriveaddress":
+		err := deriveAddressCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getbalance":
+		err := getBalanceCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "createblockchain":
+		err := createBlockchainCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "printchain":
+		err := printChainCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getblock":
+		err := getBlockCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "send":
+		err := sendCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "issuetoken":
+		err := issueTokenCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "sendtoken":
+		err := sendTokenCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "mintnft":
+		err := mintNFTCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "transfernft":
+		err := transferNFTCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "listnfts":
+		err := listNFTsCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "registername":
+		err := registerNameCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "renewname":
+		err := renewNameCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "transfername":
+		err := transferNameCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "resolvename":
+		err := resolveNameCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "listnames":
+		err := listNamesCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "stake":
+		err := stakeCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "unstake":
+		err := unstakeCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "stakers":
+		err := stakersCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "reportdoublesign":
+		err := reportDoubleSignCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "listdoublesigns":
+		err := listDoubleSignsCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "notarize":
+		err := notarizeCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "verifynotarization":
+		err := verifyNotarizationCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "initiateswap":
+		err := initiateSwapCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "participateswap":
+		err := participateSwapCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "redeemswap":
+		err := redeemSwapCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "refundswap":
+		err := refundSwapCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "auditswap":
+		err := auditSwapCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "createrawtransaction":
+		err := createRawTxCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "decoderawtransaction":
+		err := decodeRawTxCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "signrawtransaction":
+		err := signRawTxCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "sendrawtransaction":
+		err := sendRawTxCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "createpsbt":
+		err := createPsbtCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "signpsbt":
+		err := signPsbtCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "combinepsbt":
+		err := combinePsbtCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "finalizepsbt":
+		err := finalizePsbtCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "sendmany":
+		err := sendManyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "reindexutxo":
+		err := reindexUtxo.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "reindextx":
+		err := reindexTxCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "reindexaddr":
+		err := reindexAddrCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "history":
+		err := historyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	default:
+		cli.printUsage()
+		runtime.Goexit()
+	}
+
+	if reindexUtxo.Parsed() {
+		cli.reindex(nodeId)
+	}
+	if reindexTxCmd.Parsed() {
+		cli.reindexTx(nodeId)
+	}
+	if reindexAddrCmd.Parsed() {
+		cli.reindexAddr(nodeId)
+	}
+	if historyCmd.Parsed() {
+		if *historyAddress == "" {
+			historyCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.history(*historyAddress, nodeId)
+	}
+	if getBalanceCmd.Parsed() {
+		if *getBalanceAddress == "" {
+			cli.getWalletBalances(nodeId)
+		} else {
+			cli.getBalance(*getBalanceAddress, nodeId)
+		}
+	}
+
+	if createBlockchainCmd.Parsed() {
+		if *createBlockchainAddress == "" {
+			createBlockchainCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.createBlockChain(*createBlockchainAddress, nodeId, *createBlockchainConsensus, *createBlockchainSigners, *createBlockchainTxIndex, *createBlockchainAddrIndex)
+	}
+
+	if printChainCmd.Parsed() {
+		cli.printChain(nodeId, *printChainFrom, *printChainTo)
+	}
+
+	if getBlockCmd.Parsed() {
+		if *getBlockHeight < 0 {
+			getBlockCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.getBlock(nodeId, *getBlockHeight)
+	}
+
+	if createRawTxCmd.Parsed() {
+		if *createRawTxInputs == "" || *createRawTxOutputs == "" {
+			createRawTxCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.createRawTransaction(*createRawTxInputs, *createRawTxOutputs)
+	}
+	if decodeRawTxCmd.Parsed() {
+		if *decodeRawTxHex == "" {
+			decodeRawTxCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.decodeRawTransaction(*decodeRawTxHex, *decodeRawTxJSON)
+	}
+	if signRawTxCmd.Parsed() {
+		if *signRawTxHex == "" {
+			signRawTxCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.signRawTransaction(nodeId, *signRawTxHex)
+	}
+	if sendRawTxCmd.Parsed() {
+		if *sendRawTxHex == "" {
+			sendRawTxCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.sendRawTransaction(nodeId, *sendRawTxHex, *sendRawTxNode, *sendRawTxMine)
+	}
+	if createPsbtCmd.Parsed() {
+		recipients := []recipient{{*createPsbtTo, *createPsbtAmount}}
+		if *createPsbtFile != "" {
+			var err error
+			if recipients, err = readRecipients(*createPsbtFile); err != nil {
+				fmt.Println("Error:", err)
+				runtime.Goexit()
+			}
+		} else if *createPsbtTo == "" || *createPsbtAmount <= 0 {
+			createPsbtCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.createPartial(nodeId, *createPsbtFrom, recipients, *createPsbtChange, *createPsbtStrategy, *createPsbtUtxos, *createPsbtOut)
+	}
+	if signPsbtCmd.Parsed() {
+		if *signPsbtIn == "" {
+			signPsbtCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.signPartial(nodeId, *signPsbtIn, *signPsbtOut)
+	}
+	if combinePsbtCmd.Parsed() {
+		if *combinePsbtIn == "" {
+			combinePsbtCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.combinePartial(*combinePsbtIn, *combinePsbtOut)
+	}
+	if finalizePsbtCmd.Parsed() {
+		if *finalizePsbtIn == "" {
+			finalizePsbtCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.finalizePartial(nodeId, *finalizePsbtIn, *finalizePsbtMine)
+	}
+	if sendManyCmd.Parsed() {
+		if *sendManyFile == "" {
+			sendManyCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.sendMany(*sendManyFrom, *sendManyFile, nodeId, *sendManyMine, *sendManyStrategy, *sendManyUtxos)
+	}
+	if sendCmd.Parsed() {
+		if *sendTo == "" || *sendAmount <= 0 {
+			sendCmd.Usage()
+			runtime.Goexit()
+		}
+
+		lock := blockchain.Timelock{LockTime: *sendLockTime, AfterBlocks: *sendAfterBlocks}
+		cli.send(*sendFrom, *sendTo, *sendAmount, nodeId, *sendMine, *sendStrategy, *sendUtxos, lock)
+	}
+	if issueTokenCmd.Parsed() {
+		if *issueTokenName == "" || *issueTokenSupply <= 0 {
+			issueTokenCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.issueToken(nodeId, *issueTokenFrom, *issueTokenName, *issueTokenSupply, *issueTokenMintable, *issueTokenTo, *issueTokenMine)
+	}
+	if sendTokenCmd.Parsed() {
+		if *sendTokenToken == "" || *sendTokenTo == "" || *sendTokenAmount <= 0 {
+			sendTokenCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.sendToken(nodeId, *sendTokenFrom, *sendTokenToken, *sendTokenTo, *sendTokenAmount, *sendTokenMine)
+	}
+	if mintNFTCmd.Parsed() {
+		if (*mintNFTFile == "") == (*mintNFTHash == "") {
+			mintNFTCmd.Usage()
+			runtime.Goexit()
+		}
+		contentHash, err := hex.DecodeString(*mintNFTHash)
+		if err != nil {
+			fmt.Println("Error: content hash is not hex")
+			runtime.Goexit()
+		}
+		if *mintNFTFile != "" {
+			contentHash = fileDigest(*mintNFTFile)
+		}
+		cli.mintNFT(nodeId, *mintNFTFrom, contentHash, *mintNFTURI, *mintNFTTo, *mintNFTMine)
+	}
+	if transferNFTCmd.Parsed() {
+		if *transferNFTId == "" || *transferNFTTo == "" {
+			transferNFTCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.transferNFT(nodeId, *transferNFTFrom, *transferNFTId, *transferNFTTo, *transferNFTMine)
+	}
+	if listNFTsCmd.Parsed() {
+		cli.listNFTs(nodeId, *listNFTsAddress)
+	}
+	if registerNameCmd.Parsed() {
+		if *registerNameName == "" {
+			registerNameCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.registerName(nodeId, *registerNameFrom, strings.TrimPrefix(*registerNameName, "@"), *registerNameOwner, *registerNameMine)
+	}
+	if renewNameCmd.Parsed() {
+		if *renewNameName == "" {
+			renewNameCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.renewName(nodeId, strings.TrimPrefix(*renewNameName, "@"), *renewNameMine)
+	}
+	if transferNameCmd.Parsed() {
+		if *transferNameName == "" || *transferNameTo == "" {
+			transferNameCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.transferName(nodeId, strings.TrimPrefix(*transferNameName, "@"), *transferNameTo, *transferNameMine)
+	}
+	if resolveNameCmd.Parsed() {
+		if *resolveNameName == "" {
+			resolveNameCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.resolveName(nodeId, strings.TrimPrefix(*resolveNameName, "@"))
+	}
+	if listNamesCmd.Parsed() {
+		cli.listNames(nodeId, *listNamesAddress)
+	}
+	if stakeCmd.Parsed() {
+		if *stakeAmount <= 0 {
+			stakeCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.stake(nodeId, *stakeFrom, *stakeAddress, *stakeAmount, *stakeMine)
+	}
+	if unstakeCmd.Parsed() {
+		if *unstakeAddress == "" {
+			unstakeCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.unstake(nodeId, *unstakeAddress, *unstakeMine)
+	}
+	if stakersCmd.Parsed() {
+		cli.stakers(nodeId)
+	}
+	if listDoubleSignsCmd.Parsed() {
+		cli.listDoubleSigns(nodeId)
+	}
+	if reportDoubleSignCmd.Parsed() {
+		first, err1 := hex.DecodeString(*reportDoubleSignBlock1)
+		second, err2 := hex.DecodeString(*reportDoubleSignBlock2)
+		if first == nil || second == nil || err1 != nil || err2 != nil {
+			reportDoubleSignCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.reportDoubleSign(nodeId, *reportDoubleSignFrom, first, second, *reportDoubleSignMine)
+	}
+	if notarizeCmd.Parsed() {
+		if *notarizeFile == "" {
+			notarizeCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.notarize(nodeId, *notarizeFile, *notarizeFrom, *notarizeMine)
+	}
+	if verifyNotarizationCmd.Parsed() {
+		if *verifyNotarizationFile == "" {
+			verifyNotarizationCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.verifyNotarization(nodeId, *verifyNotarizationFile)
+	}
+	if initiateSwapCmd.Parsed() {
+		if *initiateSwapTo == "" || *initiateSwapAmount <= 0 {
+			initiateSwapCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.initiateSwap(nodeId, *initiateSwapFrom, *initiateSwapTo, *initiateSwapAmount, *initiateSwapLockTime, *initiateSwapMine)
+	}
+	if participateSwapCmd.Parsed() {
+		if *participateSwapTo == "" || *participateSwapAmount <= 0 || *participateSwapSecretHash == "" {
+			participateSwapCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.participateSwap(nodeId, *participateSwapFrom, *participateSwapTo, *participateSwapAmount, *participateSwapSecretHash, *participateSwapLockTime, *participateSwapMine)
+	}
+	if redeemSwapCmd.Parsed() {
+		if *redeemSwapContract == "" || *redeemSwapTxId == "" || *redeemSwapSecret == "" {
+			redeemSwapCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.redeemSwap(nodeId, *redeemSwapContract, *redeemSwapTxId, *redeemSwapSecret, *redeemSwapMine)
+	}
+	if refundSwapCmd.Parsed() {
+		if *refundSwapContract == "" || *refundSwapTxId == "" {
+			refundSwapCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.refundSwap(nodeId, *refundSwapContract, *refundSwapTxId, *refundSwapMine)
+	}
+	if auditSwapCmd.Parsed() {
+		if *auditSwapContract == "" || *auditSwapTxId == "" {
+			auditSwapCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.auditSwap(nodeId, *auditSwapContract, *auditSwapTxId)
+	}
+	if createWalletCmd.Parsed() {
+		if *createWalletMnemonic {
+			cli.createMnemonicWallet(nodeId, *createWalletWords, *createWalletPassphrase)
+		} else {
+			cli.createWallet(nodeId)
+		}
+	}
+	if restoreWalletCmd.Parsed() {
+		if *restoreWalletMnemonic == "" {
+			restoreWalletCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.restoreWallet(nodeId, *restoreWalletMnemonic, *restoreWalletPassphrase)
+	}
+	if listAddressCmd.Parsed() {
 		cli.listAddress(nodeId)
 	}
+	if encryptWalletCmd.Parsed() {
+		if *encryptWalletPassphrase == "" {
+			encryptWalletCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.encryptWallet(nodeId, *encryptWalletPassphrase)
+	}
+	if listTransactionsCmd.Parsed() {
+		cli.listTransactions(nodeId, *listTransactionsCount)
+	}
+	if getWalletBalanceCmd.Parsed() {
+		cli.getWalletBalance(nodeId, *getWalletBalanceMinConf)
+	}
+	if setLabelCmd.Parsed() {
+		if *setLabelAddress == "" || !wallet.ValidateAddress(*setLabelAddress) {
+			setLabelCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.setLabel(nodeId, *setLabelAddress, *setLabelLabel)
+	}
+	if dumpPrivKeyCmd.Parsed() {
+		if *dumpPrivKeyAddress == "" {
+			dumpPrivKeyCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.dumpPrivKey(nodeId, *dumpPrivKeyAddress)
+	}
+	if getPubKeyCmd.Parsed() {
+		if *getPubKeyAddress == "" {
+			getPubKeyCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.getPubKey(nodeId, *getPubKeyAddress)
+	}
+	if createMultisigCmd.Parsed() {
+		if *createMultisigPubKeys == "" {
+			createMultisigCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.createMultisig(nodeId, *createMultisigM, *createMultisigPubKeys)
+	}
+	if addScriptCmd.Parsed() {
+		if *addScriptScript == "" {
+			addScriptCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.addScript(nodeId, *addScriptScript)
+	}
+	if decodeScriptCmd.Parsed() {
+		if *decodeScriptHex == "" {
+			decodeScriptCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.decodeScript(*decodeScriptHex)
+	}
+	if importPrivKeyCmd.Parsed() {
+		if *importPrivKeyKey == "" {
+			importPrivKeyCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.importPrivKey(nodeId, *importPrivKeyKey, *importPrivKeyRescan)
+	}
+	if importAddressCmd.Parsed() {
+		if *importAddressAddress == "" {
+			importAddressCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.importAddress(nodeId, *importAddressAddress, *importAddressRescan)
+	}
+	if getXPubCmd.Parsed() {
+		cli.getXPub(nodeId)
+	}
+	if deriveAddressCmd.Parsed() {
+		if *deriveAddressXPub == "" {
+			deriveAddressCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.deriveAddress(*deriveAddressXPub, *deriveAddressIndex, *deriveAddressChange)
+	}
 	if startNodeCmd.Parsed() {
 		cli.startNode(nodeId, *startNodeMiner)
 	}
@@ -5,6 +5,8 @@ go 1.18
 require (
 	github.com/dgraph-io/badger v1.5.4
 	github.com/mr-tron/base58 v1.1.0
+	github.com/vrecan/death/v3 v3.0.3
+	go.etcd.io/bbolt v1.3.7
 	golang.org/x/crypto v0.0.0-20190308221718-c2843e01d9a2
 )
 
@@ -13,7 +15,6 @@ require (
 	github.com/dgryski/go-farm v0.0.0-20180109070241-2de33835d102 // indirect
 	github.com/golang/protobuf v1.2.0 // indirect
 	github.com/pkg/errors v0.8.0 // indirect
-	github.com/vrecan/death/v3 v3.0.3 // indirect
 	golang.org/x/net v0.0.0-20190311183353-d8887717615a // indirect
 	golang.org/x/sys v0.16.0 // indirect
 )
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/gob"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -11,9 +12,13 @@ import (
 	"net"
 	"os"
 	"runtime"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/Harshjha3006/golang-blockchain/blockchain"
+	"github.com/Harshjha3006/golang-blockchain/wallet"
+	"github.com/Harshjha3006/golang-blockchain/walletdb"
 	"github.com/vrecan/death/v3"
 )
 
@@ -29,6 +34,7 @@ var (
 	blocksInTransit = [][]byte{}
 	memoryPool      = make(map[string]blockchain.Transaction)
 	minerAddress    string
+	mining          sync.Mutex // serializes the changes to the chain and the memory pool by the handlers and the forging loop
 )
 
 type Addr struct {
@@ -45,7 +51,8 @@ type Transaction struct {
 }
 
 type GetBlocks struct {
-	AddrFrom string
+	AddrFrom   string
+	BestHeight int
 }
 
 type GetData struct {
@@ -65,9 +72,9 @@ type Version struct {
 	NodeAddress string
 }
 
-func RequestBlocks() {
+func RequestBlocks(chain *blockchain.BlockChain) {
 	for _, node := range KnownNodes {
-		SendGetBlocks(node)
+		SendGetBlocks(node, chain)
 	}
 }
 func SendBlock(addr string, b *blockchain.Block) {
@@ -92,8 +99,8 @@ func SendTransaction(addr string, tx *blockchain.Transaction) {
 	SendData(addr, payload)
 }
 
-func SendGetBlocks(addr string) {
-	payload := GobEncode(GetBlocks{nodeAddress})
+func SendGetBlocks(addr string, chain *blockchain.BlockChain) {
+	payload := GobEncode(GetBlocks{nodeAddress, chain.GetBestHeight()})
 	payload = append(CmdToBytes("getblocks"), payload...)
 	SendData(addr, payload)
 }
@@ -145,7 +152,7 @@ func SendData(addr string, data []byte) {
 	}
 
 }
-func HandleAddr(request []byte) {
+func HandleAddr(request []byte, chain *blockchain.BlockChain) {
 	var buffer bytes.Buffer
 	var payload Addr
 
@@ -159,7 +166,7 @@ func HandleAddr(request []byte) {
 
 	KnownNodes = append(KnownNodes, payload.AddrList...)
 	fmt.Printf("There are %d known nodes", len(KnownNodes))
-	RequestBlocks()
+	RequestBlocks(chain)
 }
 func HandleInv(request []byte, chain *blockchain.BlockChain) {
 	var buff bytes.Buffer
@@ -212,7 +219,7 @@ func HandleVersion(request []byte, chain *blockchain.BlockChain) {
 	otherHeight := payload.BestHeight
 
 	if bestHeight < otherHeight {
-		SendGetBlocks(payload.NodeAddress)
+		SendGetBlocks(payload.NodeAddress, chain)
 	} else if bestHeight > otherHeight {
 		SendVersion(payload.NodeAddress, chain)
 	}
@@ -241,7 +248,10 @@ func HandleGetBlocks(request []byte, chain *blockchain.BlockChain) {
 	if err != nil {
 		log.Panic(err)
 	}
-	blocks := chain.GetBlockHashes()
+	blocks := chain.GetBlockHashesInRange(payload.BestHeight+1, -1)
+	if len(blocks) == 0 {
+		return
+	}
 	SendInv(payload.AddrFrom, "block", blocks)
 }
 func HandleBlock(request []byte, chain *blockchain.BlockChain) {
@@ -259,16 +269,27 @@ func HandleBlock(request []byte, chain *blockchain.BlockChain) {
 	block := blockchain.Deserialize(blockData)
 
 	fmt.Printf("Received a new block")
-	chain.AddBlock(block)
+	mining.Lock()
+	err = chain.AddBlock(block)
+	mining.Unlock()
+	if err != nil {
+		fmt.Printf("Rejected block %x : %s\n", block.Hash, err)
+		return
+	}
 	fmt.Printf("Added block %x\n", block.Hash)
 
+	if nodeAddress == KnownNodes[0] {
+		for _, node := range KnownNodes {
+			if node != KnownNodes[0] && node != payload.AddrFrom {
+				SendInv(node, "block", [][]byte{block.Hash})
+			}
+		}
+	}
+
 	if len(blocksInTransit) > 0 {
 		blockHash := blocksInTransit[0]
 		SendGetData(payload.AddrFrom, "block", blockHash)
 		blocksInTransit = blocksInTransit[1:]
-	} else {
-		utxoset := blockchain.UTXOSet{Blockchain: chain}
-		utxoset.ReIndex()
 	}
 }
 func HandleGetData(request []byte, chain *blockchain.BlockChain) {
@@ -309,6 +330,12 @@ func HandleTransaction(req []byte, chain *blockchain.BlockChain) {
 	}
 	txData := payload.Transaction
 	tx := blockchain.DeserializeTransaction(txData)
+	if err := chain.CheckLocks(&tx); err != nil {
+		fmt.Printf("Rejecting transaction %x: %s\n", tx.Id, err)
+		return
+	}
+	mining.Lock()
+	defer mining.Unlock()
 	memoryPool[hex.EncodeToString(tx.Id)] = tx
 
 	fmt.Printf("%s, %d\n", nodeAddress, len(memoryPool))
@@ -320,7 +347,7 @@ func HandleTransaction(req []byte, chain *blockchain.BlockChain) {
 			}
 		}
 	} else {
-		if len(memoryPool) >= 2 && len(minerAddress) > 0 {
+		if len(memoryPool) >= 2 && len(minerAddress) > 0 && chain.Engine.Name() != blockchain.ConsensusPoS {
 			MineTx(chain)
 		}
 	}
@@ -333,20 +360,34 @@ func MineTx(chain *blockchain.BlockChain) {
 	for id := range memoryPool {
 		fmt.Printf("tx : %s\n", memoryPool[id].Id)
 		tx := memoryPool[id]
-		if chain.VerifyTransaction(&tx) {
+		if err := chain.CheckLocks(&tx); err != nil {
+			if !errors.Is(err, blockchain.ErrTimelocked) {
+				// spent by a block of another node
+				delete(memoryPool, id)
+			}
+		} else if chain.VerifyTransaction(&tx) == nil {
 			txs = append(txs, &tx)
+		} else {
+			delete(memoryPool, id)
 		}
 	}
-	if len(txs) <= 0 {
+	// proof of stake nodes produce a block in every slot, even without
+	// transactions
+	if len(txs) <= 0 && chain.Engine.Name() != blockchain.ConsensusPoS {
 		fmt.Printf("All transactions are invalid")
 		return
 	}
 
 	cbtx := blockchain.CoinbaseTx(minerAddress, "")
 	txs = append(txs, cbtx)
-	newBlock := chain.MineBlock(txs)
-	utxoset := blockchain.UTXOSet{Blockchain: chain}
-	utxoset.ReIndex()
+	newBlock, err := chain.MineBlock(txs)
+	if errors.Is(err, blockchain.ErrNotSelected) {
+		return
+	}
+	if err != nil {
+		fmt.Printf("Could not mine a block: %s\n", err)
+		return
+	}
 
 	fmt.Println("New Block added")
 	for _, tx := range txs {
@@ -364,6 +405,18 @@ func MineTx(chain *blockchain.BlockChain) {
 		MineTx(chain)
 	}
 }
+
+// forge produces the blocks of a proof of stake node: every half slot, it
+// mines the memory pool if one of the node's stakers may produce the next
+// block
+func forge(chain *blockchain.BlockChain) {
+	for range time.Tick(blockchain.StakeSlot * time.Second / 2) {
+		mining.Lock()
+		MineTx(chain)
+		mining.Unlock()
+	}
+}
+
 func GobEncode(data interface{}) []byte {
 	var buf bytes.Buffer
 
@@ -405,7 +458,7 @@ func HandleConnection(conn net.Conn, chain *blockchain.BlockChain) {
 	fmt.Printf("Received %s command\n", command)
 	switch command {
 	case "addr":
-		HandleAddr(req)
+		HandleAddr(req, chain)
 	case "block":
 		HandleBlock(req, chain)
 	case "inv":
@@ -437,6 +490,17 @@ func StartServer(nodeId string, mineAddress string) {
 	defer chain.Database.Close()
 	go CloseDb(chain)
 
+	if wallets, err := wallet.CreateWallets(nodeId); err == nil {
+		db, err := walletdb.Open(nodeId, wallets, chain)
+		if err != nil {
+			log.Panic(err)
+		}
+		chain.Subscribe(db)
+	}
+	if len(minerAddress) > 0 && chain.Engine.Name() == blockchain.ConsensusPoS {
+		go forge(chain)
+	}
+
 	if nodeAddress != KnownNodes[0] {
 		SendVersion(KnownNodes[0], chain)
 	}
@@ -0,0 +1,384 @@
+package script
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/ripemd160"
+)
+
+// Checker gives the engine access to the spending transaction for the
+// opcodes that depend on it
+type Checker interface {
+	// CheckSig reports whether signature is a valid signature of the
+	// spending input by pubKey
+	CheckSig(signature, pubKey []byte) bool
+	CheckLockTime(lockTime int64) bool
+	CheckSequence(sequence int64) bool
+}
+
+var (
+	ErrEvalFalse       = errors.New("script evaluated to false")
+	ErrVerifyFailed    = errors.New("verify failed")
+	ErrOpReturn        = errors.New("OP_RETURN executed")
+	ErrStackUnderflow  = errors.New("stack underflow")
+	ErrUnbalancedIf    = errors.New("unbalanced conditional")
+	ErrNotPushOnly     = errors.New("unlocking script is not push only")
+	ErrTooManyOps      = errors.New("too many operations")
+	ErrStackOverflow   = errors.New("stack size limit exceeded")
+	ErrElementTooLarge = errors.New("pushed element is too large")
+	ErrTimelock        = errors.New("timelock is not satisfied")
+)
+
+// Verify runs the unlocking script of an input and the locking script of
+// the output it spends, and for script hash outputs the redeem script
+// revealed by the unlocking script
+func Verify(unlock, lock []byte, checker Checker) error {
+	if !IsPushOnly(unlock) {
+		return ErrNotPushOnly
+	}
+	vm := engine{checker: checker}
+	if err := vm.run(unlock); err != nil {
+		return err
+	}
+	unlocked := append([][]byte{}, vm.stack...)
+
+	if err := vm.run(lock); err != nil {
+		return err
+	}
+	if err := vm.checkResult(); err != nil {
+		return err
+	}
+	if !IsPayToScriptHash(lock) {
+		return nil
+	}
+
+	if len(unlocked) == 0 {
+		return ErrStackUnderflow
+	}
+	redeem := unlocked[len(unlocked)-1]
+	vm = engine{checker: checker, stack: unlocked[:len(unlocked)-1]}
+	if err := vm.run(redeem); err != nil {
+		return fmt.Errorf("redeem script: %w", err)
+	}
+	return vm.checkResult()
+}
+
+type engine struct {
+	checker Checker
+	stack   [][]byte
+	cond    []bool // branches of the enclosing conditionals being executed
+	ops     int
+}
+
+func (vm *engine) executing() bool {
+	for _, branch := range vm.cond {
+		if !branch {
+			return false
+		}
+	}
+	return true
+}
+
+func (vm *engine) checkResult() error {
+	if len(vm.stack) == 0 || !asBool(vm.stack[len(vm.stack)-1]) {
+		return ErrEvalFalse
+	}
+	return nil
+}
+
+func (vm *engine) push(data []byte) error {
+	if len(data) > MaxElementSize {
+		return ErrElementTooLarge
+	}
+	if len(vm.stack) >= MaxStackSize {
+		return ErrStackOverflow
+	}
+	vm.stack = append(vm.stack, data)
+	return nil
+}
+
+func (vm *engine) pop() ([]byte, error) {
+	if len(vm.stack) == 0 {
+		return nil, ErrStackUnderflow
+	}
+	top := vm.stack[len(vm.stack)-1]
+	vm.stack = vm.stack[:len(vm.stack)-1]
+	return top, nil
+}
+
+func (vm *engine) peek(depth int) ([]byte, error) {
+	if depth >= len(vm.stack) {
+		return nil, ErrStackUnderflow
+	}
+	return vm.stack[len(vm.stack)-1-depth], nil
+}
+
+func (vm *engine) popNum(maxSize int) (int64, error) {
+	data, err := vm.pop()
+	if err != nil {
+		return 0, err
+	}
+	return decodeNum(data, maxSize)
+}
+
+func (vm *engine) run(script []byte) error {
+	instructions, err := parse(script)
+	if err != nil {
+		return err
+	}
+	vm.cond = nil
+	vm.ops = 0
+	for _, in := range instructions {
+		if err := vm.step(in); err != nil {
+			return fmt.Errorf("%s: %w", opcodeName(in.op), err)
+		}
+	}
+	if len(vm.cond) != 0 {
+		return ErrUnbalancedIf
+	}
+	return nil
+}
+
+func (vm *engine) step(in instruction) error {
+	if in.isPush() {
+		if len(in.data) > MaxElementSize {
+			return ErrElementTooLarge
+		}
+		if !vm.executing() {
+			return nil
+		}
+		return vm.push(in.pushed())
+	}
+
+	vm.ops++
+	if vm.ops > MaxOps {
+		return ErrTooManyOps
+	}
+
+	switch in.op {
+	case OP_IF, OP_NOTIF:
+		branch := false
+		if vm.executing() {
+			top, err := vm.pop()
+			if err != nil {
+				return err
+			}
+			branch = asBool(top) == (in.op == OP_IF)
+		}
+		vm.cond = append(vm.cond, branch)
+		return nil
+	case OP_ELSE:
+		if len(vm.cond) == 0 {
+			return ErrUnbalancedIf
+		}
+		vm.cond[len(vm.cond)-1] = !vm.cond[len(vm.cond)-1]
+		return nil
+	case OP_ENDIF:
+		if len(vm.cond) == 0 {
+			return ErrUnbalancedIf
+		}
+		vm.cond = vm.cond[:len(vm.cond)-1]
+		return nil
+	}
+	if !vm.executing() {
+		return nil
+	}
+
+	switch in.op {
+	case OP_NOP:
+	case OP_VERIFY:
+		top, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		if !asBool(top) {
+			return ErrVerifyFailed
+		}
+	case OP_RETURN:
+		return ErrOpReturn
+
+	case OP_DROP:
+		_, err := vm.pop()
+		return err
+	case OP_DUP, OP_OVER:
+		depth := 0
+		if in.op == OP_OVER {
+			depth = 1
+		}
+		data, err := vm.peek(depth)
+		if err != nil {
+			return err
+		}
+		return vm.push(data)
+	case OP_NIP, OP_SWAP:
+		top, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		second, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		if in.op == OP_SWAP {
+			vm.stack = append(vm.stack, top, second)
+		} else {
+			vm.stack = append(vm.stack, top)
+		}
+	case OP_SIZE:
+		top, err := vm.peek(0)
+		if err != nil {
+			return err
+		}
+		return vm.push(encodeNum(int64(len(top))))
+
+	case OP_EQUAL, OP_EQUALVERIFY:
+		a, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		b, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		return vm.pushResult(bytes.Equal(a, b), in.op == OP_EQUALVERIFY)
+
+	case OP_RIPEMD160, OP_SHA256, OP_HASH160, OP_HASH256:
+		data, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		return vm.push(hashOp(in.op, data))
+
+	case OP_CHECKSIG, OP_CHECKSIGVERIFY:
+		pubKey, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		signature, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		return vm.pushResult(vm.checker.CheckSig(signature, pubKey), in.op == OP_CHECKSIGVERIFY)
+	case OP_CHECKMULTISIG, OP_CHECKMULTISIGVERIFY:
+		valid, err := vm.checkMultisig()
+		if err != nil {
+			return err
+		}
+		return vm.pushResult(valid, in.op == OP_CHECKMULTISIGVERIFY)
+
+	case OP_CHECKLOCKTIMEVERIFY, OP_CHECKSEQUENCEVERIFY:
+		// the argument is left on the stack, like in Bitcoin
+		top, err := vm.peek(0)
+		if err != nil {
+			return err
+		}
+		n, err := decodeNum(top, 5)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return ErrTimelock
+		}
+		satisfied := vm.checker.CheckSequence(n)
+		if in.op == OP_CHECKLOCKTIMEVERIFY {
+			satisfied = vm.checker.CheckLockTime(n)
+		}
+		if !satisfied {
+			return ErrTimelock
+		}
+
+	default:
+		return errors.New("unknown opcode")
+	}
+	return nil
+}
+
+// pushResult pushes the result of a check, or fails the script on a false
+// result of its VERIFY form
+func (vm *engine) pushResult(result bool, verify bool) error {
+	if verify {
+		if !result {
+			return ErrVerifyFailed
+		}
+		return nil
+	}
+	if result {
+		return vm.push([]byte{1})
+	}
+	return vm.push(nil)
+}
+
+// checkMultisig pops <sig>... <m> <pubKey>... <n> and checks that the
+// signatures match m of the keys, in the order of the keys
+func (vm *engine) checkMultisig() (bool, error) {
+	n, err := vm.popNum(4)
+	if err != nil {
+		return false, err
+	}
+	if n < 1 || n > MaxMultisigKeys {
+		return false, fmt.Errorf("invalid number of keys %d", n)
+	}
+	vm.ops += int(n)
+	if vm.ops > MaxOps {
+		return false, ErrTooManyOps
+	}
+	pubKeys := make([][]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		if pubKeys[i], err = vm.pop(); err != nil {
+			return false, err
+		}
+	}
+	m, err := vm.popNum(4)
+	if err != nil {
+		return false, err
+	}
+	if m < 1 || m > n {
+		return false, fmt.Errorf("invalid number of signatures %d", m)
+	}
+	signatures := make([][]byte, m)
+	for i := m - 1; i >= 0; i-- {
+		if signatures[i], err = vm.pop(); err != nil {
+			return false, err
+		}
+	}
+
+	key := 0
+	for _, signature := range signatures {
+		for key < len(pubKeys) && !vm.checker.CheckSig(signature, pubKeys[key]) {
+			key++
+		}
+		if key == len(pubKeys) {
+			return false, nil
+		}
+		key++
+	}
+	return true, nil
+}
+
+func hashOp(op byte, data []byte) []byte {
+	switch op {
+	case OP_RIPEMD160:
+		hasher := ripemd160.New()
+		hasher.Write(data)
+		return hasher.Sum(nil)
+	case OP_SHA256:
+		hash := sha256.Sum256(data)
+		return hash[:]
+	case OP_HASH160:
+		return Hash160(data)
+	}
+	return hash256(data)
+}
+
+// asBool is false for empty data, zero and negative zero
+func asBool(data []byte) bool {
+	for i, b := range data {
+		if b != 0 {
+			return !(i == len(data)-1 && b == 0x80)
+		}
+	}
+	return false
+}
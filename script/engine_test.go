@@ -0,0 +1,189 @@
+package script
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+// testChecker accepts a signature made of "sig" followed by the public key,
+// and the lock time and sequence of the spending input it is given
+type testChecker struct {
+	lockTime int64
+	sequence int64
+}
+
+func (c testChecker) CheckSig(signature, pubKey []byte) bool {
+	return bytes.Equal(signature, append([]byte("sig"), pubKey...))
+}
+
+func (c testChecker) CheckLockTime(lockTime int64) bool {
+	return lockTime <= c.lockTime
+}
+
+func (c testChecker) CheckSequence(sequence int64) bool {
+	return sequence <= c.sequence
+}
+
+func sig(pubKey []byte) []byte {
+	return append([]byte("sig"), pubKey...)
+}
+
+func TestVerify(t *testing.T) {
+	pubKey := bytes.Repeat([]byte{2}, 33)
+	other := bytes.Repeat([]byte{3}, 33)
+
+	tests := []struct {
+		name   string
+		unlock []byte
+		lock   []byte
+		want   error
+	}{
+		{"true", nil, NewBuilder().AddInt(1).Script(), nil},
+		{"false", nil, NewBuilder().AddInt(0).Script(), ErrEvalFalse},
+		{"equal", NewBuilder().AddData([]byte("a")).Script(), NewBuilder().AddData([]byte("a")).AddOp(OP_EQUAL).Script(), nil},
+		{"equalverify fails", NewBuilder().AddData([]byte("a")).Script(),
+			NewBuilder().AddData([]byte("b")).AddOp(OP_EQUALVERIFY).AddInt(1).Script(), ErrVerifyFailed},
+		{"if branch", NewBuilder().AddInt(1).Script(),
+			NewBuilder().AddOp(OP_IF).AddInt(1).AddOp(OP_ELSE).AddInt(0).AddOp(OP_ENDIF).Script(), nil},
+		{"else branch", NewBuilder().AddInt(0).Script(),
+			NewBuilder().AddOp(OP_IF).AddInt(1).AddOp(OP_ELSE).AddInt(0).AddOp(OP_ENDIF).Script(), ErrEvalFalse},
+		{"unbalanced if", NewBuilder().AddInt(1).Script(), NewBuilder().AddOp(OP_IF).AddInt(1).Script(), ErrUnbalancedIf},
+		{"op return", nil, NullDataScript([]byte("data")), ErrOpReturn},
+		{"underflow", nil, NewBuilder().AddOp(OP_DROP).Script(), ErrStackUnderflow},
+		{"unlock not push only", NewBuilder().AddInt(1).AddOp(OP_DUP).Script(), NewBuilder().AddOp(OP_EQUAL).Script(), ErrNotPushOnly},
+		{"pubkey hash", PubKeyHashUnlock(sig(pubKey), pubKey), PayToPubKeyHash(Hash160(pubKey)), nil},
+		{"pubkey hash wrong key", PubKeyHashUnlock(sig(other), other), PayToPubKeyHash(Hash160(pubKey)), ErrVerifyFailed},
+		{"pubkey hash bad signature", PubKeyHashUnlock(sig(other), pubKey), PayToPubKeyHash(Hash160(pubKey)), ErrEvalFalse},
+	}
+	for _, test := range tests {
+		err := Verify(test.unlock, test.lock, testChecker{})
+		if !errors.Is(err, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.want)
+		}
+	}
+}
+
+func TestMultisig(t *testing.T) {
+	keys := [][]byte{bytes.Repeat([]byte{1}, 33), bytes.Repeat([]byte{2}, 33), bytes.Repeat([]byte{3}, 33)}
+	redeem := MultisigScript(2, keys)
+	lock := PayToScriptHash(Hash160(redeem))
+	unlock := func(signatures ...[]byte) []byte {
+		b := NewBuilder()
+		for _, signature := range signatures {
+			b.AddData(signature)
+		}
+		return b.AddData(redeem).Script()
+	}
+
+	if err := Verify(unlock(sig(keys[0]), sig(keys[2])), lock, testChecker{}); err != nil {
+		t.Errorf("2 of 3 signatures: %v", err)
+	}
+	if err := Verify(unlock(sig(keys[2]), sig(keys[0])), lock, testChecker{}); err == nil {
+		t.Error("signatures out of key order verify")
+	}
+	if err := Verify(unlock(sig(keys[1]), sig(keys[1])), lock, testChecker{}); err == nil {
+		t.Error("the same signature twice verifies")
+	}
+	if err := Verify(unlock(sig(keys[1])), lock, testChecker{}); err == nil {
+		t.Error("1 of 3 signatures verifies")
+	}
+
+	otherRedeem := MultisigScript(1, keys)
+	b := NewBuilder().AddData(sig(keys[0])).AddData(otherRedeem)
+	if err := Verify(b.Script(), lock, testChecker{}); err == nil {
+		t.Error("a redeem script of another hash verifies")
+	}
+
+	m, pubKeys, err := ExtractMultisig(redeem)
+	if err != nil || m != 2 || len(pubKeys) != 3 {
+		t.Errorf("ExtractMultisig = %d, %d keys, %v", m, len(pubKeys), err)
+	}
+	if class, hash := Classify(lock); class != ScriptHash || !bytes.Equal(hash, Hash160(redeem)) {
+		t.Errorf("Classify = %v %x", class, hash)
+	}
+}
+
+func TestTimelocks(t *testing.T) {
+	pubKey := bytes.Repeat([]byte{2}, 33)
+	unlock := PubKeyHashUnlock(sig(pubKey), pubKey)
+	cltv := WithTimelock(100, 0, PayToPubKeyHash(Hash160(pubKey)))
+	csv := WithTimelock(0, 10, PayToPubKeyHash(Hash160(pubKey)))
+
+	tests := []struct {
+		name    string
+		lock    []byte
+		checker testChecker
+		want    error
+	}{
+		{"cltv reached", cltv, testChecker{lockTime: 100}, nil},
+		{"cltv not reached", cltv, testChecker{lockTime: 99}, ErrTimelock},
+		{"csv reached", csv, testChecker{sequence: 10}, nil},
+		{"csv not reached", csv, testChecker{sequence: 9}, ErrTimelock},
+		{"negative lock", NewBuilder().AddInt(-1).AddOp(OP_CHECKLOCKTIMEVERIFY).Script(), testChecker{lockTime: 100}, ErrTimelock},
+	}
+	for _, test := range tests {
+		if err := Verify(unlock, test.lock, test.checker); !errors.Is(err, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.want)
+		}
+	}
+
+	lockTime, afterBlocks, inner := Timelock(WithTimelock(100, 10, PayToPubKeyHash(Hash160(pubKey))))
+	if lockTime != 100 || afterBlocks != 10 || !bytes.Equal(inner, PayToPubKeyHash(Hash160(pubKey))) {
+		t.Errorf("Timelock = %d, %d, %x", lockTime, afterBlocks, inner)
+	}
+	if class, _ := Classify(cltv); class != PubKeyHash {
+		t.Errorf("timelocked pubkey hash classified as %v", class)
+	}
+}
+
+func TestAtomicSwap(t *testing.T) {
+	recipient := bytes.Repeat([]byte{2}, 33)
+	sender := bytes.Repeat([]byte{3}, 33)
+	secret := bytes.Repeat([]byte{7}, SecretSize)
+	secretHash := sha256.Sum256(secret)
+	swap := AtomicSwap{secretHash[:], Hash160(recipient), Hash160(sender), 500}
+	contract := AtomicSwapContract(swap)
+	lock := PayToScriptHash(Hash160(contract))
+
+	extracted, err := ExtractAtomicSwap(contract)
+	if err != nil || !bytes.Equal(extracted.SecretHash, swap.SecretHash) || extracted.LockTime != swap.LockTime {
+		t.Fatalf("ExtractAtomicSwap = %v, %v", extracted, err)
+	}
+
+	redeem := AtomicSwapRedeem(sig(recipient), recipient, secret, contract)
+	if err := Verify(redeem, lock, testChecker{}); err != nil {
+		t.Errorf("redeem with the secret: %v", err)
+	}
+	if got := ExtractSecret(redeem, secretHash[:]); !bytes.Equal(got, secret) {
+		t.Errorf("ExtractSecret = %x", got)
+	}
+	wrongSecret := AtomicSwapRedeem(sig(recipient), recipient, bytes.Repeat([]byte{8}, SecretSize), contract)
+	if err := Verify(wrongSecret, lock, testChecker{}); err == nil {
+		t.Error("redeem with a wrong secret verifies")
+	}
+	if err := Verify(AtomicSwapRedeem(sig(sender), sender, secret, contract), lock, testChecker{}); err == nil {
+		t.Error("the sender redeems")
+	}
+
+	refund := AtomicSwapRefund(sig(sender), sender, contract)
+	if err := Verify(refund, lock, testChecker{lockTime: 499}); !errors.Is(err, ErrTimelock) {
+		t.Errorf("refund before the lock time: %v", err)
+	}
+	if err := Verify(refund, lock, testChecker{lockTime: 500}); err != nil {
+		t.Errorf("refund at the lock time: %v", err)
+	}
+}
+
+func TestNumEncoding(t *testing.T) {
+	for _, n := range []int64{0, 1, -1, 16, 127, 128, 255, 256, -255, 1 << 20, -(1 << 31) + 1} {
+		got, err := decodeNum(encodeNum(n), 5)
+		if err != nil || got != n {
+			t.Errorf("decodeNum(encodeNum(%d)) = %d, %v", n, got, err)
+		}
+	}
+	if _, err := decodeNum([]byte{1, 2, 3, 4, 5, 6}, 5); err == nil {
+		t.Error("decodeNum accepts a number larger than its limit")
+	}
+}
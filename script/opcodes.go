@@ -0,0 +1,93 @@
+package script
+
+import "fmt"
+
+// Opcodes keep the values they have in Bitcoin script, only a subset of
+// them is implemented
+const (
+	OP_0         = 0x00
+	OP_PUSHDATA1 = 0x4c
+	OP_PUSHDATA2 = 0x4d
+	OP_1NEGATE   = 0x4f
+	OP_1         = 0x51
+	OP_16        = 0x60
+
+	OP_NOP    = 0x61
+	OP_IF     = 0x63
+	OP_NOTIF  = 0x64
+	OP_ELSE   = 0x67
+	OP_ENDIF  = 0x68
+	OP_VERIFY = 0x69
+	OP_RETURN = 0x6a
+
+	OP_DROP = 0x75
+	OP_DUP  = 0x76
+	OP_NIP  = 0x77
+	OP_OVER = 0x78
+	OP_SWAP = 0x7c
+	OP_SIZE = 0x82
+
+	OP_EQUAL       = 0x87
+	OP_EQUALVERIFY = 0x88
+
+	OP_RIPEMD160 = 0xa6
+	OP_SHA256    = 0xa8
+	OP_HASH160   = 0xa9
+	OP_HASH256   = 0xaa
+
+	OP_CHECKSIG            = 0xac
+	OP_CHECKSIGVERIFY      = 0xad
+	OP_CHECKMULTISIG       = 0xae
+	OP_CHECKMULTISIGVERIFY = 0xaf
+
+	OP_CHECKLOCKTIMEVERIFY = 0xb1
+	OP_CHECKSEQUENCEVERIFY = 0xb2
+)
+
+var opcodeNames = map[byte]string{
+	OP_0:         "OP_0",
+	OP_PUSHDATA1: "OP_PUSHDATA1",
+	OP_PUSHDATA2: "OP_PUSHDATA2",
+	OP_1NEGATE:   "OP_1NEGATE",
+
+	OP_NOP:    "OP_NOP",
+	OP_IF:     "OP_IF",
+	OP_NOTIF:  "OP_NOTIF",
+	OP_ELSE:   "OP_ELSE",
+	OP_ENDIF:  "OP_ENDIF",
+	OP_VERIFY: "OP_VERIFY",
+	OP_RETURN: "OP_RETURN",
+
+	OP_DROP: "OP_DROP",
+	OP_DUP:  "OP_DUP",
+	OP_NIP:  "OP_NIP",
+	OP_OVER: "OP_OVER",
+	OP_SWAP: "OP_SWAP",
+	OP_SIZE: "OP_SIZE",
+
+	OP_EQUAL:       "OP_EQUAL",
+	OP_EQUALVERIFY: "OP_EQUALVERIFY",
+
+	OP_RIPEMD160: "OP_RIPEMD160",
+	OP_SHA256:    "OP_SHA256",
+	OP_HASH160:   "OP_HASH160",
+	OP_HASH256:   "OP_HASH256",
+
+	OP_CHECKSIG:            "OP_CHECKSIG",
+	OP_CHECKSIGVERIFY:      "OP_CHECKSIGVERIFY",
+	OP_CHECKMULTISIG:       "OP_CHECKMULTISIG",
+	OP_CHECKMULTISIGVERIFY: "OP_CHECKMULTISIGVERIFY",
+
+	OP_CHECKLOCKTIMEVERIFY: "OP_CHECKLOCKTIMEVERIFY",
+	OP_CHECKSEQUENCEVERIFY: "OP_CHECKSEQUENCEVERIFY",
+}
+
+func opcodeName(op byte) string {
+	if op >= OP_1 && op <= OP_16 {
+		return fmt.Sprintf("OP_%d", op-OP_1+1)
+	}
+	if name, ok := opcodeNames[op]; ok {
+		return name
+	}
+	return fmt.Sprintf("OP_UNKNOWN%d", op)
+}
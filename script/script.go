@@ -0,0 +1,430 @@
+package script
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ripemd160"
+)
+
+// Resource limits of scripts and their execution
+const (
+	MaxScriptSize   = 10000
+	MaxElementSize  = 1024 // a redeem script of MaxMultisigKeys keys fits in a single push
+	MaxOps          = 201  // opcodes other than data pushes executed per script
+	MaxStackSize    = 1000
+	MaxMultisigKeys = 15
+	MaxDataSize     = 80 // data carried by a null data output
+)
+
+var (
+	ErrScriptTooLarge = errors.New("script is too large")
+	ErrMalformedPush  = errors.New("script ends in the middle of a data push")
+)
+
+// instruction is an opcode together with the data it pushes
+type instruction struct {
+	op   byte
+	data []byte
+	end  int // offset of the next instruction in the script
+}
+
+func parse(script []byte) ([]instruction, error) {
+	if len(script) > MaxScriptSize {
+		return nil, ErrScriptTooLarge
+	}
+	var instructions []instruction
+	for i := 0; i < len(script); {
+		op := script[i]
+		i++
+		size := 0
+		switch {
+		case op > OP_0 && op < OP_PUSHDATA1:
+			size = int(op)
+		case op == OP_PUSHDATA1:
+			if i+1 > len(script) {
+				return nil, ErrMalformedPush
+			}
+			size = int(script[i])
+			i++
+		case op == OP_PUSHDATA2:
+			if i+2 > len(script) {
+				return nil, ErrMalformedPush
+			}
+			size = int(binary.LittleEndian.Uint16(script[i:]))
+			i += 2
+		}
+		if i+size > len(script) {
+			return nil, ErrMalformedPush
+		}
+		i += size
+		instructions = append(instructions, instruction{op, script[i-size : i], i})
+	}
+	return instructions, nil
+}
+
+// Validate checks that a script parses
+func Validate(script []byte) error {
+	_, err := parse(script)
+	return err
+}
+
+// isPush reports whether the instruction only pushes data or a small number
+func (in instruction) isPush() bool {
+	return in.op <= OP_PUSHDATA2 || in.op == OP_1NEGATE || (in.op >= OP_1 && in.op <= OP_16)
+}
+
+// IsPushOnly reports whether a script only pushes data, as unlocking
+// scripts must
+func IsPushOnly(script []byte) bool {
+	instructions, err := parse(script)
+	if err != nil {
+		return false
+	}
+	for _, in := range instructions {
+		if !in.isPush() {
+			return false
+		}
+	}
+	return true
+}
+
+// Pushes returns the data pushed by a push only script
+func Pushes(script []byte) ([][]byte, error) {
+	instructions, err := parse(script)
+	if err != nil {
+		return nil, err
+	}
+	var pushes [][]byte
+	for _, in := range instructions {
+		if !in.isPush() {
+			return nil, errors.New("script is not push only")
+		}
+		pushes = append(pushes, in.pushed())
+	}
+	return pushes, nil
+}
+
+// pushed is the stack element a push instruction leaves
+func (in instruction) pushed() []byte {
+	switch {
+	case in.op == OP_1NEGATE:
+		return encodeNum(-1)
+	case in.op >= OP_1 && in.op <= OP_16:
+		return encodeNum(int64(in.op - OP_1 + 1))
+	}
+	return in.data
+}
+
+// Builder assembles a script from opcodes and data
+type Builder struct {
+	script []byte
+}
+
+func NewBuilder() *Builder {
+	return &Builder{}
+}
+
+func (b *Builder) AddOp(op byte) *Builder {
+	b.script = append(b.script, op)
+	return b
+}
+
+// AddData pushes data with the smallest push opcode
+func (b *Builder) AddData(data []byte) *Builder {
+	switch {
+	case len(data) == 0:
+		b.script = append(b.script, OP_0)
+	case len(data) < OP_PUSHDATA1:
+		b.script = append(b.script, byte(len(data)))
+	case len(data) <= 0xff:
+		b.script = append(b.script, OP_PUSHDATA1, byte(len(data)))
+	default:
+		b.script = append(b.script, OP_PUSHDATA2, byte(len(data)), byte(len(data)>>8))
+	}
+	b.script = append(b.script, data...)
+	return b
+}
+
+// AddInt pushes a number, as a single opcode when it is small
+func (b *Builder) AddInt(n int64) *Builder {
+	switch {
+	case n == 0:
+		return b.AddOp(OP_0)
+	case n == -1:
+		return b.AddOp(OP_1NEGATE)
+	case n >= 1 && n <= 16:
+		return b.AddOp(byte(OP_1 + n - 1))
+	}
+	return b.AddData(encodeNum(n))
+}
+
+func (b *Builder) Script() []byte {
+	return b.script
+}
+
+// encodeNum encodes a number as a little endian sign and magnitude integer
+// like Bitcoin script numbers
+func encodeNum(n int64) []byte {
+	if n == 0 {
+		return nil
+	}
+	negative := n < 0
+	if negative {
+		n = -n
+	}
+	var data []byte
+	for n > 0 {
+		data = append(data, byte(n&0xff))
+		n >>= 8
+	}
+	if data[len(data)-1]&0x80 != 0 {
+		extra := byte(0)
+		if negative {
+			extra = 0x80
+		}
+		data = append(data, extra)
+	} else if negative {
+		data[len(data)-1] |= 0x80
+	}
+	return data
+}
+
+func decodeNum(data []byte, maxSize int) (int64, error) {
+	if len(data) > maxSize {
+		return 0, fmt.Errorf("number is longer than %d bytes", maxSize)
+	}
+	if len(data) == 0 {
+		return 0, nil
+	}
+	var n int64
+	for i, b := range data {
+		n |= int64(b) << (8 * i)
+	}
+	if data[len(data)-1]&0x80 != 0 {
+		n &^= int64(0x80) << (8 * (len(data) - 1))
+		return -n, nil
+	}
+	return n, nil
+}
+
+func Hash160(data []byte) []byte {
+	sha := sha256.Sum256(data)
+	hasher := ripemd160.New()
+	hasher.Write(sha[:])
+	return hasher.Sum(nil)
+}
+
+func hash256(data []byte) []byte {
+	first := sha256.Sum256(data)
+	second := sha256.Sum256(first[:])
+	return second[:]
+}
+
+// Disasm returns the human readable form of a script
+func Disasm(script []byte) string {
+	instructions, err := parse(script)
+	if err != nil {
+		return fmt.Sprintf("[invalid script %x]", script)
+	}
+	var parts []string
+	for _, in := range instructions {
+		if in.op > OP_0 && in.op <= OP_PUSHDATA2 {
+			parts = append(parts, hex.EncodeToString(in.data))
+			continue
+		}
+		parts = append(parts, opcodeName(in.op))
+	}
+	return strings.Join(parts, " ")
+}
+
+// Standard script templates
+
+type Class int
+
+const (
+	NonStandard Class = iota
+	PubKeyHash
+	ScriptHash
+	Multisig
+	HTLC // atomic swap contract
+	NullData
+)
+
+func (c Class) String() string {
+	return [...]string{"nonstandard", "pubkeyhash", "scripthash", "multisig", "htlc", "nulldata"}[c]
+}
+
+// PayToPubKeyHash locks an output to the key hashing to pubKeyHash:
+// OP_DUP OP_HASH160 <pubKeyHash> OP_EQUALVERIFY OP_CHECKSIG
+func PayToPubKeyHash(pubKeyHash []byte) []byte {
+	return NewBuilder().AddOp(OP_DUP).AddOp(OP_HASH160).AddData(pubKeyHash).
+		AddOp(OP_EQUALVERIFY).AddOp(OP_CHECKSIG).Script()
+}
+
+// PayToScriptHash locks an output to the redeem script hashing to
+// scriptHash, which the spender reveals: OP_HASH160 <scriptHash> OP_EQUAL
+func PayToScriptHash(scriptHash []byte) []byte {
+	return NewBuilder().AddOp(OP_HASH160).AddData(scriptHash).AddOp(OP_EQUAL).Script()
+}
+
+// MultisigScript requires m signatures of the public keys:
+// <m> <pubKey>... <n> OP_CHECKMULTISIG
+func MultisigScript(m int, pubKeys [][]byte) []byte {
+	b := NewBuilder().AddInt(int64(m))
+	for _, pubKey := range pubKeys {
+		b.AddData(pubKey)
+	}
+	return b.AddInt(int64(len(pubKeys))).AddOp(OP_CHECKMULTISIG).Script()
+}
+
+// PubKeyHashUnlock is the unlocking script of a pay-to-pubkey-hash output
+func PubKeyHashUnlock(signature, pubKey []byte) []byte {
+	return NewBuilder().AddData(signature).AddData(pubKey).Script()
+}
+
+// NullDataScript is the locking script of a provably unspendable output
+// carrying data: OP_RETURN <data>
+func NullDataScript(data []byte) []byte {
+	return NewBuilder().AddOp(OP_RETURN).AddData(data).Script()
+}
+
+// IsUnspendable reports whether a locking script fails as soon as it runs,
+// so that its output can be left out of the UTXO set
+func IsUnspendable(script []byte) bool {
+	return len(script) > 0 && script[0] == OP_RETURN
+}
+
+// ExtractNullData returns the data carried by a null data script
+func ExtractNullData(script []byte) ([]byte, error) {
+	instructions, err := parse(script)
+	if err != nil || len(instructions) != 2 || instructions[0].op != OP_RETURN || !instructions[1].isPush() {
+		return nil, errors.New("not a null data script")
+	}
+	data := instructions[1].pushed()
+	if len(data) > MaxDataSize {
+		return nil, fmt.Errorf("null data is larger than %d bytes", MaxDataSize)
+	}
+	return data, nil
+}
+
+// WithTimelock prefixes a locking script so that it can only be spent by a
+// transaction whose lock time reaches lockTime, and by inputs whose relative
+// lock time is at least afterBlocks. A zero lock is left out:
+// <lockTime> OP_CHECKLOCKTIMEVERIFY OP_DROP <afterBlocks> OP_CHECKSEQUENCEVERIFY OP_DROP <script>
+func WithTimelock(lockTime, afterBlocks int64, lockScript []byte) []byte {
+	b := NewBuilder()
+	if lockTime > 0 {
+		b.AddInt(lockTime).AddOp(OP_CHECKLOCKTIMEVERIFY).AddOp(OP_DROP)
+	}
+	if afterBlocks > 0 {
+		b.AddInt(afterBlocks).AddOp(OP_CHECKSEQUENCEVERIFY).AddOp(OP_DROP)
+	}
+	return append(b.Script(), lockScript...)
+}
+
+// Timelock returns the timelocks a script was prefixed with by WithTimelock
+// and the script they lock
+func Timelock(lockScript []byte) (lockTime, afterBlocks int64, inner []byte) {
+	instructions, err := parse(lockScript)
+	if err != nil {
+		return 0, 0, lockScript
+	}
+	start := 0
+	for i := 0; i+2 < len(instructions); i += 3 {
+		op := instructions[i+1].op
+		if !instructions[i].isPush() || instructions[i+2].op != OP_DROP ||
+			(op != OP_CHECKLOCKTIMEVERIFY && op != OP_CHECKSEQUENCEVERIFY) {
+			break
+		}
+		n, err := decodeNum(instructions[i].pushed(), 5)
+		if err != nil {
+			break
+		}
+		if op == OP_CHECKLOCKTIMEVERIFY {
+			lockTime = n
+		} else {
+			afterBlocks = n
+		}
+		start = instructions[i+2].end
+	}
+	return lockTime, afterBlocks, lockScript[start:]
+}
+
+// Classify recognizes the standard templates, possibly timelocked, and
+// returns the hash an address encodes for pubkey hash and script hash scripts
+func Classify(script []byte) (Class, []byte) {
+	_, _, script = Timelock(script)
+	instructions, err := parse(script)
+	if err != nil {
+		return NonStandard, nil
+	}
+	ops := func(want ...byte) bool {
+		if len(instructions) != len(want) {
+			return false
+		}
+		for i, op := range want {
+			if instructions[i].op != op {
+				return false
+			}
+		}
+		return true
+	}
+	switch {
+	case ops(OP_DUP, OP_HASH160, 20, OP_EQUALVERIFY, OP_CHECKSIG):
+		return PubKeyHash, instructions[2].data
+	case ops(OP_HASH160, 20, OP_EQUAL):
+		return ScriptHash, instructions[1].data
+	}
+	if _, _, err := ExtractMultisig(script); err == nil {
+		return Multisig, nil
+	}
+	if _, err := ExtractAtomicSwap(script); err == nil {
+		return HTLC, nil
+	}
+	if _, err := ExtractNullData(script); err == nil {
+		return NullData, nil
+	}
+	return NonStandard, nil
+}
+
+// ExtractMultisig returns the required signatures and public keys of a
+// multisig script
+func ExtractMultisig(script []byte) (int, [][]byte, error) {
+	errNotMultisig := errors.New("not a multisig script")
+	instructions, err := parse(script)
+	if err != nil || len(instructions) < 4 || instructions[len(instructions)-1].op != OP_CHECKMULTISIG {
+		return 0, nil, errNotMultisig
+	}
+	smallInt := func(in instruction) int {
+		if in.op >= OP_1 && in.op <= OP_16 {
+			return int(in.op-OP_1) + 1
+		}
+		return 0
+	}
+	m := smallInt(instructions[0])
+	n := smallInt(instructions[len(instructions)-2])
+	keys := instructions[1 : len(instructions)-2]
+	if m == 0 || n != len(keys) || m > n {
+		return 0, nil, errNotMultisig
+	}
+	var pubKeys [][]byte
+	for _, key := range keys {
+		if key.op == OP_0 || key.op > OP_PUSHDATA2 {
+			return 0, nil, errNotMultisig
+		}
+		pubKeys = append(pubKeys, key.data)
+	}
+	return m, pubKeys, nil
+}
+
+// IsPayToScriptHash reports whether a locking script is a script hash
+// template, whose redeem script is run on spending
+func IsPayToScriptHash(script []byte) bool {
+	class, _ := Classify(script)
+	return class == ScriptHash
+}
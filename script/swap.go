@@ -0,0 +1,96 @@
+package script
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+)
+
+// SecretSize is the size of the secret of an atomic swap contract
+const SecretSize = 32
+
+// AtomicSwap holds the terms of a hash time locked contract
+type AtomicSwap struct {
+	SecretHash    []byte // sha256 of the secret the recipient reveals to claim
+	RecipientHash []byte // pubkey hash of the recipient
+	RefundHash    []byte // pubkey hash of the sender, refunded after LockTime
+	LockTime      int64
+}
+
+// AtomicSwapContract is the redeem script of a hash time locked contract,
+// paying the recipient against the secret, or refunding the sender once
+// the lock time has passed:
+//
+//	OP_IF
+//	    OP_SIZE 32 OP_EQUALVERIFY OP_SHA256 <secretHash> OP_EQUALVERIFY
+//	    OP_DUP OP_HASH160 <recipientHash>
+//	OP_ELSE
+//	    <lockTime> OP_CHECKLOCKTIMEVERIFY OP_DROP
+//	    OP_DUP OP_HASH160 <refundHash>
+//	OP_ENDIF
+//	OP_EQUALVERIFY OP_CHECKSIG
+func AtomicSwapContract(swap AtomicSwap) []byte {
+	return NewBuilder().
+		AddOp(OP_IF).
+		AddOp(OP_SIZE).AddInt(SecretSize).AddOp(OP_EQUALVERIFY).
+		AddOp(OP_SHA256).AddData(swap.SecretHash).AddOp(OP_EQUALVERIFY).
+		AddOp(OP_DUP).AddOp(OP_HASH160).AddData(swap.RecipientHash).
+		AddOp(OP_ELSE).
+		AddInt(swap.LockTime).AddOp(OP_CHECKLOCKTIMEVERIFY).AddOp(OP_DROP).
+		AddOp(OP_DUP).AddOp(OP_HASH160).AddData(swap.RefundHash).
+		AddOp(OP_ENDIF).
+		AddOp(OP_EQUALVERIFY).AddOp(OP_CHECKSIG).Script()
+}
+
+// ExtractAtomicSwap returns the terms of an atomic swap contract
+func ExtractAtomicSwap(contract []byte) (*AtomicSwap, error) {
+	errNotSwap := errors.New("not an atomic swap contract")
+	instructions, err := parse(contract)
+	if err != nil || len(instructions) != 20 {
+		return nil, errNotSwap
+	}
+	lockTime, err := decodeNum(instructions[11].pushed(), 5)
+	if err != nil {
+		return nil, errNotSwap
+	}
+	swap := &AtomicSwap{
+		SecretHash:    instructions[5].data,
+		RecipientHash: instructions[9].data,
+		RefundHash:    instructions[16].data,
+		LockTime:      lockTime,
+	}
+	if len(swap.SecretHash) != sha256.Size || len(swap.RecipientHash) != 20 || len(swap.RefundHash) != 20 ||
+		swap.LockTime <= 0 || !bytes.Equal(AtomicSwapContract(*swap), contract) {
+		return nil, errNotSwap
+	}
+	return swap, nil
+}
+
+// AtomicSwapRedeem is the unlocking script claiming a script hash output of
+// an atomic swap contract with the secret
+func AtomicSwapRedeem(signature, pubKey, secret, contract []byte) []byte {
+	return NewBuilder().AddData(signature).AddData(pubKey).AddData(secret).
+		AddInt(1).AddData(contract).Script()
+}
+
+// AtomicSwapRefund is the unlocking script refunding a script hash output of
+// an atomic swap contract to its sender
+func AtomicSwapRefund(signature, pubKey, contract []byte) []byte {
+	return NewBuilder().AddData(signature).AddData(pubKey).AddInt(0).
+		AddData(contract).Script()
+}
+
+// ExtractSecret returns the secret revealed by an unlocking script claiming
+// an atomic swap contract whose secret hashes to secretHash, nil when it does
+// not reveal it
+func ExtractSecret(unlock []byte, secretHash []byte) []byte {
+	pushes, err := Pushes(unlock)
+	if err != nil || len(pushes) != 5 {
+		return nil
+	}
+	secret := pushes[2]
+	if hash := sha256.Sum256(secret); !bytes.Equal(hash[:], secretHash) {
+		return nil
+	}
+	return secret
+}
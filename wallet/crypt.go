@@ -0,0 +1,221 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/gob"
+	"errors"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// An encrypted wallet keeps its public data in the clear and seals the seed
+// and private keys with AES-GCM under a key derived from the passphrase
+// with scrypt. The derived key is never written anywhere: a command signing
+// with the wallet asks for the passphrase, and keeps the key in its memory
+// only for as long as it runs.
+
+var (
+	ErrWalletLocked    = errors.New("wallet is locked")
+	ErrWrongPassphrase = errors.New("wrong wallet passphrase")
+)
+
+var (
+	unlockMu sync.Mutex
+	unlocked = make(map[string][]byte) // node id -> key unsealing its wallet in this process
+)
+
+type CryptParams struct {
+	Salt []byte
+	N    int
+	R    int
+	P    int
+}
+
+type walletSecrets struct {
+	Seed []byte
+	Keys map[string][]byte // address -> private scalar
+}
+
+func (p CryptParams) deriveKey(passphrase string) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), p.Salt, p.N, p.R, p.P, 32)
+}
+
+func seal(key, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(key, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrWrongPassphrase
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return plaintext, nil
+}
+
+func (ws *Wallets) IsEncrypted() bool {
+	return ws.Crypt != nil
+}
+
+// Locked reports whether the private keys of an encrypted wallet are unavailable
+func (ws *Wallets) Locked() bool {
+	return ws.IsEncrypted() && ws.key == nil
+}
+
+// Encrypt protects the wallet with a passphrase, it is left locked
+func (ws *Wallets) Encrypt(passphrase string) error {
+	if ws.IsEncrypted() {
+		return errors.New("wallet is already encrypted")
+	}
+	if passphrase == "" {
+		return errors.New("passphrase must not be empty")
+	}
+	params := CryptParams{make([]byte, 16), 1 << 15, 8, 1}
+	if _, err := rand.Read(params.Salt); err != nil {
+		return err
+	}
+	key, err := params.deriveKey(passphrase)
+	if err != nil {
+		return err
+	}
+	ws.Crypt = &params
+	ws.key = key
+	if err := ws.sealSecrets(); err != nil {
+		return err
+	}
+	ws.Lock()
+	return nil
+}
+
+// Unlock makes the private keys of an encrypted wallet available
+func (ws *Wallets) Unlock(passphrase string) error {
+	if !ws.IsEncrypted() {
+		return errors.New("wallet is not encrypted")
+	}
+	key, err := ws.Crypt.deriveKey(passphrase)
+	if err != nil {
+		return err
+	}
+	return ws.unlockWithKey(key)
+}
+
+// Lock forgets the private keys of an encrypted wallet
+func (ws *Wallets) Lock() {
+	if !ws.IsEncrypted() {
+		return
+	}
+	ws.key = nil
+	ws.Seed = nil
+	for address, w := range ws.Wallets {
+		ws.Wallets[address] = &Wallet{PublicKey: w.PublicKey, Path: w.Path}
+	}
+}
+
+func (ws *Wallets) unlockWithKey(key []byte) error {
+	plaintext, err := open(key, ws.Secrets)
+	if err != nil {
+		return err
+	}
+	var secrets walletSecrets
+	if err := gob.NewDecoder(bytes.NewReader(plaintext)).Decode(&secrets); err != nil {
+		return err
+	}
+	ws.key = key
+	ws.Seed = secrets.Seed
+	for address, d := range secrets.Keys {
+		if w, ok := ws.Wallets[address]; ok {
+			w.PrivateKey = privateKeyFromBytes(d)
+		}
+	}
+	return nil
+}
+
+func (ws *Wallets) sealSecrets() error {
+	secrets := walletSecrets{ws.Seed, make(map[string][]byte)}
+	for address, w := range ws.Wallets {
+		if w.CanSign() {
+			secrets.Keys[address] = w.PrivateKey.D.FillBytes(make([]byte, 32))
+		}
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(secrets); err != nil {
+		return err
+	}
+	sealed, err := seal(ws.key, buf.Bytes())
+	if err != nil {
+		return err
+	}
+	ws.Secrets = sealed
+	return nil
+}
+
+// publicCopy returns the wallet as it is written to disk when encrypted
+func (ws *Wallets) publicCopy() *Wallets {
+	public := *ws
+	public.Seed = nil
+	public.Wallets = make(map[string]*Wallet)
+	for address, w := range ws.Wallets {
+		public.Wallets[address] = &Wallet{PublicKey: w.PublicKey, Path: w.Path}
+	}
+	return &public
+}
+
+// KeepUnlocked keeps the wallet of a node unlocked for the rest of the
+// process, so that the wallets loaded later, like the ones sealing blocks,
+// are unlocked too
+func (ws *Wallets) KeepUnlocked(nodeId string) error {
+	if !ws.IsEncrypted() {
+		return nil
+	}
+	if ws.Locked() {
+		return ErrWalletLocked
+	}
+	unlockMu.Lock()
+	defer unlockMu.Unlock()
+	unlocked[nodeId] = ws.key
+	return nil
+}
+
+func (ws *Wallets) loadUnlock(nodeId string) {
+	unlockMu.Lock()
+	key, ok := unlocked[nodeId]
+	unlockMu.Unlock()
+	if ok && ws.unlockWithKey(key) != nil {
+		ws.Lock()
+	}
+}
+
+func writePrivateFile(path string, data []byte) error {
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+	return os.Chmod(path, 0600)
+}
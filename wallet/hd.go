@@ -0,0 +1,259 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// HD key derivation follows BIP32 on the P-256 curve as specified by SLIP-0010
+
+const (
+	HardenedKeyStart = uint32(0x80000000)
+	ExternalChain    = uint32(0) // receiving addresses
+	InternalChain    = uint32(1) // change addresses
+
+	masterKeySalt = "Nist256p1 seed"
+)
+
+var (
+	privateVersion = []byte{0x04, 0x88, 0xad, 0xe4}
+	publicVersion  = []byte{0x04, 0x88, 0xb2, 0x1e}
+
+	ErrHardenedFromPublic = errors.New("cannot derive a hardened child from a public key")
+	ErrInvalidExtendedKey = errors.New("invalid extended key")
+)
+
+type ExtendedKey struct {
+	Key         []byte // 32 byte private scalar or 33 byte compressed public key
+	ChainCode   []byte
+	Depth       byte
+	ParentPrint []byte // first 4 bytes of the parent's key hash
+	ChildNum    uint32
+	Private     bool
+}
+
+func NewMaster(seed []byte) (*ExtendedKey, error) {
+	if len(seed) < 16 || len(seed) > 64 {
+		return nil, errors.New("seed must be between 16 and 64 bytes")
+	}
+	data := seed
+	for {
+		mac := hmac.New(sha512.New, []byte(masterKeySalt))
+		mac.Write(data)
+		sum := mac.Sum(nil)
+		if validScalar(sum[:32]) {
+			return &ExtendedKey{sum[:32], sum[32:], 0, []byte{0, 0, 0, 0}, 0, true}, nil
+		}
+		data = sum
+	}
+}
+
+func (k *ExtendedKey) pubKeyCompressed() []byte {
+	if !k.Private {
+		return k.Key
+	}
+	curve := elliptic.P256()
+	x, y := curve.ScalarBaseMult(k.Key)
+	return elliptic.MarshalCompressed(curve, x, y)
+}
+
+// Child derives the child key with index i, indexes from HardenedKeyStart
+// on are hardened and can only be derived from a private key
+func (k *ExtendedKey) Child(i uint32) (*ExtendedKey, error) {
+	hardened := i >= HardenedKeyStart
+	if hardened && !k.Private {
+		return nil, ErrHardenedFromPublic
+	}
+
+	var data []byte
+	if hardened {
+		data = append([]byte{0x00}, k.Key...)
+	} else {
+		data = k.pubKeyCompressed()
+	}
+	index := make([]byte, 4)
+	binary.BigEndian.PutUint32(index, i)
+	data = append(data, index...)
+
+	curve := elliptic.P256()
+	n := curve.Params().N
+	parentPrint := PubkeyHash(k.pubKeyCompressed())[:4]
+
+	for {
+		mac := hmac.New(sha512.New, k.ChainCode)
+		mac.Write(data)
+		sum := mac.Sum(nil)
+		il := new(big.Int).SetBytes(sum[:32])
+		chainCode := sum[32:]
+
+		if il.Cmp(n) < 0 {
+			if k.Private {
+				childKey := new(big.Int).Add(il, new(big.Int).SetBytes(k.Key))
+				childKey.Mod(childKey, n)
+				if childKey.Sign() != 0 {
+					return &ExtendedKey{childKey.FillBytes(make([]byte, 32)), chainCode, k.Depth + 1, parentPrint, i, true}, nil
+				}
+			} else {
+				px, py := elliptic.UnmarshalCompressed(curve, k.Key)
+				if px == nil {
+					return nil, ErrInvalidExtendedKey
+				}
+				ix, iy := curve.ScalarBaseMult(sum[:32])
+				cx, cy := curve.Add(ix, iy, px, py)
+				if cx.Sign() != 0 || cy.Sign() != 0 {
+					return &ExtendedKey{elliptic.MarshalCompressed(curve, cx, cy), chainCode, k.Depth + 1, parentPrint, i, false}, nil
+				}
+			}
+		}
+		data = append(append([]byte{0x01}, chainCode...), index...)
+	}
+}
+
+// Derive follows a path such as m/0'/0/5, where ' marks a hardened index
+func (k *ExtendedKey) Derive(path string) (*ExtendedKey, error) {
+	parts := strings.Split(path, "/")
+	if len(parts) == 0 || (parts[0] != "m" && parts[0] != "M") {
+		return nil, fmt.Errorf("invalid derivation path %q", path)
+	}
+	key := k
+	for _, part := range parts[1:] {
+		offset := uint32(0)
+		if strings.HasSuffix(part, "'") {
+			offset = HardenedKeyStart
+			part = strings.TrimSuffix(part, "'")
+		}
+		index, err := strconv.ParseUint(part, 10, 31)
+		if err != nil {
+			return nil, fmt.Errorf("invalid derivation path %q", path)
+		}
+		if key, err = key.Child(uint32(index) + offset); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// Neuter returns the extended public key, which can derive the public keys
+// of non-hardened children but cannot sign
+func (k *ExtendedKey) Neuter() *ExtendedKey {
+	if !k.Private {
+		return k
+	}
+	return &ExtendedKey{k.pubKeyCompressed(), k.ChainCode, k.Depth, k.ParentPrint, k.ChildNum, false}
+}
+
+func (k *ExtendedKey) PublicKey() []byte {
+	curve := elliptic.P256()
+	x, y := elliptic.UnmarshalCompressed(curve, k.pubKeyCompressed())
+	return pubKeyBytes(x, y)
+}
+
+func (k *ExtendedKey) PrivateKey() (ecdsa.PrivateKey, error) {
+	if !k.Private {
+		return ecdsa.PrivateKey{}, errors.New("extended key is public")
+	}
+	return privateKeyFromBytes(k.Key), nil
+}
+
+// Wallet returns the wallet holding the key pair of the extended key
+func (k *ExtendedKey) Wallet() (*Wallet, error) {
+	private, err := k.PrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	return &Wallet{PrivateKey: private, PublicKey: k.PublicKey()}, nil
+}
+
+func (k *ExtendedKey) Address() []byte {
+	return Wallet{PublicKey: k.PublicKey()}.Address()
+}
+
+func (k *ExtendedKey) String() string {
+	var buf bytes.Buffer
+	if k.Private {
+		buf.Write(privateVersion)
+	} else {
+		buf.Write(publicVersion)
+	}
+	buf.WriteByte(k.Depth)
+	buf.Write(k.ParentPrint)
+	binary.Write(&buf, binary.BigEndian, k.ChildNum)
+	buf.Write(k.ChainCode)
+	if k.Private {
+		buf.WriteByte(0x00)
+	}
+	buf.Write(k.Key)
+
+	payload := buf.Bytes()
+	return string(Base58Encode(append(payload, CheckSum(payload)...)))
+}
+
+func ParseExtendedKey(s string) (*ExtendedKey, error) {
+	data, err := base58Decode(s)
+	if err != nil || len(data) != 82 {
+		return nil, ErrInvalidExtendedKey
+	}
+	payload, checksum := data[:78], data[78:]
+	if !bytes.Equal(CheckSum(payload), checksum) {
+		return nil, ErrInvalidExtendedKey
+	}
+
+	k := &ExtendedKey{
+		Depth:       payload[4],
+		ParentPrint: payload[5:9],
+		ChildNum:    binary.BigEndian.Uint32(payload[9:13]),
+		ChainCode:   payload[13:45],
+	}
+	switch {
+	case bytes.Equal(payload[:4], privateVersion) && payload[45] == 0x00:
+		k.Private = true
+		k.Key = payload[46:]
+		if !validScalar(k.Key) {
+			return nil, ErrInvalidExtendedKey
+		}
+	case bytes.Equal(payload[:4], publicVersion):
+		k.Key = payload[45:]
+		if x, _ := elliptic.UnmarshalCompressed(elliptic.P256(), k.Key); x == nil {
+			return nil, ErrInvalidExtendedKey
+		}
+	default:
+		return nil, ErrInvalidExtendedKey
+	}
+	return k, nil
+}
+
+// AccountPath returns the path of an account, whose external and internal
+// chains hold the receiving and change keys
+func AccountPath(account uint32) string {
+	return fmt.Sprintf("m/%d'", account)
+}
+
+func KeyPath(account, chain, index uint32) string {
+	return fmt.Sprintf("%s/%d/%d", AccountPath(account), chain, index)
+}
+
+// DeriveAddress derives the address at chain/index below an account's
+// extended public key, for watch-only use
+func DeriveAddress(xpub string, chain, index uint32) (string, error) {
+	key, err := ParseExtendedKey(xpub)
+	if err != nil {
+		return "", err
+	}
+	key = key.Neuter()
+	if key, err = key.Child(chain); err != nil {
+		return "", err
+	}
+	if key, err = key.Child(index); err != nil {
+		return "", err
+	}
+	return string(key.Address()), nil
+}
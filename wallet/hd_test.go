@@ -0,0 +1,194 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func fromHex(t *testing.T, s string) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// SLIP-0010 test vectors for the nist256p1 curve
+func TestSLIP10Vectors(t *testing.T) {
+	tests := []struct {
+		seed        string
+		path        string
+		fingerprint string
+		chainCode   string
+		private     string
+		public      string
+	}{
+		{"000102030405060708090a0b0c0d0e0f", "m", "00000000",
+			"beeb672fe4621673f722f38529c07392fecaa61015c80c34f29ce8b41b3cb6ea",
+			"612091aaa12e22dd2abef664f8a01a82cae99ad7441b7ef8110424915c268bc2",
+			"0266874dc6ade47b3ecd096745ca09bcd29638dd52c2c12117b11ed3e458cfa9e8"},
+		{"000102030405060708090a0b0c0d0e0f", "m/0'", "be6105b5",
+			"3460cea53e6a6bb5fb391eeef3237ffd8724bf0a40e94943c98b83825342ee11",
+			"6939694369114c67917a182c59ddb8cafc3004e63ca5d3b84403ba8613debc0c",
+			"0384610f5ecffe8fda089363a41f56a5c7ffc1d81b59a612d0d649b2d22355590c"},
+		{"000102030405060708090a0b0c0d0e0f", "m/0'/1", "9b02312f",
+			"4187afff1aafa8445010097fb99d23aee9f599450c7bd140b6826ac22ba21d0c",
+			"284e9d38d07d21e4e281b645089a94f4cf5a5a81369acf151a1c3a57f18b2129",
+			"03526c63f8d0b4bbbf9c80df553fe66742df4676b241dabefdef67733e070f6844"},
+		{"000102030405060708090a0b0c0d0e0f", "m/0'/1/2'", "b98005c1",
+			"98c7514f562e64e74170cc3cf304ee1ce54d6b6da4f880f313e8204c2a185318",
+			"694596e8a54f252c960eb771a3c41e7e32496d03b954aeb90f61635b8e092aa7",
+			"0359cf160040778a4b14c5f4d7b76e327ccc8c4a6086dd9451b7482b5a4972dda0"},
+		{"000102030405060708090a0b0c0d0e0f", "m/0'/1/2'/2", "0e9f3274",
+			"ba96f776a5c3907d7fd48bde5620ee374d4acfd540378476019eab70790c63a0",
+			"5996c37fd3dd2679039b23ed6f70b506c6b56b3cb5e424681fb0fa64caf82aaa",
+			"029f871f4cb9e1c97f9f4de9ccd0d4a2f2a171110c61178f84430062230833ff20"},
+		{"000102030405060708090a0b0c0d0e0f", "m/0'/1/2'/2/1000000000", "8b2b5c4b",
+			"b9b7b82d326bb9cb5b5b121066feea4eb93d5241103c9e7a18aad40f1dde8059",
+			"21c4f269ef0a5fd1badf47eeacebeeaa3de22eb8e5b0adcd0f27dd99d34d0119",
+			"02216cd26d31147f72427a453c443ed2cde8a1e53c9cc44e5ddf739725413fe3f4"},
+		// derivation retry
+		{"000102030405060708090a0b0c0d0e0f", "m/28578'", "be6105b5",
+			"e94c8ebe30c2250a14713212f6449b20f3329105ea15b652ca5bdfc68f6c65c2",
+			"06f0db126f023755d0b8d86d4591718a5210dd8d024e3e14b6159d63f53aa669",
+			"02519b5554a4872e8c9c1c847115363051ec43e93400e030ba3c36b52a3e70a5b7"},
+		{"000102030405060708090a0b0c0d0e0f", "m/28578'/33941", "3e2b7bc6",
+			"9e87fe95031f14736774cd82f25fd885065cb7c358c1edf813c72af535e83071",
+			"092154eed4af83e078ff9b84322015aefe5769e31270f62c3f66c33888335f3a",
+			"0235bfee614c0d5b2cae260000bb1d0d84b270099ad790022c1ae0b2e782efe120"},
+		// seed retry
+		{"a7305bc8df8d0951f0cb224c0e95d7707cbdf2c6ce7e8d481fec69c7ff5e9446", "m", "00000000",
+			"7762f9729fed06121fd13f326884c82f59aa95c57ac492ce8c9654e60efd130c",
+			"3b8c18469a4634517d6d0b65448f8e6c62091b45540a1743c5846be55d47d88f",
+			"0383619fadcde31063d8c5cb00dbfe1713f3e6fa169d8541a798752a1c1ca0cb20"},
+	}
+	for _, test := range tests {
+		master, err := NewMaster(fromHex(t, test.seed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, err := master.Derive(test.path)
+		if err != nil {
+			t.Fatalf("%s: %v", test.path, err)
+		}
+		if got := hex.EncodeToString(key.ParentPrint); got != test.fingerprint {
+			t.Errorf("%s: fingerprint %s, want %s", test.path, got, test.fingerprint)
+		}
+		if got := hex.EncodeToString(key.ChainCode); got != test.chainCode {
+			t.Errorf("%s: chain code %s, want %s", test.path, got, test.chainCode)
+		}
+		if got := hex.EncodeToString(key.Key); got != test.private {
+			t.Errorf("%s: private key %s, want %s", test.path, got, test.private)
+		}
+		if got := hex.EncodeToString(key.Neuter().Key); got != test.public {
+			t.Errorf("%s: public key %s, want %s", test.path, got, test.public)
+		}
+	}
+}
+
+func TestPublicDerivation(t *testing.T) {
+	master, err := NewMaster(fromHex(t, "000102030405060708090a0b0c0d0e0f"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	account, err := master.Derive(AccountPath(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	private, err := master.Derive(KeyPath(0, ExternalChain, 7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	address, err := DeriveAddress(account.Neuter().String(), ExternalChain, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if address != string(private.Address()) {
+		t.Errorf("address derived from the xpub %s, want %s", address, private.Address())
+	}
+	if _, err := account.Neuter().Child(HardenedKeyStart); !errors.Is(err, ErrHardenedFromPublic) {
+		t.Errorf("hardened child of a public key: %v", err)
+	}
+}
+
+func TestParseExtendedKey(t *testing.T) {
+	master, err := NewMaster(fromHex(t, "000102030405060708090a0b0c0d0e0f"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []*ExtendedKey{master, master.Neuter()} {
+		parsed, err := ParseExtendedKey(key.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed.String() != key.String() || parsed.Private != key.Private {
+			t.Errorf("ParseExtendedKey(%s) = %s", key, parsed)
+		}
+	}
+
+	// private keys of zero and of the curve order are not keys
+	for _, scalar := range []string{
+		"0000000000000000000000000000000000000000000000000000000000000000",
+		"ffffffff00000000ffffffffffffffffbce6faada7179e84f3b9cac2fc632551",
+	} {
+		key := &ExtendedKey{fromHex(t, scalar), master.ChainCode, 0, []byte{0, 0, 0, 0}, 0, true}
+		if _, err := ParseExtendedKey(key.String()); !errors.Is(err, ErrInvalidExtendedKey) {
+			t.Errorf("ParseExtendedKey of scalar %s: %v", scalar, err)
+		}
+	}
+
+	tampered := []byte(master.String())
+	tampered[len(tampered)-1] ^= 1
+	if _, err := ParseExtendedKey(string(tampered)); err == nil {
+		t.Error("ParseExtendedKey accepts a bad checksum")
+	}
+}
+
+func TestPrivateKeyEncoding(t *testing.T) {
+	w := MakeWallet()
+	decoded, err := DecodePrivateKey(EncodePrivateKey(w.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.D.Cmp(w.PrivateKey.D) != 0 {
+		t.Error("decoded private key differs")
+	}
+
+	for _, scalar := range []string{
+		"0000000000000000000000000000000000000000000000000000000000000000",
+		"ffffffff00000000ffffffffffffffffbce6faada7179e84f3b9cac2fc632551",
+	} {
+		payload := append([]byte{privateKeyVersion}, fromHex(t, scalar)...)
+		encoded := string(Base58Encode(append(payload, CheckSum(payload)...)))
+		if _, err := DecodePrivateKey(encoded); !errors.Is(err, ErrInvalidPrivateKey) {
+			t.Errorf("DecodePrivateKey of scalar %s: %v", scalar, err)
+		}
+	}
+}
+
+func TestMultisigPolicy(t *testing.T) {
+	var keys [][]byte
+	for i := 0; i < 3; i++ {
+		keys = append(keys, MakeWallet().PublicKey)
+	}
+	policy, err := NewMultisigPolicy(2, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseMultisigPolicy(policy.Script())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.M != 2 || !bytes.Equal(parsed.Hash(), policy.Hash()) {
+		t.Errorf("parsed policy %v, want %v", parsed, policy)
+	}
+	if !IsScriptAddress(string(policy.Address())) || !ValidateAddress(string(policy.Address())) {
+		t.Errorf("policy address %s is not a valid script address", policy.Address())
+	}
+	if _, err := NewMultisigPolicy(4, keys); err == nil {
+		t.Error("a policy needing more signatures than keys is accepted")
+	}
+}
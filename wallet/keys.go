@@ -0,0 +1,84 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"errors"
+)
+
+const privateKeyVersion = byte(0x80)
+
+var ErrInvalidPrivateKey = errors.New("invalid private key")
+
+// EncodePrivateKey encodes a private key as Base58 with a version byte and
+// checksum, the same way addresses are encoded
+func EncodePrivateKey(private ecdsa.PrivateKey) string {
+	payload := append([]byte{privateKeyVersion}, private.D.FillBytes(make([]byte, 32))...)
+	return string(Base58Encode(append(payload, CheckSum(payload)...)))
+}
+
+func DecodePrivateKey(encoded string) (ecdsa.PrivateKey, error) {
+	data, err := base58Decode(encoded)
+	if err != nil || len(data) != 1+32+checkSumLength || data[0] != privateKeyVersion {
+		return ecdsa.PrivateKey{}, ErrInvalidPrivateKey
+	}
+	payload, checksum := data[:33], data[33:]
+	if !bytes.Equal(CheckSum(payload), checksum) || !validScalar(payload[1:]) {
+		return ecdsa.PrivateKey{}, ErrInvalidPrivateKey
+	}
+	return privateKeyFromBytes(payload[1:]), nil
+}
+
+// DumpPrivKey returns the encoded private key of a wallet address
+func (ws *Wallets) DumpPrivKey(address string) (string, error) {
+	w, ok := ws.Wallets[address]
+	if !ok {
+		return "", errors.New("address is not in the wallet")
+	}
+	if ws.Locked() {
+		return "", ErrWalletLocked
+	}
+	if !w.CanSign() {
+		return "", errors.New("private key of the address is not available")
+	}
+	return EncodePrivateKey(w.PrivateKey), nil
+}
+
+// ImportPrivKey adds an encoded private key to the wallet and returns its address
+func (ws *Wallets) ImportPrivKey(encoded string) (string, error) {
+	if ws.Locked() {
+		return "", ErrWalletLocked
+	}
+	private, err := DecodePrivateKey(encoded)
+	if err != nil {
+		return "", err
+	}
+	wallet := &Wallet{private, pubKeyBytes(private.X, private.Y), ""}
+	address := string(wallet.Address())
+	ws.Wallets[address] = wallet
+	delete(ws.WatchOnly, address)
+	return address, nil
+}
+
+// ImportAddress tracks an address whose key the wallet does not hold
+func (ws *Wallets) ImportAddress(address string) error {
+	if !ValidateAddress(address) {
+		return errors.New("address is not valid")
+	}
+	if _, ok := ws.Wallets[address]; ok {
+		return errors.New("address is already in the wallet")
+	}
+	if ws.WatchOnly == nil {
+		ws.WatchOnly = make(map[string]bool)
+	}
+	ws.WatchOnly[address] = true
+	return nil
+}
+
+func (ws *Wallets) GetWatchOnlyAddresses() []string {
+	var addresses []string
+	for address := range ws.WatchOnly {
+		addresses = append(addresses, address)
+	}
+	return addresses
+}
@@ -0,0 +1,73 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// BIP39 test vectors, whose seeds use the passphrase TREZOR
+func TestBIP39Vectors(t *testing.T) {
+	tests := []struct {
+		entropy  string
+		mnemonic string
+		seed     string
+	}{
+		{"00000000000000000000000000000000",
+			"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+			"c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04"},
+		{"7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f",
+			"legal winner thank year wave sausage worth useful legal winner thank yellow",
+			"2e8905819b8723fe2c1d161860e5ee1830318dbf49a83bd451cfb8440c28bd6fa457fe1296106559a3c80937a1c1069be3a3a5bd381ee6260e8d9739fce1f607"},
+		{"80808080808080808080808080808080",
+			"letter advice cage absurd amount doctor acoustic avoid letter advice cage above",
+			"d71de856f81a8acc65e6fc851a38d4d7ec216fd0796d0a6827a3ad6ed5511a30fa280f12eb2e47ed2ac03b5c462a0358d18d69fe4f985ec81778c1b370b652a8"},
+		{"ffffffffffffffffffffffffffffffff",
+			"zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo wrong",
+			"ac27495480225222079d7be181583751e86f571027b0497b5b5d11218e0a8a13332572917f0f8e5a589620c6f15b11c61dee327651a14c34e18231052e48c069"},
+		{"0000000000000000000000000000000000000000000000000000000000000000",
+			"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon art",
+			"bda85446c68413707090a52022edd26a1c9462295029f2e60cd7c4f2bbd3097170af7a4d73245cafa9c3cca8d561a7c3de6f5d4a10be8ed2a5e608d68f92fcc8"},
+		{"9e885d952ad362caeb4efe34a8e91bd2",
+			"ozone drill grab fiber curtain grace pudding thank cruise elder eight picnic",
+			"274ddc525802f7c828d8ef7ddbcdc5304e87ac3535913611fbbfa986d0c9e5476c91689f9c8a54fd55bd38606aa6a8595ad213d4c9c9f9aca3fb217069a41028"},
+	}
+	for _, test := range tests {
+		mnemonic, err := NewMnemonic(fromHex(t, test.entropy))
+		if err != nil || mnemonic != test.mnemonic {
+			t.Errorf("NewMnemonic(%s) = %q, %v", test.entropy, mnemonic, err)
+		}
+		entropy, err := MnemonicToEntropy(test.mnemonic)
+		if err != nil || hex.EncodeToString(entropy) != test.entropy {
+			t.Errorf("MnemonicToEntropy(%q) = %x, %v", test.mnemonic, entropy, err)
+		}
+		seed, err := MnemonicSeed(test.mnemonic, "TREZOR")
+		if err != nil || hex.EncodeToString(seed) != test.seed {
+			t.Errorf("MnemonicSeed(%q) = %x, %v", test.mnemonic, seed, err)
+		}
+	}
+}
+
+func TestInvalidMnemonic(t *testing.T) {
+	valid := "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+	for _, mnemonic := range []string{
+		strings.Replace(valid, "about", "abandon", 1), // bad checksum
+		strings.Replace(valid, "about", "notaword", 1),
+		"abandon abandon about",
+	} {
+		if _, err := MnemonicToEntropy(mnemonic); !errors.Is(err, ErrInvalidMnemonic) {
+			t.Errorf("MnemonicToEntropy(%q): %v", mnemonic, err)
+		}
+		if _, err := MnemonicSeed(mnemonic, ""); err == nil {
+			t.Errorf("MnemonicSeed(%q) accepts an invalid mnemonic", mnemonic)
+		}
+	}
+
+	// words are case insensitive and may be separated by any whitespace
+	seed, _ := MnemonicSeed(valid, "")
+	loose, err := MnemonicSeed(" "+strings.ToUpper(strings.ReplaceAll(valid, " ", "\t "))+"\n", "")
+	if err != nil || hex.EncodeToString(loose) != hex.EncodeToString(seed) {
+		t.Errorf("loosely written mnemonic gives seed %x, %v", loose, err)
+	}
+}
@@ -0,0 +1,80 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"fmt"
+	"math/big"
+
+	"github.com/Harshjha3006/golang-blockchain/script"
+)
+
+// MultisigPolicy requires M signatures of the N public keys to spend.
+// Outputs are locked to the hash of its multisig script, which spenders
+// reveal as the redeem script.
+type MultisigPolicy struct {
+	M       int
+	PubKeys [][]byte
+}
+
+func NewMultisigPolicy(m int, pubKeys [][]byte) (*MultisigPolicy, error) {
+	if len(pubKeys) == 0 || len(pubKeys) > script.MaxMultisigKeys {
+		return nil, fmt.Errorf("multisig needs 1 to %d public keys", script.MaxMultisigKeys)
+	}
+	if m < 1 || m > len(pubKeys) {
+		return nil, fmt.Errorf("required signatures must be between 1 and %d", len(pubKeys))
+	}
+	curve := elliptic.P256()
+	for i, pubKey := range pubKeys {
+		if len(pubKey) != 64 {
+			return nil, fmt.Errorf("public key %d is not 64 bytes", i+1)
+		}
+		x, y := new(big.Int).SetBytes(pubKey[:32]), new(big.Int).SetBytes(pubKey[32:])
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("public key %d is not a valid key", i+1)
+		}
+		for _, other := range pubKeys[:i] {
+			if bytes.Equal(pubKey, other) {
+				return nil, fmt.Errorf("public key %d is repeated", i+1)
+			}
+		}
+	}
+	return &MultisigPolicy{m, pubKeys}, nil
+}
+
+// Script is the redeem script of the policy
+func (p *MultisigPolicy) Script() []byte {
+	return script.MultisigScript(p.M, p.PubKeys)
+}
+
+// ParseMultisigPolicy returns the policy of a multisig redeem script
+func ParseMultisigPolicy(redeemScript []byte) (*MultisigPolicy, error) {
+	m, pubKeys, err := script.ExtractMultisig(redeemScript)
+	if err != nil {
+		return nil, err
+	}
+	return NewMultisigPolicy(m, pubKeys)
+}
+
+// Hash is the hash outputs locked to the policy commit to
+func (p *MultisigPolicy) Hash() []byte {
+	return script.Hash160(p.Script())
+}
+
+func (p *MultisigPolicy) Address() []byte {
+	return ScriptAddress(p.Script())
+}
+
+// KeyIndex returns the position of a public key in the policy, or -1
+func (p *MultisigPolicy) KeyIndex(pubKey []byte) int {
+	for i, key := range p.PubKeys {
+		if bytes.Equal(key, pubKey) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (p *MultisigPolicy) String() string {
+	return fmt.Sprintf("%d-of-%d", p.M, len(p.PubKeys))
+}
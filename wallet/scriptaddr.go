@@ -0,0 +1,50 @@
+package wallet
+
+import (
+	"errors"
+
+	"github.com/Harshjha3006/golang-blockchain/script"
+)
+
+// scriptVersion is the version byte of pay-to-script-hash addresses, whose
+// outputs are locked to the hash of a redeem script the spender reveals
+const scriptVersion = byte(0x05)
+
+func ScriptAddress(redeemScript []byte) []byte {
+	return ScriptAddressFromHash(script.Hash160(redeemScript))
+}
+
+func ScriptAddressFromHash(hash []byte) []byte {
+	versionedHash := append([]byte{scriptVersion}, hash...)
+	return Base58Encode(append(versionedHash, CheckSum(versionedHash)...))
+}
+
+// IsScriptAddress reports whether a valid address is a pay-to-script-hash address
+func IsScriptAddress(address string) bool {
+	data, err := base58Decode(address)
+	return err == nil && len(data) > 0 && data[0] == scriptVersion && ValidateAddress(address)
+}
+
+// AddScript adds the address of a redeem script to the wallet and returns it
+func (ws *Wallets) AddScript(redeemScript []byte) (string, error) {
+	if err := script.Validate(redeemScript); err != nil {
+		return "", err
+	}
+	if len(redeemScript) > script.MaxElementSize {
+		return "", errors.New("redeem script is too large to be revealed")
+	}
+	if ws.Scripts == nil {
+		ws.Scripts = make(map[string][]byte)
+	}
+	address := string(ScriptAddress(redeemScript))
+	ws.Scripts[address] = redeemScript
+	return address, nil
+}
+
+func (ws *Wallets) GetScriptAddresses() []string {
+	var addresses []string
+	for address := range ws.Scripts {
+		addresses = append(addresses, address)
+	}
+	return addresses
+}
@@ -11,6 +11,10 @@ func Base58Encode(input []byte) []byte {
 	return []byte(encode)
 }
 
+func base58Decode(input string) ([]byte, error) {
+	return base58.Decode(input)
+}
+
 func Base58Decode(input []byte) []byte {
 	decode, err := base58.Decode(string(input))
 	if err != nil {
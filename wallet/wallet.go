@@ -6,7 +6,9 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/gob"
 	"log"
+	"math/big"
 
 	"golang.org/x/crypto/ripemd160"
 )
@@ -19,6 +21,15 @@ const (
 type Wallet struct {
 	PrivateKey ecdsa.PrivateKey
 	PublicKey  []byte
+	Path       string // HD derivation path, empty for keys generated on their own
+}
+
+// walletData is the stored form of a Wallet, the curve of an
+// ecdsa.PrivateKey cannot be gob encoded
+type walletData struct {
+	D         []byte
+	PublicKey []byte
+	Path      string
 }
 
 func NewKeyPair() (ecdsa.PrivateKey, []byte) {
@@ -26,26 +37,77 @@ func NewKeyPair() (ecdsa.PrivateKey, []byte) {
 	if err != nil {
 		log.Panic(err)
 	}
-	pub := append(private.X.Bytes(), private.Y.Bytes()...)
+	pub := pubKeyBytes(private.X, private.Y)
 	return *private, pub
 }
 
+// pubKeyBytes encodes a public key as X || Y, each padded to 32 bytes
+func pubKeyBytes(x, y *big.Int) []byte {
+	return append(x.FillBytes(make([]byte, 32)), y.FillBytes(make([]byte, 32))...)
+}
+
+// validScalar tells whether d is a private key of the curve, 0 < d < N
+func validScalar(d []byte) bool {
+	key := new(big.Int).SetBytes(d)
+	return key.Sign() > 0 && key.Cmp(elliptic.P256().Params().N) < 0
+}
+
+func privateKeyFromBytes(d []byte) ecdsa.PrivateKey {
+	curve := elliptic.P256()
+	private := ecdsa.PrivateKey{D: new(big.Int).SetBytes(d)}
+	private.Curve = curve
+	private.X, private.Y = curve.ScalarBaseMult(d)
+	return private
+}
+
+func (w Wallet) GobEncode() ([]byte, error) {
+	var buf bytes.Buffer
+	data := walletData{nil, w.PublicKey, w.Path}
+	if w.PrivateKey.D != nil {
+		data.D = w.PrivateKey.D.FillBytes(make([]byte, 32))
+	}
+	err := gob.NewEncoder(&buf).Encode(data)
+	return buf.Bytes(), err
+}
+
+func (w *Wallet) GobDecode(content []byte) error {
+	var data walletData
+	if err := gob.NewDecoder(bytes.NewReader(content)).Decode(&data); err != nil {
+		return err
+	}
+	if data.D != nil {
+		w.PrivateKey = privateKeyFromBytes(data.D)
+	}
+	w.PublicKey = data.PublicKey
+	w.Path = data.Path
+	return nil
+}
+
 func MakeWallet() *Wallet {
 	private, pub := NewKeyPair()
-	wallet := &Wallet{private, pub}
+	wallet := &Wallet{private, pub, ""}
 	return wallet
 }
 
+// CanSign reports whether the private key of the wallet is available
+func (w Wallet) CanSign() bool {
+	return w.PrivateKey.D != nil
+}
+
 func (w Wallet) Address() []byte {
 	// getting pubkeyHash
 	pubHash := PubkeyHash(w.PublicKey)
+	return AddressFromPubKeyHash(pubHash)
+}
+
+// AddressFromPubKeyHash encodes a pubkey hash as an address
+func AddressFromPubKeyHash(pubHash []byte) []byte {
 	versionedHash := append([]byte{version}, pubHash...)
 	checksum := CheckSum(versionedHash)
 
 	fullHash := append(versionedHash, checksum...)
 	address := Base58Encode(fullHash)
 	return address
-
 }
 func PubkeyHash(pubkey []byte) []byte {
 	sha256Hash := sha256.Sum256(pubkey)
@@ -59,12 +121,18 @@ func PubkeyHash(pubkey []byte) []byte {
 }
 
 func ValidateAddress(address string) bool {
-	fullHash := Base58Decode([]byte(address))
-	version := fullHash[0]
+	fullHash, err := base58Decode(address)
+	if err != nil || len(fullHash) <= 1+checkSumLength {
+		return false
+	}
+	addressVersion := fullHash[0]
 	actualCheckSum := fullHash[len(fullHash)-checkSumLength:]
 	pubKeyHash := fullHash[1 : len(fullHash)-checkSumLength]
+	if (addressVersion != version && addressVersion != scriptVersion) || len(pubKeyHash) != 20 {
+		return false
+	}
 
-	versionedHash := append([]byte{version}, pubKeyHash...)
+	versionedHash := append([]byte{addressVersion}, pubKeyHash...)
 	genCheckSum := CheckSum(versionedHash)
 	return bytes.Equal(actualCheckSum, genCheckSum)
 }
@@ -2,17 +2,28 @@ package wallet
 
 import (
 	"bytes"
-	"crypto/elliptic"
+	"crypto/rand"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"log"
+	"math/big"
 	"os"
 )
 
 const walletFile = "./tmp/wallets_%s.data"
 
 type Wallets struct {
-	Wallets map[string]*Wallet
+	Wallets   map[string]*Wallet
+	Seed      []byte            // HD seed every derived key comes from, the only secret to back up
+	Account   uint32            // account the keys are derived under
+	NextIndex [2]uint32         // next unused index of the external and internal chains
+	WatchOnly map[string]bool   // addresses tracked without their private key
+	Scripts   map[string][]byte // redeem scripts of script addresses
+
+	Crypt   *CryptParams // set when the wallet is encrypted
+	Secrets []byte       // sealed seed and private keys of an encrypted wallet
+	key     []byte       // key unsealing Secrets, nil while locked
 }
 
 func CreateWallets(nodeId string) (*Wallets, error) {
@@ -21,11 +32,113 @@ func CreateWallets(nodeId string) (*Wallets, error) {
 	err := wallets.loadFile(nodeId)
 	return &wallets, err
 }
-func (ws *Wallets) AddWallet() string {
-	wallet := MakeWallet()
+
+// AddWallet derives the next unused receiving key of the wallet
+func (ws *Wallets) AddWallet() (string, error) {
+	return ws.deriveNext(ExternalChain)
+}
+
+// AddChangeWallet derives the next unused change key of the wallet
+func (ws *Wallets) AddChangeWallet() (string, error) {
+	return ws.deriveNext(InternalChain)
+}
+
+func (ws *Wallets) deriveNext(chain uint32) (string, error) {
+	if ws.Locked() {
+		return "", ErrWalletLocked
+	}
+	if ws.Seed == nil {
+		seed := make([]byte, 32)
+		if _, err := rand.Read(seed); err != nil {
+			return "", err
+		}
+		ws.Seed = seed
+	}
+	key, err := ws.deriveKey(chain, ws.NextIndex[chain])
+	if err != nil {
+		return "", err
+	}
+	wallet, err := key.Wallet()
+	if err != nil {
+		return "", err
+	}
+	ws.NextIndex[chain]++
+	wallet.Path = KeyPath(ws.Account, chain, key.ChildNum)
 	address := string(wallet.Address())
 	ws.Wallets[address] = wallet
-	return address
+	return address, nil
+}
+
+// SetSeed makes the wallet an HD wallet derived from seed. It fails if the
+// wallet already has a seed, so an existing backup is never replaced.
+func (ws *Wallets) SetSeed(seed []byte) error {
+	if ws.Locked() {
+		return ErrWalletLocked
+	}
+	if ws.Seed != nil {
+		return errors.New("wallet already has an HD seed")
+	}
+	if _, err := NewMaster(seed); err != nil {
+		return err
+	}
+	ws.Seed = seed
+	ws.NextIndex = [2]uint32{}
+	return nil
+}
+
+// DiscoverKeys derives the keys of both chains until gapLimit consecutive
+// keys are unused, adds the used ones to the wallet and returns how many
+// were found. It is how a wallet restored from its seed finds its funds.
+func (ws *Wallets) DiscoverKeys(isUsed func(pubKeyHash []byte) bool, gapLimit uint32) (int, error) {
+	found := 0
+	for _, chain := range []uint32{ExternalChain, InternalChain} {
+		for index, unused := uint32(0), uint32(0); unused < gapLimit; index++ {
+			key, err := ws.deriveKey(chain, index)
+			if err != nil {
+				return found, err
+			}
+			if !isUsed(PubkeyHash(key.PublicKey())) {
+				unused++
+				continue
+			}
+			unused = 0
+			for ws.NextIndex[chain] <= index {
+				if _, err := ws.deriveNext(chain); err != nil {
+					return found, err
+				}
+			}
+			found++
+		}
+	}
+	return found, nil
+}
+
+func (ws *Wallets) deriveKey(chain, index uint32) (*ExtendedKey, error) {
+	master, err := NewMaster(ws.Seed)
+	if err != nil {
+		return nil, err
+	}
+	return master.Derive(KeyPath(ws.Account, chain, index))
+}
+
+// AccountXPub returns the extended public key of the wallet's account, from
+// which a watch-only wallet can derive every receiving and change address
+func (ws *Wallets) AccountXPub() (string, error) {
+	if ws.Locked() {
+		return "", ErrWalletLocked
+	}
+	if ws.Seed == nil {
+		return "", errors.New("wallet has no HD seed")
+	}
+	master, err := NewMaster(ws.Seed)
+	if err != nil {
+		return "", err
+	}
+	account, err := master.Derive(AccountPath(ws.Account))
+	if err != nil {
+		return "", err
+	}
+	return account.Neuter().String(), nil
 }
 
 func (ws *Wallets) GetWallet(address string) Wallet {
@@ -49,32 +162,77 @@ func (ws *Wallets) loadFile(nodeId string) error {
 	if err != nil {
 		log.Panic(err)
 	}
-	gob.Register(elliptic.P256())
 	decoder := gob.NewDecoder(bytes.NewReader(content))
 	err = decoder.Decode(&wallets)
 
 	if err != nil {
-		log.Panic(err)
+		legacy, legacyErr := decodeLegacyWallets(content)
+		if legacyErr != nil {
+			log.Panic(err)
+		}
+		wallets = Wallets{Wallets: legacy}
+		wallets.SaveFile(nodeId)
 	}
 
-	ws.Wallets = wallets.Wallets
+	*ws = wallets
+	if ws.IsEncrypted() {
+		ws.Lock()
+		// earlier versions kept the wallet unlocked between commands with this file
+		os.Remove(fmt.Sprintf("./tmp/wallets_%s.unlock", nodeId))
+		ws.loadUnlock(nodeId)
+	}
 	return nil
 
 }
 
+// legacyWallet is a key as wallet files stored it before keys were encoded as
+// their scalar, a gob of the whole ecdsa.PrivateKey and its curve. Only the
+// scalar is read back, the curve is always P-256.
+type legacyWallet struct {
+	PrivateKey struct{ D *big.Int }
+	PublicKey  []byte
+}
+
+// decodeLegacyWallets reads the keys of a wallet file in the legacy format
+func decodeLegacyWallets(content []byte) (map[string]*Wallet, error) {
+	var legacy struct {
+		Wallets map[string]*legacyWallet
+	}
+	if err := gob.NewDecoder(bytes.NewReader(content)).Decode(&legacy); err != nil {
+		return nil, err
+	}
+	wallets := make(map[string]*Wallet)
+	for address, w := range legacy.Wallets {
+		d := w.PrivateKey.D
+		if d == nil || !validScalar(d.Bytes()) {
+			return nil, fmt.Errorf("legacy wallet %s has an invalid private key", address)
+		}
+		wallets[address] = &Wallet{privateKeyFromBytes(d.FillBytes(make([]byte, 32))), w.PublicKey, ""}
+	}
+	return wallets, nil
+}
+
 func (ws *Wallets) SaveFile(nodeId string) {
 	var buf bytes.Buffer
 
 	walletFile := fmt.Sprintf(walletFile, nodeId)
-	gob.Register(elliptic.P256())
 	encoder := gob.NewEncoder(&buf)
 
-	err := encoder.Encode(ws)
+	stored := ws
+	if ws.IsEncrypted() {
+		if !ws.Locked() {
+			if err := ws.sealSecrets(); err != nil {
+				log.Panic(err)
+			}
+		}
+		stored = ws.publicCopy()
+	}
+	err := encoder.Encode(stored)
 
 	if err != nil {
 		log.Panic(err)
 	}
-	err = os.WriteFile(walletFile, buf.Bytes(), 0644)
+	err = writePrivateFile(walletFile, buf.Bytes())
 
 	if err != nil {
 		log.Panic(err)
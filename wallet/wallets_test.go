@@ -0,0 +1,222 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestWallets() *Wallets {
+	return &Wallets{Wallets: make(map[string]*Wallet)}
+}
+
+func TestHDWallet(t *testing.T) {
+	mnemonic := "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+	seed, err := MnemonicSeed(mnemonic, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws := newTestWallets()
+	if err := ws.SetSeed(seed); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.SetSeed(seed); err == nil {
+		t.Error("SetSeed replaces an existing seed")
+	}
+
+	first, err := ws.AddWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := ws.AddWallet()
+	change, _ := ws.AddChangeWallet()
+	if first == second || first == change {
+		t.Errorf("derived addresses repeat: %s %s %s", first, second, change)
+	}
+	if ws.NextIndex != [2]uint32{2, 1} {
+		t.Errorf("NextIndex = %v", ws.NextIndex)
+	}
+	if path := ws.Wallets[change].Path; path != KeyPath(0, InternalChain, 0) {
+		t.Errorf("change key path %s", path)
+	}
+
+	// a wallet restored from the same seed finds the used keys
+	used := map[string]bool{
+		string(PubkeyHash(ws.Wallets[second].PublicKey)): true,
+		string(PubkeyHash(ws.Wallets[change].PublicKey)): true,
+	}
+	restored := newTestWallets()
+	if err := restored.SetSeed(seed); err != nil {
+		t.Fatal(err)
+	}
+	found, err := restored.DiscoverKeys(func(pubKeyHash []byte) bool { return used[string(pubKeyHash)] }, 5)
+	if err != nil || found != 2 {
+		t.Fatalf("DiscoverKeys = %d, %v", found, err)
+	}
+	for _, address := range []string{first, second, change} {
+		if _, ok := restored.Wallets[address]; !ok {
+			t.Errorf("restored wallet misses %s", address)
+		}
+	}
+
+	xpub, err := ws.AccountXPub()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if address, err := DeriveAddress(xpub, ExternalChain, 1); err != nil || address != second {
+		t.Errorf("address derived from the account xpub %s, %v, want %s", address, err, second)
+	}
+}
+
+func TestEncryptedWallet(t *testing.T) {
+	ws := newTestWallets()
+	address, err := ws.AddWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	private := ws.Wallets[address].PrivateKey.D
+
+	if err := ws.Encrypt("passphrase"); err != nil {
+		t.Fatal(err)
+	}
+	if !ws.Locked() || ws.Seed != nil || ws.Wallets[address].CanSign() {
+		t.Fatal("encrypted wallet is left unlocked")
+	}
+	if _, err := ws.AddWallet(); !errors.Is(err, ErrWalletLocked) {
+		t.Errorf("AddWallet on a locked wallet: %v", err)
+	}
+	if _, err := ws.AccountXPub(); !errors.Is(err, ErrWalletLocked) {
+		t.Errorf("AccountXPub on a locked wallet: %v", err)
+	}
+	if err := ws.SetSeed(make([]byte, 32)); !errors.Is(err, ErrWalletLocked) {
+		t.Errorf("SetSeed on a locked wallet: %v", err)
+	}
+
+	if err := ws.Unlock("wrong"); err == nil {
+		t.Error("wallet unlocks with a wrong passphrase")
+	}
+	if err := ws.Unlock("passphrase"); err != nil {
+		t.Fatal(err)
+	}
+	if ws.Locked() || ws.Wallets[address].PrivateKey.D.Cmp(private) != 0 {
+		t.Error("unlocking does not restore the private key")
+	}
+	if _, err := ws.AddWallet(); err != nil {
+		t.Errorf("AddWallet on an unlocked wallet: %v", err)
+	}
+}
+
+func TestKeepUnlocked(t *testing.T) {
+	inTempDir(t)
+	ws := newTestWallets()
+	address, err := ws.AddWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.Encrypt("passphrase"); err != nil {
+		t.Fatal(err)
+	}
+	ws.SaveFile("keep")
+
+	loaded, err := CreateWallets("keep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Locked() {
+		t.Fatal("an encrypted wallet is loaded unlocked")
+	}
+	if err := loaded.KeepUnlocked("keep"); !errors.Is(err, ErrWalletLocked) {
+		t.Errorf("KeepUnlocked on a locked wallet: %v", err)
+	}
+	if err := loaded.Unlock("passphrase"); err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.KeepUnlocked("keep"); err != nil {
+		t.Fatal(err)
+	}
+	defer delete(unlocked, "keep")
+
+	// wallets loaded later in the process are unlocked, the file is not
+	again, err := CreateWallets("keep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Locked() || !again.Wallets[address].CanSign() {
+		t.Error("a wallet kept unlocked is loaded locked")
+	}
+	delete(unlocked, "keep")
+	if locked, _ := CreateWallets("keep"); !locked.Locked() {
+		t.Error("the wallet file keeps the wallet unlocked")
+	}
+}
+
+// inTempDir runs the rest of a test in a new directory holding the tmp
+// directory wallet files are written to
+func inTempDir(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "tmp"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+// testdata/wallets_legacy.data was written by createwallet before keys were
+// stored as their scalar
+func TestLegacyWalletFile(t *testing.T) {
+	content, err := os.ReadFile("testdata/wallets_legacy.data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inTempDir(t)
+	if err := os.WriteFile(filepath.Join("tmp", "wallets_legacy.data"), content, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ws, err := CreateWallets("legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addresses := []string{"166fXy9V4LstjGYauzHCjPB3B3EGSX7zTg", "1Ppij4fpK7zD6eLKYGRStv14v6469S9ftn"}
+	if len(ws.Wallets) != len(addresses) {
+		t.Fatalf("read %d keys, want %d", len(ws.Wallets), len(addresses))
+	}
+	for _, address := range addresses {
+		w, ok := ws.Wallets[address]
+		if !ok {
+			t.Fatalf("legacy key %s is missing", address)
+		}
+		if string(w.Address()) != address || !w.CanSign() {
+			t.Errorf("legacy key %s read as %s", address, w.Address())
+		}
+		public := append(w.PrivateKey.X.Bytes(), w.PrivateKey.Y.Bytes()...)
+		if !bytes.Equal(public, w.PublicKey) {
+			t.Errorf("private key of %s does not match its public key", address)
+		}
+	}
+
+	// the file is migrated to the current format
+	migrated, err := os.ReadFile(filepath.Join("tmp", "wallets_legacy.data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored Wallets
+	if err := gob.NewDecoder(bytes.NewReader(migrated)).Decode(&stored); err != nil {
+		t.Fatalf("migrated wallet file: %v", err)
+	}
+	for _, address := range addresses {
+		if w := stored.Wallets[address]; w == nil || w.PrivateKey.D.Cmp(ws.Wallets[address].PrivateKey.D) != 0 {
+			t.Errorf("migrated wallet file lost the key of %s", address)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package walletdb
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/Harshjha3006/golang-blockchain/blockchain"
+	"github.com/Harshjha3006/golang-blockchain/wallet"
+)
+
+const dbFile = "./tmp/walletdb_%s.data"
+
+// Output is an output paying to one of the wallet's addresses
+type Output struct {
+	TxId    []byte
+	Index   int
+	Address string
+	Value   int
+	Height  int
+	SpentBy []byte // id of the spending transaction, nil while unspent
+}
+
+// TxRecord is a transaction that pays to or spends from the wallet
+type TxRecord struct {
+	TxId      []byte
+	BlockHash []byte
+	Height    int
+	Position  int // position of the transaction in its block
+	Timestamp int64
+	Coinbase  bool
+	Received  int // value paid to wallet addresses
+	Sent      int // value of wallet outputs spent
+}
+
+func (r *TxRecord) Net() int {
+	return r.Received - r.Sent
+}
+
+// WalletDB tracks the outputs and transactions of a wallet's addresses. It
+// follows the main chain block by block, so it can be kept up to date by
+// subscribing it to a chain or by calling Sync.
+type WalletDB struct {
+	Addresses  map[string]string    // pubkey hash in hex -> owned or watched address
+	Labels     map[string]string    // address -> label
+	Outputs    map[string]*Output   // outpoint -> output paying to the wallet
+	Txs        map[string]*TxRecord // txid in hex -> transaction of the wallet
+	BestHeight int
+	BestHash   []byte // last block applied, nil before genesis
+
+	nodeId string
+	mu     sync.Mutex
+}
+
+func Load(nodeId string) (*WalletDB, error) {
+	db := &WalletDB{
+		Addresses:  make(map[string]string),
+		Labels:     make(map[string]string),
+		Outputs:    make(map[string]*Output),
+		Txs:        make(map[string]*TxRecord),
+		BestHeight: -1,
+	}
+	db.nodeId = nodeId
+
+	data, err := os.ReadFile(fmt.Sprintf(dbFile, nodeId))
+	if os.IsNotExist(err) {
+		return db, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(db); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+func (db *WalletDB) Save() error {
+	var content bytes.Buffer
+	if err := gob.NewEncoder(&content).Encode(db); err != nil {
+		return err
+	}
+	file := fmt.Sprintf(dbFile, db.nodeId)
+	if err := os.WriteFile(file, content.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Chmod(file, 0600)
+}
+
+// Open loads the wallet database of a node, adds the wallet's addresses and
+// catches up with the chain
+func Open(nodeId string, wallets *wallet.Wallets, chain *blockchain.BlockChain) (*WalletDB, error) {
+	db, err := Load(nodeId)
+	if err != nil {
+		return nil, err
+	}
+	db.AddAddresses(wallets)
+	if err := db.Sync(chain); err != nil {
+		return nil, err
+	}
+	return db, db.Save()
+}
+
+// AddAddresses starts tracking the wallet's addresses. Their earlier
+// transactions are only found by a Rescan.
+func (db *WalletDB) AddAddresses(wallets *wallet.Wallets) []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var added []string
+	addresses := append(wallets.GetAllAddresses(), wallets.GetWatchOnlyAddresses()...)
+	addresses = append(addresses, wallets.GetScriptAddresses()...)
+	for _, address := range addresses {
+		key := hex.EncodeToString(pubKeyHash(address))
+		if _, ok := db.Addresses[key]; !ok {
+			db.Addresses[key] = address
+			added = append(added, address)
+		}
+	}
+	return added
+}
+
+func pubKeyHash(address string) []byte {
+	data := wallet.Base58Decode([]byte(address))
+	return data[1 : len(data)-4]
+}
+
+func outpointKey(txId []byte, index int) string {
+	return fmt.Sprintf("%x:%d", txId, index)
+}
+
+// Sync disconnects the blocks that left the main chain since the last sync
+// and connects the blocks added since
+func (db *WalletDB) Sync(chain *blockchain.BlockChain) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for db.BestHash != nil {
+		block, err := chain.GetBlockByHeight(db.BestHeight)
+		if err == nil && bytes.Equal(block.Hash, db.BestHash) {
+			break
+		}
+		stale, err := chain.GetBlock(db.BestHash)
+		if err != nil {
+			return err
+		}
+		db.disconnectBlock(&stale)
+	}
+
+	iter := chain.IteratorFrom(db.BestHeight + 1)
+	for {
+		block := iter.Next()
+		if block == nil {
+			break
+		}
+		db.connectBlock(block)
+	}
+	return nil
+}
+
+// Rescan forgets every transaction and rebuilds the database from genesis
+func (db *WalletDB) Rescan(chain *blockchain.BlockChain) error {
+	db.mu.Lock()
+	db.Outputs = make(map[string]*Output)
+	db.Txs = make(map[string]*TxRecord)
+	db.BestHeight = -1
+	db.BestHash = nil
+	db.mu.Unlock()
+	return db.Sync(chain)
+}
+
+func (db *WalletDB) BlockConnected(block *blockchain.Block) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if !bytes.Equal(block.PrevHash, db.BestHash) {
+		return
+	}
+	db.connectBlock(block)
+	if err := db.Save(); err != nil {
+		log.Panic(err)
+	}
+}
+
+func (db *WalletDB) BlockDisconnected(block *blockchain.Block) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if !bytes.Equal(block.Hash, db.BestHash) {
+		return
+	}
+	db.disconnectBlock(block)
+	if err := db.Save(); err != nil {
+		log.Panic(err)
+	}
+}
+
+func (db *WalletDB) connectBlock(block *blockchain.Block) {
+	for pos, tx := range block.Transactions {
+		record := &TxRecord{tx.Id, block.Hash, block.Height, pos, block.Timstamp, tx.IsCoinbase(), 0, 0}
+
+		if !tx.IsCoinbase() {
+			for _, in := range tx.Inputs {
+				out, ok := db.Outputs[outpointKey(in.Id, in.OutIndex)]
+				if ok && out.SpentBy == nil {
+					out.SpentBy = tx.Id
+					record.Sent += out.Value
+				}
+			}
+		}
+		for idx, out := range tx.Outputs {
+			address, ok := db.Addresses[hex.EncodeToString(out.AddressHash())]
+			if !ok {
+				continue
+			}
+			db.Outputs[outpointKey(tx.Id, idx)] = &Output{tx.Id, idx, address, out.Value, block.Height, nil}
+			record.Received += out.Value
+		}
+
+		if record.Received > 0 || record.Sent > 0 {
+			db.Txs[hex.EncodeToString(tx.Id)] = record
+		}
+	}
+	db.BestHeight = block.Height
+	db.BestHash = block.Hash
+}
+
+func (db *WalletDB) disconnectBlock(block *blockchain.Block) {
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		tx := block.Transactions[i]
+		for idx := range tx.Outputs {
+			delete(db.Outputs, outpointKey(tx.Id, idx))
+		}
+		if !tx.IsCoinbase() {
+			for _, in := range tx.Inputs {
+				out, ok := db.Outputs[outpointKey(in.Id, in.OutIndex)]
+				if ok && bytes.Equal(out.SpentBy, tx.Id) {
+					out.SpentBy = nil
+				}
+			}
+		}
+		delete(db.Txs, hex.EncodeToString(tx.Id))
+	}
+	db.BestHeight = block.Height - 1
+	db.BestHash = block.PrevHash
+	if len(db.BestHash) == 0 {
+		db.BestHash = nil
+	}
+}
+
+func (db *WalletDB) Confirmations(height int) int {
+	return db.BestHeight - height + 1
+}
+
+// Transactions returns the wallet's transactions, oldest first
+func (db *WalletDB) Transactions() []*TxRecord {
+	var records []*TxRecord
+	for _, record := range db.Txs {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Height != records[j].Height {
+			return records[i].Height < records[j].Height
+		}
+		return records[i].Position < records[j].Position
+	})
+	return records
+}
+
+// Unspent returns the unspent outputs with at least minConf confirmations
+func (db *WalletDB) Unspent(minConf int) []*Output {
+	var outputs []*Output
+	for _, out := range db.Outputs {
+		if out.SpentBy == nil && db.Confirmations(out.Height) >= minConf {
+			outputs = append(outputs, out)
+		}
+	}
+	sort.Slice(outputs, func(i, j int) bool {
+		if outputs[i].Height != outputs[j].Height {
+			return outputs[i].Height < outputs[j].Height
+		}
+		return outpointKey(outputs[i].TxId, outputs[i].Index) < outpointKey(outputs[j].TxId, outputs[j].Index)
+	})
+	return outputs
+}
+
+func (db *WalletDB) Balance(minConf int) int {
+	balance := 0
+	for _, out := range db.Unspent(minConf) {
+		balance += out.Value
+	}
+	return balance
+}
+
+// LabelBalances sums the unspent outputs by the label of their address,
+// addresses without a label count under ""
+func (db *WalletDB) LabelBalances(minConf int) map[string]int {
+	balances := make(map[string]int)
+	for _, out := range db.Unspent(minConf) {
+		balances[db.Labels[out.Address]] += out.Value
+	}
+	return balances
+}
+
+func (db *WalletDB) SetLabel(address, label string) error {
+	if _, ok := db.Addresses[hex.EncodeToString(pubKeyHash(address))]; !ok {
+		return errors.New("address is not in the wallet")
+	}
+	if label == "" {
+		delete(db.Labels, address)
+	} else {
+		db.Labels[address] = label
+	}
+	return nil
+}